@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"os/exec"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/gomodule/redigo/redis"
 	"golang.org/x/term"
+
+	"github.com/fgm/drupal_redis_stats/stats"
 )
 
 type PasswordReader interface {
@@ -25,11 +31,11 @@ func (prf passwordReaderFunc) ReadPassword(fd int) ([]byte, error) {
 
 var ErrInvalidUTF8 = errors.New("input was not a valid UTF-8 string")
 
-func getPasswordFromCLI(w io.Writer, pr PasswordReader, fd int) (string, error) {
-	fmt.Fprint(w, "Password: ")
+// readPassword obtains a password from any PasswordReader, validating it.
+func readPassword(pr PasswordReader, fd int) (string, error) {
 	pBytes, err := pr.ReadPassword(fd)
 	if err != nil {
-		return "", fmt.Errorf("failed acquiring password from terminal without echo: %w", err)
+		return "", err
 	}
 	if !utf8.Valid(pBytes) {
 		return "", fmt.Errorf("invalid password: %w", ErrInvalidUTF8)
@@ -37,17 +43,97 @@ func getPasswordFromCLI(w io.Writer, pr PasswordReader, fd int) (string, error)
 	return string(pBytes), nil
 }
 
+func getPasswordFromCLI(w io.Writer, pr PasswordReader, fd int) (string, error) {
+	fmt.Fprint(w, "Password: ")
+	pass, err := readPassword(pr, fd)
+	if err != nil && !errors.Is(err, ErrInvalidUTF8) {
+		return "", fmt.Errorf("failed acquiring password from terminal without echo: %w", err)
+	}
+	return pass, err
+}
+
+// trimNewline removes a single trailing line ending, as added by editors and
+// helper commands, while keeping any other whitespace in the password.
+func trimNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
+
+// filePasswordReader reads the password from a file, like a mounted secret.
+func filePasswordReader(path string) PasswordReader {
+	return passwordReaderFunc(func(int) ([]byte, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading password file: %w", err)
+		}
+		return trimNewline(b), nil
+	})
+}
+
+// linePasswordReader reads the password from the first line of r, usually
+// os.Stdin for non-interactive jobs.
+func linePasswordReader(r io.Reader) PasswordReader {
+	return passwordReaderFunc(func(int) ([]byte, error) {
+		line, err := bufio.NewReader(r).ReadBytes('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+			return nil, fmt.Errorf("failed reading password from stdin: %w", err)
+		}
+		return trimNewline(line), nil
+	})
+}
+
+// commandPasswordReader runs a credential helper command through the shell,
+// and reads the password from its standard output. Its standard error is
+// passed through, for helpers needing to interact with the user.
+func commandPasswordReader(command string) PasswordReader {
+	return passwordReaderFunc(func(int) ([]byte, error) {
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed running password command: %w", err)
+		}
+		return trimNewline(out), nil
+	})
+}
+
+// getPasswordReader returns the reader for the non-interactive password source
+// selected by the flags, or nil if none was selected.
+func getPasswordReader(fs *flag.FlagSet, passFile string, passStdin bool, passCommand string) (PasswordReader, error) {
+	var pr PasswordReader
+	var sources []string
+	if passFile != "" {
+		pr, sources = filePasswordReader(passFile), append(sources, "-pass-file")
+	}
+	if passStdin {
+		pr, sources = linePasswordReader(os.Stdin), append(sources, "-pass-stdin")
+	}
+	if passCommand != "" {
+		pr, sources = commandPasswordReader(passCommand), append(sources, "-pass-command")
+	}
+	if len(sources) > 0 && isFlagPassed(fs, "pass") {
+		sources = append(sources, "-pass")
+	}
+	if len(sources) > 1 {
+		return nil, fmt.Errorf("password sources are mutually exclusive: %s", strings.Join(sources, ", "))
+	}
+	return pr, nil
+}
+
 // getCredentials combines the user and pass values with those possibly
 // set in the DSN. If the pass flag was set but empty, it read it from the
 // terminal in echo-less mode.
 //
-// Explicit user/pass flags override those found in the DSN.
-func getCredentials(fs *flag.FlagSet, w io.Writer, flagDSN, flagUser, flagPass string) (user, pass string, err error) {
+// Explicit user/pass flags override those found in the DSN, and so does a
+// password obtained from pr, if it is not nil. Since dial ignores the DSN
+// credentials, these are the only ones used to authenticate.
+func getCredentials(fs *flag.FlagSet, w io.Writer, flagDSN, flagUser, flagPass string, pr PasswordReader) (user, pass string, err error) {
 	user, pass = flagUser, flagPass
 
 	hasDSNFlag := isFlagPassed(fs, "dsn")
 	hasUserFlag := isFlagPassed(fs, "user")
 	hasPassFlag := isFlagPassed(fs, "pass")
+	var singleAuth bool
 
 	if hasDSNFlag && (!hasUserFlag || !hasPassFlag) {
 		var u *url.URL
@@ -61,17 +147,30 @@ func getCredentials(fs *flag.FlagSet, w io.Writer, flagDSN, flagUser, flagPass s
 		}
 		if !hasPassFlag {
 			dsnPass, DSNContainsPass := u.User.Password()
-			if DSNContainsPass {
+			switch {
+			case DSNContainsPass:
 				pass = dsnPass
-			} else {
+			case u.User != nil:
 				// Redis URL parsing accepts single auth element as password, not user
 				pass = user
 				user = ""
+				singleAuth = true
 			}
 		}
 	}
 
-	if hasPassFlag && flagPass == "" {
+	switch {
+	case pr != nil:
+		// With a separate password source, the single DSN auth element is the user.
+		if hasUserFlag {
+			user = flagUser
+		} else if singleAuth {
+			user = pass
+		}
+		if pass, err = readPassword(pr, int(os.Stdin.Fd())); err != nil {
+			return "", "", err
+		}
+	case hasPassFlag && flagPass == "":
 		pass, err = getPasswordFromCLI(w, passwordReaderFunc(term.ReadPassword), int(os.Stdin.Fd()))
 		if err != nil {
 			return "", "", err
@@ -80,6 +179,46 @@ func getCredentials(fs *flag.FlagSet, w io.Writer, flagDSN, flagUser, flagPass s
 	return
 }
 
+// clientName identifies the connections in CLIENT LIST.
+const clientName = "drupal_redis_stats"
+
+// hello sets the connection up with HELLO, which also authenticates if a user
+// or password is provided, and names the connection. It returns the fields
+// describing the server in the HELLO reply.
+//
+// Servers predating HELLO, like Redis < 6, reply with an unknown command error,
+// in which case it falls back to a plain AUTH, returning nil fields. Other
+// errors, like WRONGPASS or NOPERM, are returned as is: retrying them with AUTH
+// would only fail again.
+//
+// It requests protocol version 2, since the redigo client does not parse RESP3.
+func hello(c redis.Conn, includeUser bool, user, pass string) (map[string]string, error) {
+	hasAuth := user != "" || pass != ""
+	args := []interface{}{2}
+	if hasAuth {
+		helloUser := user
+		if !includeUser || helloUser == "" {
+			// Redis assigns the requirepass password to the default user.
+			helloUser = "default"
+		}
+		args = append(args, "AUTH", helloUser, pass)
+	}
+	args = append(args, "SETNAME", clientName)
+
+	reply, err := c.Do("HELLO", args...)
+	var serverErr redis.Error
+	switch {
+	case err == nil:
+		return stats.ParseHello(reply)
+	case !errors.As(err, &serverErr) || !strings.HasPrefix(string(serverErr), "ERR unknown command"):
+		return nil, fmt.Errorf("failed HELLO: %w", err)
+	case hasAuth:
+		return nil, authenticate(c, includeUser, user, pass)
+	default:
+		return nil, nil
+	}
+}
+
 func authenticate(c redis.Conn, includeUser bool, user, pass string) error {
 	var err error
 	if includeUser {
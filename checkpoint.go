@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/snapshot"
+	"github.com/fgm/drupal_redis_stats/stats"
+	"github.com/fgm/drupal_redis_stats/stats/progress"
+)
+
+// checkpointPasses is the number of SCAN passes between checks of the
+// checkpoint interval.
+const checkpointPasses = 100
+
+// checkpointTarget identifies the database in a checkpoint: the DSN without its
+// credentials, which are not to be written to disk.
+func checkpointTarget(dsn string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing Redis DSN: %v", err)
+	}
+	u.User = nil
+	return u.String(), nil
+}
+
+// checkpointer saves the state of a scan to a file at intervals, to resume it
+// from the saved cursor if it is interrupted.
+type checkpointer struct {
+	path     string
+	target   string
+	interval time.Duration
+	client   stats.Client
+	w        io.Writer // Scan progress output.
+}
+
+// load returns the partial results saved in the checkpoint file, checking they
+// were saved for the same target and prefix.
+func (cp checkpointer) load(prefix string, scheme stats.Scheme) (stats.CacheStats, error) {
+	saved, err := snapshot.LoadCheckpoint(cp.path)
+	if err != nil {
+		return stats.CacheStats{}, err
+	}
+	if saved.Target != cp.target {
+		return stats.CacheStats{}, fmt.Errorf("checkpoint %s was saved for %s, not %s", cp.path, saved.Target, cp.target)
+	}
+	if saved.Stats.Prefix != prefix {
+		return stats.CacheStats{}, fmt.Errorf("checkpoint %s was saved for prefix %q, not %q", cp.path, saved.Stats.Prefix, prefix)
+	}
+	// A detected scheme is kept, but an explicit one must match.
+	if scheme != stats.SchemeAuto && saved.Stats.Scheme != "" && saved.Stats.Scheme != scheme {
+		return stats.CacheStats{}, fmt.Errorf("checkpoint %s was saved for key scheme %s, not %s", cp.path, saved.Stats.Scheme, scheme)
+	}
+	saved.Stats.Incomplete = false
+	return saved.Stats, nil
+}
+
+// chunkReporter reports the scans of successive chunks as a single scan, from
+// the start of the first chunk to the end of the last one.
+type chunkReporter struct {
+	progress.Reporter
+	started bool
+	// offset is added to the keys seen by a chunk, which only starts from the
+	// Drupal cache keys found so far, to also count the other keys seen.
+	offset uint64
+	last   progress.Status
+	err    error
+}
+
+func (cr *chunkReporter) Start(s progress.Status) {
+	if !cr.started {
+		cr.started = true
+		cr.Reporter.Start(s)
+		return
+	}
+	cr.offset = cr.last.Seen - s.Seen
+}
+
+func (cr *chunkReporter) Update(s progress.Status) {
+	s.Seen += cr.offset
+	cr.Reporter.Update(s)
+}
+
+func (cr *chunkReporter) Done(s progress.Status, err error) {
+	s.Seen += cr.offset
+	cr.last, cr.err = s, err
+}
+
+// finish reports the end of the whole scan.
+func (cr *chunkReporter) finish() {
+	if cr.started {
+		cr.Reporter.Done(cr.last, cr.err)
+	}
+}
+
+// scan continues the scan of cs from its cursor, saving checkpoints at
+// intervals, and when the scan stops on an error. The checkpoint file is
+// removed once the scan is complete.
+func (cp checkpointer) scan(ctx context.Context, cs *stats.CacheStats) error {
+	// Report the progress of all chunks together, for a meaningful rate.
+	pr := &chunkReporter{Reporter: progress.New(cp.w)}
+	cs.Progress = pr
+	defer pr.finish()
+	last := time.Now()
+	for {
+		err := cs.ScanContext(ctx, cp.client, checkpointPasses, cp.w)
+		if err == nil && cs.Cursor == 0 {
+			if err = os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed removing checkpoint: %w", err)
+			}
+			return nil
+		}
+		if err != nil || time.Since(last) >= cp.interval {
+			if saveErr := snapshot.NewCheckpoint(cp.target, *cs).Save(cp.path); saveErr != nil {
+				return errors.Join(err, saveErr)
+			}
+			last = time.Now()
+		}
+		if err != nil {
+			return fmt.Errorf("%w, checkpoint saved to %s: continue with -resume", err, cp.path)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Package cluster scans all primaries of a Redis Cluster for Drupal cache content.
+
+A single connection only sees the keys held by one cluster node, so the nodes
+are discovered from the initial connection, and each primary is scanned on its
+own connection before the results are merged.
+*/
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+/*
+SlotRange is an inclusive range of hash slots.
+*/
+type SlotRange struct {
+	Start, End int
+}
+
+func (sr SlotRange) String() string {
+	if sr.Start == sr.End {
+		return strconv.Itoa(sr.Start)
+	}
+	return fmt.Sprintf("%d-%d", sr.Start, sr.End)
+}
+
+/*
+Node describes a cluster primary.
+*/
+type Node struct {
+	ID   string
+	Addr string // host:port, or host:tls-port if the node only has a TLS port.
+	// TLSAddr is host:tls-port, when the node advertises a TLS port in CLUSTER
+	// SHARDS. CLUSTER NODES only reports the port used by the cluster.
+	TLSAddr string `json:",omitempty"`
+	Slots   []SlotRange
+}
+
+/*
+SlotsString returns the slot ranges served by the node, like "0-5460,5462".
+*/
+func (n Node) SlotsString() string {
+	ranges := make([]string, len(n.Slots))
+	for i, sr := range n.Slots {
+		ranges[i] = sr.String()
+	}
+	return strings.Join(ranges, ",")
+}
+
+/*
+NodeStats holds the scan results of a single node.
+*/
+type NodeStats struct {
+	Node
+	Stats stats.CacheStats
+}
+
+/*
+Result holds the merged scan results for the cluster, and the per-node ones.
+*/
+type Result struct {
+	Total stats.CacheStats
+	Nodes []NodeStats // Ordered by address.
+}
+
+/*
+Client is a client for a single node, closed once its scan is done, like a
+stats.RedigoClient.
+*/
+type Client interface {
+	stats.Client
+	io.Closer
+}
+
+/*
+Dialer opens an authenticated client to the node, on its Addr, or its TLSAddr
+for TLS connections.
+*/
+type Dialer func(n Node) (Client, error)
+
+/*
+Nodes discovers the primaries of the cluster using the given connection, to
+scan them with Scan.
+
+It uses CLUSTER SHARDS, available since Redis 7.0, falling back to CLUSTER NODES
+on older servers.
+*/
+func Nodes(c redis.Conn) ([]Node, error) {
+	nodes, err := shardsNodes(c)
+	if err != nil {
+		if nodes, err = clusterNodes(c); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Addr < nodes[j].Addr })
+	return nodes, nil
+}
+
+// toMap converts a RESP2 flat key-value array to a map.
+func toMap(v interface{}) (map[string]interface{}, error) {
+	values, err := redis.Values(v, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("odd number of elements in key-value array: %d", len(values))
+	}
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		k, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = values[i+1]
+	}
+	return m, nil
+}
+
+// shardsNodes discovers the primaries using CLUSTER SHARDS.
+func shardsNodes(c redis.Conn) ([]Node, error) {
+	shards, err := redis.Values(c.Do("CLUSTER", "SHARDS"))
+	if err != nil {
+		return nil, fmt.Errorf("failed CLUSTER SHARDS: %w", err)
+	}
+	var nodes []Node
+	for _, s := range shards {
+		shard, err := toMap(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing shard: %w", err)
+		}
+		bounds, err := redis.Ints(shard["slots"], nil)
+		if err != nil || len(bounds)%2 != 0 {
+			return nil, fmt.Errorf("failed parsing shard slots: %v", shard["slots"])
+		}
+		var slots []SlotRange
+		for i := 0; i < len(bounds); i += 2 {
+			slots = append(slots, SlotRange{Start: bounds[i], End: bounds[i+1]})
+		}
+		shardNodes, err := redis.Values(shard["nodes"], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing shard nodes: %w", err)
+		}
+		for _, sn := range shardNodes {
+			node, err := toMap(sn)
+			if err != nil {
+				return nil, fmt.Errorf("failed parsing shard node: %w", err)
+			}
+			role, _ := redis.String(node["role"], nil)
+			health, _ := redis.String(node["health"], nil)
+			// Failed primaries cannot be scanned, and loading ones do not hold
+			// all their keys yet.
+			if role != "master" || health == "failed" || health == "loading" {
+				continue
+			}
+			id, _ := redis.String(node["id"], nil)
+			host, _ := redis.String(node["endpoint"], nil)
+			if host == "" || host == "?" {
+				host, _ = redis.String(node["ip"], nil)
+			}
+			n := Node{ID: id, Slots: slots}
+			if tlsPort, err := redis.Int(node["tls-port"], nil); err == nil {
+				n.TLSAddr = net.JoinHostPort(host, strconv.Itoa(tlsPort))
+			}
+			port, err := redis.Int(node["port"], nil)
+			switch {
+			case err == nil:
+				n.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+			case n.TLSAddr != "":
+				n.Addr = n.TLSAddr
+			default:
+				return nil, fmt.Errorf("no port for node %s", id)
+			}
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// clusterNodes discovers the primaries using CLUSTER NODES, which returns one
+// line per node, formatted as:
+//
+//	<id> <ip:port@cport[,hostname]> <flags> <primary> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> <slot> ... <slot>
+func clusterNodes(c redis.Conn) ([]Node, error) {
+	text, err := redis.String(c.Do("CLUSTER", "NODES"))
+	if err != nil {
+		return nil, fmt.Errorf("failed CLUSTER NODES: %w", err)
+	}
+	var nodes []Node
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("unexpected CLUSTER NODES line: %q", line)
+		}
+		flags := strings.Split(fields[2], ",")
+		if !hasFlag(flags, "master") || hasFlag(flags, "fail") || hasFlag(flags, "noaddr") {
+			continue
+		}
+		addr, _, _ := strings.Cut(fields[1], "@")
+		node := Node{ID: fields[0], Addr: addr}
+		for _, slot := range fields[8:] {
+			if strings.HasPrefix(slot, "[") {
+				continue // Slot being imported or migrated.
+			}
+			start, end, isRange := strings.Cut(slot, "-")
+			if !isRange {
+				end = start
+			}
+			sr := SlotRange{}
+			if sr.Start, err = strconv.Atoi(start); err != nil {
+				return nil, fmt.Errorf("unexpected slot %q: %w", slot, err)
+			}
+			if sr.End, err = strconv.Atoi(end); err != nil {
+				return nil, fmt.Errorf("unexpected slot %q: %w", slot, err)
+			}
+			node.Slots = append(node.Slots, sr)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Scan scans each of the cluster primaries, as returned by Nodes, on its own
+client obtained from dial, and merges the results.
+
+  - prefix is the Drupal cache prefix, as in stats.CacheStats.Prefix.
+  - w is a logging output (think os.Stderr), not the main output.
+*/
+func Scan(nodes []Node, dial Dialer, prefix string, w io.Writer) (Result, error) {
+	return ScanContext(context.Background(), nodes, dial, prefix, "", nil, nil, w)
+}
+
+/*
+ScanContext is Scan, stopping like stats.CacheStats.ScanContext when ctx is
+done. The total and the node being scanned are then marked incomplete, and the
+nodes not scanned yet are omitted, except from the TotalKeys of the total, per
+their DBSIZE, to measure its coverage. All nodes are therefore connected to
+before scanning the first one.
+
+The scheme is that of the keys, as in stats.CacheStats.Scheme: with
+stats.SchemeAuto, it is detected for each node, unless schemes, if not nil,
+holds the one detected on a previous scan, where the new ones are stored. The
+throttle, if not nil, paces the scans of all nodes.
+*/
+func ScanContext(ctx context.Context, nodes []Node, dial Dialer, prefix string, scheme stats.Scheme, schemes stats.SchemeCache, throttle *stats.Throttle, w io.Writer) (Result, error) {
+	var res Result
+	if len(nodes) == 0 {
+		return res, fmt.Errorf("no primary found in cluster")
+	}
+	clients := make([]Client, 0, len(nodes))
+	defer func() {
+		for _, nc := range clients {
+			nc.Close()
+		}
+	}()
+	// Sizing is not interrupted, to measure the coverage of interrupted scans.
+	sizeCtx := context.WithoutCancel(ctx)
+	sizes := make([]uint32, len(nodes))
+	for i, node := range nodes {
+		nc, err := dial(node)
+		if err != nil {
+			return res, fmt.Errorf("failed connecting to node %s: %w", node.Addr, err)
+		}
+		clients = append(clients, nc)
+		size, err := nc.DBSize(sizeCtx)
+		if err != nil {
+			return res, fmt.Errorf("failed DBSIZE on node %s: %w", node.Addr, err)
+		}
+		sizes[i] = uint32(size)
+	}
+
+	res.Total = stats.CacheStats{Prefix: prefix, Stats: map[string]stats.BinStats{}}
+	for i, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			res.Total.AddUnscanned(sizes[i:])
+			return res, err
+		}
+		ns, err := scanNode(ctx, node, clients[i], prefix, schemes.Scheme(node.ID, scheme), throttle, w)
+		schemes.Store(node.ID, ns.Stats.Scheme)
+		if err != nil {
+			if ns.Stats.Incomplete {
+				res.Total.Merge(ns.Stats)
+				res.Total.AddUnscanned(sizes[i+1:])
+				res.Nodes = append(res.Nodes, ns)
+			}
+			return res, err
+		}
+		res.Total.Merge(ns.Stats)
+		res.Nodes = append(res.Nodes, ns)
+	}
+	return res, nil
+}
+
+func scanNode(ctx context.Context, node Node, nc stats.Client, prefix string, scheme stats.Scheme, throttle *stats.Throttle, w io.Writer) (NodeStats, error) {
+	ns := NodeStats{Node: node, Stats: stats.CacheStats{Prefix: prefix, Scheme: scheme, Throttle: throttle}}
+	if err := ns.Stats.ScanContext(ctx, nc, 0, w); err != nil {
+		if ns.Stats.Incomplete {
+			return ns, err
+		}
+		return ns, fmt.Errorf("failed scanning node %s: %w", node.Addr, err)
+	}
+	return ns, nil
+}
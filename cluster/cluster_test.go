@@ -0,0 +1,289 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/cluster"
+	"github.com/fgm/drupal_redis_stats/redistest"
+	"github.com/fgm/drupal_redis_stats/stats"
+	"github.com/fgm/drupal_redis_stats/stats/progress"
+	"github.com/fgm/drupal_redis_stats/stats/statstest"
+)
+
+// newFakeCluster starts 3 primaries serving 3 slot ranges, with a replica for
+// the first one, which must not be scanned, like the failed and loading
+// primaries without slots listed at unreachable addresses. Without useShards,
+// the nodes are discovered with CLUSTER NODES, which does not report loading
+// nodes.
+func newFakeCluster(t *testing.T, useShards bool) []*redistest.Server {
+	keys := []map[string]int64{
+		{
+			"drupal.redis.10.1.0:render:a": 1000,
+			"drupal.redis.10.1.0:render:b": 2000,
+			"drupal.redis.10.1.0:config:c": 100,
+		},
+		{
+			"drupal.redis.10.1.0:render:d": 4000,
+			"drupal.redis.10.1.0:page:e":   500,
+		},
+		{
+			"drupal.redis.10.1.0:config:f": 200,
+			"other:key":                    1000,
+		},
+	}
+	slots := [][2]int{{0, 5460}, {5461, 10922}, {10923, 16383}}
+
+	var servers []*redistest.Server
+	var topology []redistest.ClusterNode
+	for i, sizes := range keys {
+		s := newServer(t, sizes)
+		servers = append(servers, s)
+		topology = append(topology, redistest.ClusterNode{ID: fmt.Sprintf("n%d", i), Addr: s.Addr, Slots: [][2]int{slots[i]}})
+	}
+	replica := newServer(t, map[string]int64{"drupal.redis.10.1.0:render:a": 1000})
+	topology = append(topology,
+		redistest.ClusterNode{ID: "r0", Addr: replica.Addr, Primary: "n0"},
+		redistest.ClusterNode{ID: "f3", Addr: "127.0.0.1:1", Health: "failed"},
+	)
+	if useShards {
+		topology = append(topology, redistest.ClusterNode{ID: "l4", Addr: "127.0.0.1:2", Health: "loading"})
+	}
+	for _, s := range append(servers, replica) {
+		s.SetCluster(topology)
+		if !useShards {
+			s.Disable("CLUSTER SHARDS")
+		}
+	}
+	return servers
+}
+
+// newServer starts a server holding keys using the given MEMORY USAGE.
+func newServer(t *testing.T, sizes map[string]int64) *redistest.Server {
+	s := redistest.NewServer()
+	t.Cleanup(s.Close)
+	for key, size := range sizes {
+		s.SetSized(0, key, size)
+	}
+	return s
+}
+
+func dial(addr string) (redis.Conn, error) {
+	return redis.Dial("tcp", addr)
+}
+
+func dialNode(n cluster.Node) (cluster.Client, error) {
+	c, err := dial(n.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return stats.NewRedigoClient(c), nil
+}
+
+// discover returns the nodes of the cluster seen from the server.
+func discover(t *testing.T, s *redistest.Server) []cluster.Node {
+	c, err := dial(s.Addr)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer c.Close()
+	nodes, err := cluster.Nodes(c)
+	if err != nil {
+		t.Fatalf("failed discovering nodes: %v", err)
+	}
+	return nodes
+}
+
+func TestNodes(t *testing.T) {
+	for _, useShards := range []bool{true, false} {
+		t.Run(fmt.Sprintf("shards %t", useShards), func(t *testing.T) {
+			fakes := newFakeCluster(t, useShards)
+			c, err := dial(fakes[1].Addr)
+			if err != nil {
+				t.Fatalf("failed dialing: %v", err)
+			}
+			defer c.Close()
+			nodes, err := cluster.Nodes(c)
+			if err != nil {
+				t.Fatalf("failed discovering nodes: %v", err)
+			}
+			if len(nodes) != 3 {
+				t.Fatalf("got %d nodes, expected 3: %v", len(nodes), nodes)
+			}
+			seen := map[string]string{}
+			for _, n := range nodes {
+				seen[n.ID] = n.SlotsString()
+			}
+			expected := map[string]string{"n0": "0-5460", "n1": "5461-10922", "n2": "10923-16383"}
+			if !reflect.DeepEqual(seen, expected) {
+				t.Errorf("got %v, expected %v", seen, expected)
+			}
+		})
+	}
+}
+
+func TestNodesTLSPort(t *testing.T) {
+	s := newServer(t, nil)
+	s.SetCluster([]redistest.ClusterNode{
+		{ID: "n0", Addr: "127.0.0.1:7000", TLSPort: 7100, Slots: [][2]int{{0, 16383}}},
+	})
+	c, err := dial(s.Addr)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer c.Close()
+	nodes, err := cluster.Nodes(c)
+	if err != nil {
+		t.Fatalf("failed discovering nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Addr != "127.0.0.1:7000" || nodes[0].TLSAddr != "127.0.0.1:7100" {
+		t.Errorf("got %+v, expected a node on ports 7000 and 7100", nodes)
+	}
+}
+
+func TestNodesSadNotCluster(t *testing.T) {
+	fake := newServer(t, nil)
+	c, err := dial(fake.Addr)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer c.Close()
+	if _, err = cluster.Nodes(c); err == nil {
+		t.Error("unexpected success on a non-cluster server")
+	}
+}
+
+func TestScan(t *testing.T) {
+	fakes := newFakeCluster(t, true)
+	nodes := discover(t, fakes[0])
+
+	res, err := cluster.Scan(nodes, dialNode, "", io.Discard)
+	if err != nil {
+		t.Fatalf("failed scanning: %v", err)
+	}
+	expected := stats.CacheStats{
+		TotalKeys: 7,
+		Server:    &stats.Server{Product: stats.ProductRedis, Version: redistest.DefaultVersion, Mode: "cluster", MemoryUsage: true},
+		Stats: map[string]stats.BinStats{
+			"render": {Keys: 3, Size: 7000},
+			"config": {Keys: 2, Size: 300},
+			"page":   {Keys: 1, Size: 500},
+		},
+	}
+	if !reflect.DeepEqual(res.Total, expected) {
+		t.Errorf("got %v, expected %v", res.Total, expected)
+	}
+	if len(res.Nodes) != 3 {
+		t.Fatalf("got %d nodes, expected 3", len(res.Nodes))
+	}
+	var sum uint32
+	for _, ns := range res.Nodes {
+		sum += ns.Stats.TotalKeys
+	}
+	if sum != expected.TotalKeys {
+		t.Errorf("got %d keys on nodes, expected %d", sum, expected.TotalKeys)
+	}
+}
+
+func TestScanSchemes(t *testing.T) {
+	fakes := newFakeCluster(t, true)
+	nodes := discover(t, fakes[0])
+
+	// The scheme stored for a node is reused, instead of detecting it again.
+	schemes := stats.SchemeCache{"n0": stats.SchemeD7}
+	res, err := cluster.ScanContext(context.Background(), nodes, dialNode, "", stats.SchemeAuto, schemes, nil, io.Discard)
+	if err != nil {
+		t.Fatalf("failed scanning: %v", err)
+	}
+	expected := stats.SchemeCache{"n0": stats.SchemeD7, "n1": stats.SchemeD8, "n2": stats.SchemeD8}
+	if !reflect.DeepEqual(schemes, expected) {
+		t.Errorf("got schemes %v, expected %v", schemes, expected)
+	}
+	for _, ns := range res.Nodes {
+		if ns.Stats.Scheme != expected[ns.ID] {
+			t.Errorf("got scheme %q on node %s, expected %q", ns.Stats.Scheme, ns.ID, expected[ns.ID])
+		}
+	}
+}
+
+// cancelingReporter is a progress output canceling the scan when the scan of
+// the nth node starts.
+type cancelingReporter struct {
+	n, started int
+	cancel     context.CancelFunc
+}
+
+func (cr *cancelingReporter) Start(progress.Status) {
+	if cr.started++; cr.started == cr.n {
+		cr.cancel()
+	}
+}
+
+func (cr *cancelingReporter) Update(progress.Status)      {}
+func (cr *cancelingReporter) Done(progress.Status, error) {}
+func (cr *cancelingReporter) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestScanCanceled(t *testing.T) {
+	fakes := newFakeCluster(t, true)
+	nodes := discover(t, fakes[0])
+
+	// Cancel once the second node is sized, before its first SCAN batch.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res, err := cluster.ScanContext(ctx, nodes, dialNode, "", "", nil, nil, &cancelingReporter{n: 2, cancel: cancel})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+	if !res.Total.Incomplete || len(res.Nodes) != 2 || res.Nodes[0].Stats.Incomplete || !res.Nodes[1].Stats.Incomplete {
+		t.Errorf("unexpected partial results: %+v", res)
+	}
+	if scanned := res.Nodes[1].Stats.ScannedKeys(); scanned != 0 {
+		t.Errorf("got %d keys scanned on the second node, expected 0", scanned)
+	}
+	// The keys of the third node, not scanned, count in the coverage. Nodes are
+	// ordered by address, so the first one depends on the ports of the fakes.
+	if scanned := res.Nodes[0].Stats.ScannedKeys(); res.Total.TotalKeys != 7 || res.Total.ScannedKeys() != scanned {
+		t.Errorf("got %d keys out of %d, expected %d out of 7", res.Total.ScannedKeys(), res.Total.TotalKeys, scanned)
+	}
+}
+
+func TestScanSadDial(t *testing.T) {
+	fakes := newFakeCluster(t, true)
+	nodes := discover(t, fakes[0])
+
+	errDial := errors.New("dial failed")
+	_, err := cluster.Scan(nodes, func(cluster.Node) (cluster.Client, error) { return nil, errDial }, "", io.Discard)
+	if !errors.Is(err, errDial) {
+		t.Errorf("got %v, expected %v", err, errDial)
+	}
+}
+
+// nopCloser is a cluster.Client for a statstest.Client, which needs no closing.
+type nopCloser struct {
+	*statstest.Client
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestScanClients(t *testing.T) {
+	clients := map[string]*statstest.Client{
+		"n0": statstest.NewClient(map[string]int64{"drupal.redis.10.1.0:render:a": 10}),
+		"n1": statstest.NewClient(map[string]int64{"drupal.redis.10.1.0:render:b": 20, "other:key": 30}),
+	}
+	dial := func(n cluster.Node) (cluster.Client, error) {
+		return nopCloser{clients[n.ID]}, nil
+	}
+	res, err := cluster.Scan([]cluster.Node{{ID: "n0"}, {ID: "n1"}}, dial, "", io.Discard)
+	if err != nil {
+		t.Fatalf("failed scanning: %v", err)
+	}
+	if res.Total.TotalKeys != 3 || res.Total.Stats["render"] != (stats.BinStats{Keys: 2, Size: 30}) {
+		t.Errorf("unexpected results %+v", res.Total)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/cluster"
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// clusterDialer returns a dialer connecting to cluster nodes with the same
+// scheme and credentials as the initial DSN: with rediss, on their TLS port if
+// they advertise one.
+func clusterDialer(dsn, user, pass string, options ...redis.DialOption) (cluster.Dialer, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing Redis DSN: %w", err)
+	}
+	return func(n cluster.Node) (cluster.Client, error) {
+		nu := *u
+		nu.Host = n.Addr
+		if u.Scheme == "rediss" && n.TLSAddr != "" {
+			nu.Host = n.TLSAddr
+		}
+		nu.Path = "" // Cluster nodes only have database 0.
+		nodeDSN := nu.String()
+		c, _, err := open(nodeDSN, user, pass, options...)
+		if err != nil {
+			return nil, err
+		}
+		return stats.NewRedigoClient(c), nil
+	}, nil
+}
+
+// writeClusterNodes outputs the results of each cluster node, followed by the
+// merged results, to help spotting slot imbalance caused by hot bins.
+func writeClusterNodes(w io.Writer, res cluster.Result, format output.Format, opts output.Options) error {
+	sections := make([]section, len(res.Nodes))
+	for i, ns := range res.Nodes {
+		sections[i] = section{
+			Title: fmt.Sprintf("Node %s (%s), slots %s", ns.Addr, ns.ID, ns.SlotsString()),
+			Stats: &res.Nodes[i].Stats,
+		}
+	}
+	total := section{Title: fmt.Sprintf("All %d nodes", len(res.Nodes)), Stats: &res.Total}
+	return writeSections(w, sections, total, res, format, opts)
+}
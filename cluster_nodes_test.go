@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/cluster"
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func TestWriteClusterNodes(t *testing.T) {
+	node := stats.CacheStats{TotalKeys: 2, Stats: map[string]stats.BinStats{"render": {Keys: 2, Size: 20}}}
+	res := cluster.Result{
+		Total: node,
+		Nodes: []cluster.NodeStats{{
+			Node:  cluster.Node{ID: "n0", Addr: "10.0.0.1:7000", Slots: []cluster.SlotRange{{Start: 0, End: 16383}}},
+			Stats: node,
+		}},
+	}
+	checks := [...]struct {
+		format   output.Format
+		expected string
+		expError bool
+	}{
+		{output.FormatText, "Node 10.0.0.1:7000 (n0), slots 0-16383\n\nBin", false},
+		{output.FormatMarkdown, "All 1 nodes\n\n| Bin |", false},
+		{output.FormatJSON, `"Addr":"10.0.0.1:7000"`, false},
+		{output.FormatHTML, "", true},
+	}
+	for _, check := range checks {
+		t.Run(string(check.format), func(t *testing.T) {
+			w := strings.Builder{}
+			err := writeClusterNodes(&w, res, check.format, output.Options{})
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(w.String(), check.expected) {
+				t.Errorf("did not find %q in:\n%s", check.expected, w.String())
+			}
+		})
+	}
+}
+
+func TestClusterDialer(t *testing.T) {
+	if _, err := clusterDialer(":bad", "", ""); err == nil {
+		t.Error("unexpected success with invalid DSN")
+	}
+
+	plain := newTestServer(t)
+	tlsAddr := newTLSServer(t, newTestCA(t), false)
+	node := cluster.Node{ID: "n0", Addr: plain.Addr, TLSAddr: tlsAddr}
+	checks := [...]struct {
+		name string
+		dsn  string
+		node cluster.Node
+	}{
+		{"plain", "redis://" + plain.Addr, node},
+		{"tls port", "rediss://" + tlsAddr, node},
+		{"no tls port", "rediss://" + tlsAddr, cluster.Node{ID: "n0", Addr: tlsAddr}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			opts := tlsOptions{insecure: strings.HasPrefix(check.dsn, "rediss:")}
+			options, err := getDialOptions(check.dsn, opts)
+			if err != nil {
+				t.Fatalf("failed building dial options: %v", err)
+			}
+			dial, err := clusterDialer(check.dsn, "", "", options...)
+			if err != nil {
+				t.Fatalf("failed building dialer: %v", err)
+			}
+			c, err := dial(check.node)
+			if err != nil {
+				t.Fatalf("failed dialing: %v", err)
+			}
+			c.Close()
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// envPrefix is prepended to flag names to build environment variable names.
+	envPrefix = "DRS_"
+	// envRedisCLIAuth is the password variable used by redis-cli.
+	envRedisCLIAuth = "REDISCLI_AUTH"
+)
+
+// Precedences of the sources of flag values.
+const (
+	precedenceConfig = iota + 1
+	precedenceEnv
+	precedenceFlag
+)
+
+// credentialFlags are the flags overriding the credentials in a DSN from the
+// same source, or one with a lower precedence.
+var credentialFlags = map[string]bool{
+	"user": true, "pass": true, "pass-file": true, "pass-stdin": true, "pass-command": true,
+}
+
+// envName returns the environment variable name for a flag, like DRS_TLS_CA
+// for -tls-ca.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// defaultConfigPath returns the path of the configuration file used when none
+// is specified, like ~/.config/drupal_redis_stats/config.yml on Linux.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "drupal_redis_stats", "config.yml")
+}
+
+// loadConfig reads a YAML configuration file, holding flag values by flag
+// name at the top level, and named profiles overriding them:
+//
+//	dsn: redis://localhost:6379/0
+//	profiles:
+//	  prod:
+//	    dsn: rediss://redis.example.com:6380/0
+//	    tls-ca: /etc/ssl/prod-ca.pem
+//
+// It returns the values for the profile, or the top-level ones if the profile
+// is empty.
+func loadConfig(path, profile string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading configuration: %w", err)
+	}
+	var raw map[string]any
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed parsing configuration %s: %w", path, err)
+	}
+	profiles, _ := raw["profiles"].(map[string]any)
+	delete(raw, "profiles")
+
+	values := make(map[string]string, len(raw))
+	if err = addConfigValues(values, raw); err != nil {
+		return nil, err
+	}
+	if profile != "" {
+		p, ok := profiles[profile].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in %s", profile, path)
+		}
+		if err = addConfigValues(values, p); err != nil {
+			return nil, fmt.Errorf("in profile %q: %w", profile, err)
+		}
+	}
+	return values, nil
+}
+
+// addConfigValues converts the scalar configuration values to strings, as
+// accepted by flag.FlagSet.Set.
+func addConfigValues(values map[string]string, raw map[string]any) error {
+	for k, v := range raw {
+		switch v.(type) {
+		case string, bool, int, float64:
+			values[k] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("configuration key %q does not have a scalar value", k)
+		}
+	}
+	return nil
+}
+
+// applyEnvAndConfig sets the flags which were not passed on the command line
+// from the environment, or else from the configuration file, so that the
+// precedence is: flags > environment > configuration file > defaults.
+//
+// Since flags set this way are reported as passed by isFlagPassed, a user or
+// password from the environment or the configuration overrides the one in a
+// DSN from the environment or the configuration, just like a flag does. They
+// are not applied when the -dsn flag holds credentials, which would then be
+// overridden by lower precedence sources. Empty values are ignored, so they
+// cannot trigger the password prompt.
+//
+// The configuration file is the one from -config or DRS_CONFIG, else the
+// default one if it exists, using the profile from -profile or DRS_PROFILE.
+func applyEnvAndConfig(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	getenv := func(name string) string {
+		v, _ := lookupEnv(name)
+		return v
+	}
+	path, profile := fs.Lookup("config").Value.String(), fs.Lookup("profile").Value.String()
+	if !isFlagPassed(fs, "config") {
+		path = getenv(envName("config"))
+	}
+	if !isFlagPassed(fs, "profile") {
+		profile = getenv(envName("profile"))
+	}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+
+	var config map[string]string
+	var err error
+	if path != "" {
+		config, err = loadConfig(path, profile)
+		if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+			return err
+		}
+		if err != nil && profile != "" {
+			return fmt.Errorf("profile %q requested without a configuration file", profile)
+		}
+	}
+	for name := range config {
+		if fs.Lookup(name) == nil || name == "config" || name == "profile" {
+			return fmt.Errorf("unknown configuration key %q", name)
+		}
+	}
+
+	// Credentials from a source with a lower precedence than the one of a DSN
+	// holding credentials do not override them.
+	dsnPrecedence := 0
+	switch {
+	case isFlagPassed(fs, "dsn"):
+		if hasCredentials(fs.Lookup("dsn").Value.String()) {
+			dsnPrecedence = precedenceFlag
+		}
+	case getenv(envName("dsn")) != "":
+		if hasCredentials(getenv(envName("dsn"))) {
+			dsnPrecedence = precedenceEnv
+		}
+	case hasCredentials(config["dsn"]):
+		dsnPrecedence = precedenceConfig
+	}
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if setErr != nil || f.Name == "config" || f.Name == "profile" || isFlagPassed(fs, f.Name) {
+			return
+		}
+		value := getenv(envName(f.Name))
+		if value == "" && f.Name == "pass" {
+			value = getenv(envRedisCLIAuth)
+		}
+		source, precedence := "environment", precedenceEnv
+		if value == "" {
+			value, source, precedence = config[f.Name], "configuration", precedenceConfig
+		}
+		if value == "" || credentialFlags[f.Name] && precedence < dsnPrecedence {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			setErr = fmt.Errorf("invalid %s value for %s: %w", source, f.Name, err)
+		}
+	})
+	return setErr
+}
+
+// hasCredentials reports whether a data node DSN holds credentials. Those of
+// sentinel DSNs are the credentials of the sentinels.
+func hasCredentials(dsn string) bool {
+	u, err := url.Parse(dsn)
+	return err == nil && u.User != nil && !isSentinelDSN(dsn)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// writeDatabases outputs the results of each database holding Drupal cache
+// data, followed by the grand total.
+func writeDatabases(w io.Writer, total stats.CacheStats, dbs []stats.DatabaseStats, format output.Format, opts output.Options) error {
+	sections := make([]section, len(dbs))
+	for i, ds := range dbs {
+		sections[i] = section{Title: fmt.Sprintf("Database %d", ds.Index), Stats: &dbs[i].Stats}
+	}
+	v := struct {
+		Total     stats.CacheStats
+		Databases []stats.DatabaseStats
+	}{total, dbs}
+	return writeSections(w, sections, section{Title: fmt.Sprintf("All %d databases", len(dbs)), Stats: &total}, v, format, opts)
+}
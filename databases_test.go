@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func TestWriteDatabases(t *testing.T) {
+	db := stats.CacheStats{TotalKeys: 2, Stats: map[string]stats.BinStats{"render": {Keys: 2, Size: 20}}}
+	dbs := []stats.DatabaseStats{{Index: 3, Stats: db}}
+
+	w := strings.Builder{}
+	if err := writeDatabases(&w, db, dbs, output.FormatText, output.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"Database 3\n\nBin", "\nAll 1 databases\n\nBin"} {
+		if !strings.Contains(w.String(), expected) {
+			t.Errorf("did not find %q in:\n%s", expected, w.String())
+		}
+	}
+
+	w.Reset()
+	if err := writeDatabases(&w, db, dbs, output.FormatJSON, output.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(w.String(), `"Databases":[{"Index":3,`) {
+		t.Errorf("unexpected JSON: %s", w.String())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/snapshot"
+)
+
+// checkDiffFormat returns an error if diffs cannot be output in the format.
+func checkDiffFormat(format output.Format) error {
+	if format != output.FormatJSON && format != output.FormatText {
+		return fmt.Errorf("format %s is not supported for diffs: use %s or %s",
+			format, output.FormatText, output.FormatJSON)
+	}
+	return nil
+}
+
+// writeDiff outputs the diff in text or JSON format.
+func writeDiff(w io.Writer, d snapshot.Diff, format output.Format, opts output.Options) error {
+	if err := checkDiffFormat(format); err != nil {
+		return err
+	}
+	if format == output.FormatJSON {
+		return output.DiffJSON(w, &d)
+	}
+	return output.DiffText(w, &d, opts)
+}
+
+// runDiff implements the diff subcommand, comparing two snapshot files.
+func runDiff(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "Use JSON output.")
+	flagHuman := fs.String("human", "", "Display sizes with iec (1.5 KiB) or si (1.5 kB) units.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: drupal_redis_stats diff [flags] old.json new.json\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("failed parsing flags: %w", err)
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("diff needs exactly two snapshot files")
+	}
+
+	format := output.FormatText
+	if *jsonOutput {
+		format = output.FormatJSON
+	}
+	opts, err := getOutputOptions(*flagHuman, false, "", 0)
+	if err != nil {
+		return err
+	}
+
+	from, err := snapshot.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	to, err := snapshot.Load(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return writeDiff(w, snapshot.Compare(from, to), format, opts)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/snapshot"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "old.json"), filepath.Join(dir, "new.json")
+	for name, cs := range map[string]stats.CacheStats{
+		from: {TotalKeys: 1, Stats: map[string]stats.BinStats{"render": {Keys: 1, Size: 10}}},
+		to:   {TotalKeys: 2, Stats: map[string]stats.BinStats{"render": {Keys: 2, Size: 30}}},
+	} {
+		if err := snapshot.New(cs).Save(name); err != nil {
+			t.Fatalf("failed saving snapshot: %v", err)
+		}
+	}
+
+	checks := [...]struct {
+		name     string
+		args     []string
+		expected string
+		expError bool
+	}{
+		{"text", []string{from, to}, "render |    2 |     +1 | +100.0 |   30 |    +20 | +200.0", false},
+		{"json", []string{"-json", from, to}, `"SizeDelta":20`, false},
+		{"missing arg", []string{from}, "", true},
+		{"missing file", []string{from, filepath.Join(dir, "missing.json")}, "", true},
+		{"bad flag", []string{"-nope", from, to}, "", true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			w := strings.Builder{}
+			err := runDiff(&w, check.args)
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(w.String(), check.expected) {
+				t.Errorf("did not find %q in:\n%s", check.expected, w.String())
+			}
+		})
+	}
+}
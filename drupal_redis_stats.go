@@ -6,24 +6,71 @@ It then return statistics about that instance, in plain text or JSON.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 
+	"github.com/fgm/drupal_redis_stats/cluster"
+	"github.com/fgm/drupal_redis_stats/fleet"
 	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/snapshot"
 	"github.com/fgm/drupal_redis_stats/stats"
+	"github.com/fgm/drupal_redis_stats/stats/progress"
 )
 
-func getVerboseWriter(quiet bool) io.Writer {
+func getVerboseWriter(quiet bool, w io.Writer) io.Writer {
 	if quiet {
 		return ioutil.Discard
 	}
-	return os.Stderr
+	return w
+}
+
+// getProgressWriter returns the logging output on which scans report progress,
+// in the given mode, on the given file descriptor, stderr being fd 2.
+//
+// The mode and descriptor are checked even when quiet, and stdin and stdout are
+// rejected, the latter because the progress would mix with the results.
+func getProgressWriter(mode string, fd int, quiet bool, stderr io.Writer) (io.Writer, error) {
+	if mode != "auto" && mode != "log" && mode != "json" {
+		return nil, fmt.Errorf("invalid -progress %q: expected auto, log, or json", mode)
+	}
+	if fd < 2 {
+		return nil, fmt.Errorf("invalid -progress-fd %d: expected 2 for stderr, or a descriptor above it", fd)
+	}
+	w := stderr
+	if fd != 2 {
+		f := os.NewFile(uintptr(fd), "progress-fd")
+		if f == nil {
+			return nil, fmt.Errorf("invalid -progress-fd %d", fd)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("invalid -progress-fd %d: %w", fd, err)
+		}
+		w = f
+	}
+	w = getVerboseWriter(quiet, w)
+	if w == ioutil.Discard {
+		return w, nil
+	}
+	switch mode {
+	case "log":
+		// Hide the *os.File, so progress.New does not draw a bar on terminals.
+		return struct{ io.Writer }{w}, nil
+	case "json":
+		return progress.NewJSON(w), nil
+	default:
+		return w, nil
+	}
 }
 
 func isFlagPassed(fs *flag.FlagSet, name string) bool {
@@ -36,59 +83,490 @@ func isFlagPassed(fs *flag.FlagSet, name string) bool {
 	return found
 }
 
-// open the Redis connection and authenticate is needed.
-func open(dsn *string, user string, pass string, fs *flag.FlagSet) (redis.Conn, error) {
-	var c redis.Conn
+// getOutputOptions validates the flags configuring the output format.
+func getOutputOptions(human string, percent bool, sort string, top int) (output.Options, error) {
+	opts := output.Options{Percent: percent, Top: top}
 	var err error
 
-	// Connect to the server (ex: DB #1, default #0).
-	if c, err = redis.DialURL(*dsn); err != nil {
-		return nil, fmt.Errorf("failed dialing Redis URL: %w", err)
+	if opts.Units, err = output.ParseUnits(human); err != nil {
+		return opts, err
+	}
+	if sort != "" {
+		if opts.SortBy, opts.Desc, err = stats.ParseSortSpec(sort); err != nil {
+			return opts, err
+		}
+	}
+	if top < 0 {
+		return opts, fmt.Errorf("invalid negative -top value: %d", top)
+	}
+	return opts, nil
+}
+
+// getThrottle returns the scan throttle for the flags, or nil if they do not
+// limit the scan.
+func getThrottle(rate float64, maxLatency time.Duration, busyOps, pauseOps int64) (*stats.Throttle, error) {
+	if rate < 0 || maxLatency < 0 || busyOps < 0 || pauseOps < 0 {
+		return nil, errors.New("-rate, -max-latency, -busy-ops and -pause-ops cannot be negative")
+	}
+	opts := stats.ThrottleOptions{Rate: rate, MaxLatency: maxLatency, BusyOps: busyOps, PauseOps: pauseOps}
+	if opts == (stats.ThrottleOptions{}) {
+		return nil, nil
+	}
+	return stats.NewThrottle(opts), nil
+}
+
+// open the Redis connection and authenticate if needed, returning the server
+// fields from the HELLO reply, if any, for server detection.
+func open(dsn string, user string, pass string, options ...redis.DialOption) (redis.Conn, map[string]string, error) {
+	// Connect to the server, then select the DSN database (ex: DB #1, default #0).
+	c, db, err := dial(dsn, options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed dialing Redis URL: %w", err)
 	}
+	fields, err := setup(c, db, user, pass)
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	return c, fields, nil
+}
 
-	if user != "" || pass != "" {
-		if err = authenticate(c, isFlagPassed(fs, "user"), user, pass); err != nil {
-			return nil, err
+// setup sets a new connection up, in the order accepted by servers requiring
+// authentication: HELLO, which authenticates, or AUTH on servers predating it,
+// then SELECT of the database. It returns the server fields from the HELLO
+// reply, if any.
+func setup(c redis.Conn, db int, user, pass string) (map[string]string, error) {
+	if pass == "" {
+		// Without a password, there is nothing to authenticate.
+		user = ""
+	}
+	fields, err := hello(c, user != "", user, pass)
+	if err != nil {
+		return nil, err
+	}
+	if db != 0 {
+		if _, err = c.Do("SELECT", db); err != nil {
+			return nil, fmt.Errorf("failed SELECT %d: %w", db, err)
 		}
 	}
-	return c, err
+	return fields, nil
 }
 
 func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr, os.LookupEnv); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run is the whole command, from flag parsing to output, taking the command
+// line arguments without the program name, and the environment lookup, like
+// os.LookupEnv.
+func run(args []string, stdout, stderr io.Writer, lookupEnv func(string) (string, bool)) error {
 	var user, pass string
 	var err error
 	var quiet bool
 
+	if len(args) > 0 {
+		switch args[0] {
+		case "diff":
+			return runDiff(stdout, args[1:])
+		case "generate":
+			return runGenerate(stdout, stderr, args[1:])
+		}
+	}
+
 	fs := flag.NewFlagSet("cli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
 	flagUser := fs.String("user", "", "user name if Redis is configured with ACL. Overrides the DSN user.")
 	flagPass := fs.String("pass", "", "Password. If it is empty it's asked from the tty. Overrides the DSN password.")
-	dsn := fs.String("dsn", "redis://localhost:6379/0", "Can include user and password, per https://www.iana.org/assignments/uri-schemes/prov/redis")
-	jsonOutput := fs.Bool("json", false, "Use JSON output.")
+	flagPassFile := fs.String("pass-file", "", "File containing the password, like a mounted secret. Overrides the DSN password.")
+	flagPassStdin := fs.Bool("pass-stdin", false, "Read the password from the first line of stdin. Overrides the DSN password.")
+	flagPassCommand := fs.String("pass-command", "", "Shell command printing the password on its stdout, like a secrets manager CLI. Overrides the DSN password.")
+	dsn := fs.String("dsn", "redis://localhost:6379/0", "Can include user and password, per https://www.iana.org/assignments/uri-schemes/prov/redis, be a unix:///path/to/socket?db=N socket, or designate sentinels as redis-sentinel://[user:pass@]host:port[,host:port...]/service[/db]")
+	flagSocket := fs.String("socket", "", "Path to the Redis unix socket. Overrides the DSN host, keeping its database and credentials.")
+	flagSentinel := fs.String("sentinel", "", "Comma-separated sentinel addresses, to query for the master address. Overrides the DSN sentinels.")
+	flagSentinelMaster := fs.String("sentinel-master", "", "Sentinel service name. Overrides the DSN service.")
+	flagSentinelUser := fs.String("sentinel-user", "", "Sentinel user name if sentinels are configured with ACL. Overrides the DSN sentinel user.")
+	flagSentinelPass := fs.String("sentinel-pass", "", "Sentinel password. Overrides the DSN sentinel password.")
+	jsonOutput := fs.Bool("json", false, "Use JSON output. Shorthand for -format json.")
+	flagFormat := fs.String("format", string(output.FormatText), "Output format: text, json, markdown, or html.")
+	flagHuman := fs.String("human", "", "Display sizes with iec (1.5 KiB) or si (1.5 kB) units.")
+	flagPercent := fs.Bool("percent", false, "Add percent-of-total columns to the text output.")
+	flagSort := fs.String("sort", "", "Sort bins by name, keys, or size. Prefix with - for descending order, like -size.")
+	flagTop := fs.Int("top", 0, "Only display the N first bins, collapsing the others into an \"(other)\" row.")
+	flagSave := fs.String("save", "", "Save the scan results as a JSON snapshot to this file.")
+	flagDiff := fs.String("diff", "", "Compare the scan results to this snapshot file instead of displaying them.")
+	var tlsOpts tlsOptions
+	fs.StringVar(&tlsOpts.caFile, "tls-ca", "", "PEM CA bundle used to verify the server certificate, instead of the system CAs.")
+	fs.StringVar(&tlsOpts.certFile, "tls-cert", "", "PEM client certificate, for mutual TLS. Requires -tls-key.")
+	fs.StringVar(&tlsOpts.keyFile, "tls-key", "", "PEM client private key, for mutual TLS. Requires -tls-cert.")
+	fs.StringVar(&tlsOpts.serverName, "tls-server-name", "", "Server name used to verify the server certificate, instead of the DSN host.")
+	fs.BoolVar(&tlsOpts.insecure, "tls-insecure", false, "Skip the verification of the server certificate. Insecure.")
+	flagCluster := fs.Bool("cluster", false, "Scan all primaries of a Redis Cluster, discovered from the DSN node.")
+	flagPerNode := fs.Bool("per-node", false, "With -cluster, also display the results of each node.")
+	flagAllDBs := fs.Bool("all-dbs", false, "Scan all non-empty databases, displaying the results of each database holding Drupal data, and their total.")
+	flagPrefix := fs.String("prefix", "", "Drupal cache prefix, per $settings['cache_prefix']. Defaults to the drupal.redis.<version> prefixes.")
+	flagScheme := fs.String("scheme", "auto", "Drupal cache key scheme: d7 for the Drupal 7 redis module, d8 for Drupal 8 and later, or auto to detect it.")
+	flagRDB := fs.String("rdb", "", "Analyze this RDB dump file, like dump.rdb, instead of scanning the DSN server, whose database is used.")
+	flagKeys := fs.String("keys", "", "Analyze this list of keys, one per line, optionally followed by a tab and their size in bytes, instead of scanning a server. Use - for stdin.")
+	flagInventory := fs.String("inventory", "", "Scan the targets listed in this YAML or JSON inventory file instead of the DSN.")
+	flagConcurrency := fs.Int("concurrency", 0, "Maximum number of inventory targets scanned at once. Overrides the inventory value.")
+	flagWatch := fs.Duration("watch", 0, "Rescan at this interval, like 10s, redrawing the results in place until Ctrl-C.")
+	flagCheckpoint := fs.String("checkpoint", "", "Save the scan state to this file at intervals, to continue an interrupted scan with -resume.")
+	flagCheckpointInterval := fs.Duration("checkpoint-interval", 10*time.Second, "Interval between -checkpoint saves.")
+	flagResume := fs.Bool("resume", false, "Continue the scan saved in the -checkpoint file, instead of starting over.")
+	flagRate := fs.Float64("rate", 0, "Maximum number of commands per second sent to Redis, each SCAN and MEMORY USAGE counting as one. 0 means no limit.")
+	flagMaxLatency := fs.Duration("max-latency", 0, "Back off while the SCAN latency exceeds this duration, like 50ms. 0 disables.")
+	flagBusyOps := fs.Int64("busy-ops", 0, "Back off while the server instantaneous_ops_per_sec exceeds this value. 0 disables.")
+	flagPauseOps := fs.Int64("pause-ops", 0, "Pause while the server instantaneous_ops_per_sec exceeds this value. 0 disables.")
+	flagTimeout := fs.Duration("timeout", 0, "Stop scanning after this duration, like 5m, displaying the partial results marked as incomplete. 0 means no limit.")
+	templatePath := fs.String("template", "", "Path to a Go text/template file used instead of the default text output.")
 	fs.BoolVar(&quiet, "q", false, "Do not display scan progress")
-	if err := fs.Parse(os.Args[1:]); err != nil {
-		log.Fatalf("failed parsing flags: %v", err)
+	flagProgress := fs.String("progress", "auto", "Scan progress display: auto for a bar on terminals and log lines otherwise, log, or json for NDJSON events.")
+	flagProgressFD := fs.Int("progress-fd", 2, "File descriptor on which to display scan progress and logs, like 3 with 3>progress.ndjson.")
+	fs.String("config", "", "YAML configuration file providing flag values. Defaults to "+defaultConfigPath()+" if it exists.")
+	fs.String("profile", "", "Named profile to use from the configuration file.")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("failed parsing flags: %w", err)
+	}
+	if err := applyEnvAndConfig(fs, lookupEnv); err != nil {
+		return fmt.Errorf("failed applying environment and configuration: %w", err)
 	}
 
-	verboseWriter := getVerboseWriter(quiet)
+	format, err := output.ParseFormat(*flagFormat)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		format = output.FormatJSON
+	}
+	// Reject unsupported diff and multi-part formats before scanning, not after.
+	if *flagDiff != "" {
+		if err := checkDiffFormat(format); err != nil {
+			return err
+		}
+	}
+	multiPart := *flagInventory != "" || (*flagCluster && *flagPerNode) ||
+		(*flagAllDBs && *flagWatch == 0 && *flagDiff == "" && *flagSave == "" && *templatePath == "")
+	if multiPart {
+		if err := checkSectionsFormat(format); err != nil {
+			return err
+		}
+	}
+	opts, err := getOutputOptions(*flagHuman, *flagPercent, *flagSort, *flagTop)
+	if err != nil {
+		return err
+	}
+	scheme, err := stats.ParseScheme(*flagScheme)
+	if err != nil {
+		return err
+	}
 
-	if user, pass, err = getCredentials(fs, os.Stdout, *dsn, *flagUser, *flagPass); err != nil {
-		log.Fatalf("failed obtaining user/pass: %v", err)
+	verboseWriter, err := getProgressWriter(*flagProgress, *flagProgressFD, quiet, stderr)
+	if err != nil {
+		return err
+	}
+	throttle, err := getThrottle(*flagRate, *flagMaxLatency, *flagBusyOps, *flagPauseOps)
+	if err != nil {
+		return err
+	}
+
+	if *flagResume && *flagCheckpoint == "" {
+		return errors.New("-resume needs the -checkpoint file to resume from")
+	}
+	if *flagWatch > 0 && (format != output.FormatText || *templatePath != "" || *flagSave != "" || *flagDiff != "") {
+		return errors.New("-watch only redraws the text output, and cannot be used with -json, -format, -template, -save or -diff")
+	}
+	if *flagPerNode && !*flagCluster {
+		return errors.New("-per-node can only be used with -cluster")
+	}
+	if *flagPerNode && (*flagWatch > 0 || *templatePath != "" || *flagSave != "" || *flagDiff != "") {
+		return errors.New("-per-node cannot be used with -watch, -template, -save or -diff, which only handle the cluster total")
+	}
+	if *flagCheckpoint != "" && (*flagInventory != "" || *flagAllDBs || *flagCluster || *flagWatch > 0) {
+		return errors.New("-checkpoint can only be used to scan a single database, not with -inventory, -all-dbs, -cluster or -watch")
+	}
+
+	if *flagRDB != "" || *flagKeys != "" {
+		if *flagRDB != "" && *flagKeys != "" {
+			return errors.New("-rdb and -keys cannot be used together")
+		}
+		if *flagInventory != "" || *flagCluster || *flagWatch > 0 || *flagCheckpoint != "" || throttle != nil {
+			return errors.New("-rdb and -keys cannot be used with -inventory, -cluster, -watch, -checkpoint, -rate, -max-latency, -busy-ops and -pause-ops")
+		}
+		ctx, stop := interruptible()
+		defer stop()
+		ctx, cancel := withTimeout(ctx, *flagTimeout)
+		defer cancel()
+		if *flagKeys != "" {
+			if *flagAllDBs {
+				return errors.New("-all-dbs cannot be used with -keys, whose keys have no database")
+			}
+			r, err := openKeys(*flagKeys)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			total, err := scanKeys(ctx, r, *flagPrefix, scheme)
+			if err != nil && !total.Incomplete {
+				return err
+			}
+			return report(stdout, total, err, *flagSave, *flagDiff, *templatePath, format, opts)
+		}
+		db, err := dsnDatabase(*dsn)
+		if err != nil {
+			return err
+		}
+		perDatabase := *flagAllDBs && *flagDiff == "" && *flagSave == "" && *templatePath == ""
+		total, dbs, err := scanRDB(ctx, *flagRDB, *flagPrefix, scheme, db, *flagAllDBs, verboseWriter)
+		if err != nil && !total.Incomplete {
+			return err
+		}
+		if perDatabase {
+			if err := writeDatabases(stdout, total, dbs, format, opts); err != nil {
+				return fmt.Errorf("failed rendering output: %w", err)
+			}
+			return incompleteError(total, err)
+		}
+		return report(stdout, total, err, *flagSave, *flagDiff, *templatePath, format, opts)
 	}
 
-	c, err := open(dsn, user, pass, fs)
+	if *flagInventory != "" {
+		if *flagTimeout != 0 || throttle != nil {
+			return errors.New("-timeout, -rate, -max-latency, -busy-ops and -pause-ops cannot be used with -inventory")
+		}
+		if *flagSave != "" || *flagDiff != "" || *templatePath != "" || *flagWatch > 0 || *flagCluster || *flagAllDBs {
+			return errors.New("-save, -diff, -template, -watch, -cluster and -all-dbs cannot be used with -inventory")
+		}
+		inv, err := fleet.LoadInventory(*flagInventory)
+		if err != nil {
+			return err
+		}
+		// -prefix and -scheme apply to the targets which do not set their own.
+		for i, t := range inv.Targets {
+			if t.Prefix == "" {
+				inv.Targets[i].Prefix = *flagPrefix
+			}
+			if t.Scheme == "" {
+				inv.Targets[i].Scheme = scheme
+			}
+		}
+		dialOptions, err := tlsOpts.dialOptions()
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptible()
+		defer stop()
+		results := fleet.ScanContext(ctx, inv, *flagConcurrency, fleetDialer(dialOptions...), verboseWriter)
+		if err = writeFleet(stdout, results, format, opts); err != nil {
+			return fmt.Errorf("failed rendering output: %w", err)
+		}
+		return incompleteError(fleet.Summarize(results).Total, ctx.Err())
+	}
+
+	sc, useSentinel, err := getSentinelConfig(fs, *dsn, *flagSentinel, *flagSentinelMaster, *flagSentinelUser, *flagSentinelPass)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("invalid sentinel configuration: %w", err)
+	}
+	if useSentinel {
+		if *dsn, err = resolveSentinel(sc, dialSentinel); err != nil {
+			return err
+		}
+	}
+
+	if *flagSocket != "" {
+		if *dsn, err = socketDSN(*dsn, *flagSocket); err != nil {
+			return err
+		}
+	}
+
+	passReader, err := getPasswordReader(fs, *flagPassFile, *flagPassStdin, *flagPassCommand)
+	if err != nil {
+		return err
+	}
+	if user, pass, err = getCredentials(fs, stdout, *dsn, *flagUser, *flagPass, passReader); err != nil {
+		return fmt.Errorf("failed obtaining user/pass: %w", err)
+	}
+
+	dialOptions, err := getDialOptions(*dsn, tlsOpts)
+	if err != nil {
+		return err
+	}
+
+	c, helloFields, err := open(*dsn, user, pass, dialOptions...)
+	if err != nil {
+		return err
 	}
 	defer c.Close()
+	client := stats.NewRedigoClient(c)
+	server := stats.DetectServer(context.Background(), client, helloFields)
+
+	ctx, stop := interruptible()
+	defer stop()
+
+	// Rescans, like those of -watch, reuse the schemes detected on each target.
+	schemes := stats.SchemeCache{}
+	scan := func(ctx context.Context) (cs stats.CacheStats, err error) {
+		cs.Prefix = *flagPrefix
+		cs.Scheme = schemes.Scheme("", scheme)
+		cs.Server = &server
+		cs.Throttle = throttle
+		err = cs.ScanContext(ctx, client, 0, verboseWriter)
+		schemes.Store("", cs.Scheme)
+		return cs, err
+	}
+	if *flagCheckpoint != "" {
+		target, err := checkpointTarget(*dsn)
+		if err != nil {
+			return err
+		}
+		cp := checkpointer{path: *flagCheckpoint, target: target, interval: *flagCheckpointInterval, client: client, w: verboseWriter}
+		initial := stats.CacheStats{Prefix: *flagPrefix, Scheme: scheme}
+		if *flagResume {
+			if initial, err = cp.load(*flagPrefix, scheme); err != nil {
+				return err
+			}
+		}
+		scan = func(ctx context.Context) (stats.CacheStats, error) {
+			cs := initial
+			cs.Server = &server
+			cs.Throttle = throttle
+			err := cp.scan(ctx, &cs)
+			return cs, err
+		}
+	}
+	if *flagAllDBs {
+		if *flagCluster {
+			return errors.New("-all-dbs cannot be used with -cluster, which only has database 0")
+		}
+		if *flagWatch == 0 && *flagDiff == "" && *flagSave == "" && *templatePath == "" {
+			ctx, cancel := withTimeout(ctx, *flagTimeout)
+			defer cancel()
+			total, dbs, err := stats.ScanDatabasesContext(ctx, stats.NewRedigoClient(c), *flagPrefix, scheme, nil, throttle, verboseWriter)
+			if err != nil && !total.Incomplete {
+				return fmt.Errorf("failed SCAN: %w", err)
+			}
+			if err := writeDatabases(stdout, total, dbs, format, opts); err != nil {
+				return fmt.Errorf("failed rendering output: %w", err)
+			}
+			return incompleteError(total, err)
+		}
+		scan = func(ctx context.Context) (stats.CacheStats, error) {
+			total, _, err := stats.ScanDatabasesContext(ctx, stats.NewRedigoClient(c), *flagPrefix, scheme, schemes, throttle, verboseWriter)
+			return total, err
+		}
+	}
+	if *flagCluster {
+		dial, err := clusterDialer(*dsn, user, pass, dialOptions...)
+		if err != nil {
+			return err
+		}
+		if *flagPerNode {
+			ctx, cancel := withTimeout(ctx, *flagTimeout)
+			defer cancel()
+			nodes, err := cluster.Nodes(c)
+			if err != nil {
+				return fmt.Errorf("failed cluster SCAN: %w", err)
+			}
+			res, err := cluster.ScanContext(ctx, nodes, dial, *flagPrefix, scheme, nil, throttle, verboseWriter)
+			if err != nil && !res.Total.Incomplete {
+				return fmt.Errorf("failed cluster SCAN: %w", err)
+			}
+			if err := writeClusterNodes(stdout, res, format, opts); err != nil {
+				return fmt.Errorf("failed rendering output: %w", err)
+			}
+			return incompleteError(res.Total, err)
+		}
+		scan = func(ctx context.Context) (stats.CacheStats, error) {
+			// Nodes are discovered again, to follow failovers while watching.
+			nodes, err := cluster.Nodes(c)
+			if err != nil {
+				return stats.CacheStats{}, err
+			}
+			res, err := cluster.ScanContext(ctx, nodes, dial, *flagPrefix, scheme, schemes, throttle, verboseWriter)
+			return res.Total, err
+		}
+	}
 
-	var stats stats.CacheStats
-	if err = stats.Scan(c, 0, verboseWriter); err != nil {
-		log.Fatalf("failed SCAN: %v", err)
+	if *flagWatch > 0 {
+		if err = watch(ctx, stdout, *flagWatch, scanWithTimeout(scan, *flagTimeout), opts); err != nil {
+			return fmt.Errorf("failed watching: %w", err)
+		}
+		return nil
 	}
 
-	if *jsonOutput {
-		_ = output.JSON(os.Stderr, &stats)
-	} else {
-		output.Text(os.Stdout, &stats)
+	stats, scanErr := scanWithTimeout(scan, *flagTimeout)(ctx)
+	if scanErr != nil && !stats.Incomplete {
+		return fmt.Errorf("failed SCAN: %w", scanErr)
+	}
+	return report(stdout, stats, scanErr, *flagSave, *flagDiff, *templatePath, format, opts)
+}
+
+// report outputs the results of a scan, after saving them to a snapshot file
+// if save is set, as a comparison to the diff snapshot file if it is set, with
+// a template if templatePath is set, or in the given format otherwise.
+//
+// It returns the error ending the command for an incomplete scan, stopped by
+// scanErr.
+func report(w io.Writer, cs stats.CacheStats, scanErr error, save, diff, templatePath string, format output.Format, opts output.Options) error {
+	var err error
+	if save != "" {
+		if err = snapshot.New(cs).Save(save); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case diff != "":
+		var old snapshot.Snapshot
+		if old, err = snapshot.Load(diff); err == nil {
+			err = writeDiff(w, snapshot.Compare(old, snapshot.New(cs)), format, opts)
+		}
+	case templatePath != "":
+		err = output.Template(w, &cs, templatePath, opts)
+	default:
+		err = output.Write(w, &cs, format, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed rendering output: %w", err)
+	}
+	return incompleteError(cs, scanErr)
+}
+
+// interruptible returns a context canceled on Ctrl-C or SIGTERM, on which scans
+// stop and the partial results are displayed. Restoring the default behavior
+// then allows a second Ctrl-C to kill the command at once.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// withTimeout returns a context canceled after the timeout, if it is not 0.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// scanWithTimeout limits each scan to the timeout, if it is not 0.
+func scanWithTimeout(scan scanFunc, timeout time.Duration) scanFunc {
+	return func(ctx context.Context) (stats.CacheStats, error) {
+		ctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+		return scan(ctx)
+	}
+}
+
+// incompleteError returns the error ending the command after the partial
+// results of an interrupted scan are displayed, or nil for complete results.
+func incompleteError(cs stats.CacheStats, err error) error {
+	if !cs.Incomplete {
+		return nil
 	}
+	return fmt.Errorf("incomplete scan, covering %.1f%% of keys: %w", cs.Coverage(), err)
 }
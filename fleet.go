@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/fleet"
+	"github.com/fgm/drupal_redis_stats/output"
+)
+
+// fleetDialer returns a dialer connecting to fleet targets, with the
+// credentials from the target, or from its DSN if the target has none.
+func fleetDialer(options ...redis.DialOption) fleet.Dialer {
+	return func(t fleet.Target) (redis.Conn, error) {
+		u, err := url.Parse(t.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing Redis DSN: %w", err)
+		}
+		user, pass := t.User, u.User.Username()
+		if dsnPass, ok := u.User.Password(); ok {
+			if user == "" {
+				user = u.User.Username()
+			}
+			pass = dsnPass
+		}
+		if t.PasswordEnv != "" || t.PasswordFile != "" {
+			if pass, err = t.Password(); err != nil {
+				return nil, err
+			}
+		}
+		c, db, err := dial(t.DSN, options...)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = setup(c, db, user, pass); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// writeFleet outputs the results of each target, followed by the fleet
+// summary. Unreachable targets are reported with their error.
+func writeFleet(w io.Writer, results []fleet.Result, format output.Format, opts output.Options) error {
+	sections := make([]section, len(results))
+	for i, res := range results {
+		sections[i] = section{Title: "Target " + res.Target, Stats: &results[i].Stats, Err: res.Err}
+	}
+	summary := fleet.Summarize(results)
+	total := section{
+		Title: fmt.Sprintf("Fleet: %d targets, %d failed", summary.Targets, summary.Failed),
+		Stats: &summary.Total,
+	}
+	v := struct {
+		Targets []fleet.Result
+		Summary fleet.Summary
+	}{results, summary}
+	return writeSections(w, sections, total, v, format, opts)
+}
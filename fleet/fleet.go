@@ -0,0 +1,230 @@
+/*
+Package fleet scans multiple Drupal Redis instances listed in an inventory.
+*/
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+/*
+DefaultConcurrency is the number of targets scanned in parallel when neither
+the inventory nor the caller specify it.
+*/
+const DefaultConcurrency = 4
+
+/*
+Target describes a Redis instance in the inventory.
+
+Passwords are not stored in the inventory, but referenced: either as the name
+of an environment variable, or as the path of a file containing them.
+*/
+type Target struct {
+	Name         string       `yaml:"name"`
+	DSN          string       `yaml:"dsn"`
+	User         string       `yaml:"user"`
+	PasswordEnv  string       `yaml:"password_env"`
+	PasswordFile string       `yaml:"password_file"`
+	Prefix       string       `yaml:"prefix"`
+	Scheme       stats.Scheme `yaml:"scheme"` // d7, d8, or auto, the default.
+}
+
+/*
+Password resolves the password reference of the target, if any.
+*/
+func (t Target) Password() (string, error) {
+	switch {
+	case t.PasswordEnv != "" && t.PasswordFile != "":
+		return "", errors.New("password_env and password_file are mutually exclusive")
+	case t.PasswordEnv != "":
+		pass, ok := os.LookupEnv(t.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", t.PasswordEnv)
+		}
+		return pass, nil
+	case t.PasswordFile != "":
+		pass, err := os.ReadFile(t.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed reading password file: %w", err)
+		}
+		return strings.TrimRight(string(pass), "\r\n"), nil
+	default:
+		return "", nil
+	}
+}
+
+/*
+Inventory lists the targets to scan.
+*/
+type Inventory struct {
+	Concurrency int      `yaml:"concurrency"`
+	Targets     []Target `yaml:"targets"`
+}
+
+/*
+LoadInventory reads an inventory in YAML format, or JSON, which is a subset of
+YAML.
+*/
+func LoadInventory(path string) (Inventory, error) {
+	var inv Inventory
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return inv, fmt.Errorf("failed reading inventory: %w", err)
+	}
+	if err = yaml.Unmarshal(data, &inv); err != nil {
+		return inv, fmt.Errorf("failed parsing inventory %s: %w", path, err)
+	}
+	names := make(map[string]bool, len(inv.Targets))
+	for i, t := range inv.Targets {
+		switch {
+		case t.Name == "":
+			return inv, fmt.Errorf("target #%d has no name", i+1)
+		case names[t.Name]:
+			return inv, fmt.Errorf("duplicate target name %q", t.Name)
+		case t.DSN == "":
+			return inv, fmt.Errorf("target %q has no DSN", t.Name)
+		case t.Scheme != "":
+			if inv.Targets[i].Scheme, err = stats.ParseScheme(string(t.Scheme)); err != nil {
+				return inv, fmt.Errorf("target %q: %w", t.Name, err)
+			}
+		}
+		names[t.Name] = true
+	}
+	return inv, nil
+}
+
+/*
+Result holds the scan results for a target, or the error which prevented
+scanning it.
+*/
+type Result struct {
+	Target string
+	Stats  stats.CacheStats
+	Err    error  `json:"-"`
+	Error  string `json:",omitempty"` // Err, in serializable form.
+}
+
+/*
+Dialer opens an authenticated connection to a target.
+*/
+type Dialer func(t Target) (redis.Conn, error)
+
+/*
+Scan scans all targets in the inventory, at most concurrency of them at once,
+0 meaning the inventory concurrency, or DefaultConcurrency.
+
+A failing target does not interrupt the scan of the others: its error is
+reported in its result. The results are in inventory order.
+
+  - w is a logging output (think os.Stderr), not the main output.
+*/
+func Scan(inv Inventory, concurrency int, dial Dialer, w io.Writer) []Result {
+	return ScanContext(context.Background(), inv, concurrency, dial, w)
+}
+
+/*
+ScanContext is like Scan, but stops when ctx is done: the targets being scanned
+then keep their partial results, marked incomplete, and the targets not yet
+scanned are marked incomplete with the ctx error.
+*/
+func ScanContext(ctx context.Context, inv Inventory, concurrency int, dial Dialer, w io.Writer) []Result {
+	if concurrency <= 0 {
+		concurrency = inv.Concurrency
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	results := make([]Result, len(inv.Targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var logMu sync.Mutex
+	for i, t := range inv.Targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := scanTarget(ctx, t, dial)
+			results[i] = res
+
+			logMu.Lock()
+			defer logMu.Unlock()
+			if res.Err != nil {
+				fmt.Fprintf(w, "%s: %v\n", t.Name, res.Err)
+			} else {
+				fmt.Fprintf(w, "%s: %d bins\n", t.Name, len(res.Stats.Stats))
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+func scanTarget(ctx context.Context, t Target, dial Dialer) (res Result) {
+	res.Target = t.Name
+	defer func() {
+		// Do not let a panic on one target bring down the whole fleet scan.
+		if r := recover(); r != nil {
+			res.Err = fmt.Errorf("failed scanning: %v", r)
+		}
+		if res.Err != nil {
+			res.Error = res.Err.Error()
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		res.Stats.Incomplete = true
+		res.Err = fmt.Errorf("not scanned: %w", err)
+		return res
+	}
+	c, err := dial(t)
+	if err != nil {
+		res.Err = fmt.Errorf("failed connecting: %w", err)
+		return res
+	}
+	defer c.Close()
+	res.Stats.Prefix, res.Stats.Scheme = t.Prefix, t.Scheme
+	if t.Scheme == "" {
+		res.Stats.Scheme = stats.SchemeAuto
+	}
+	// Progress bars from concurrent scans would be garbled, so discard them.
+	if err = res.Stats.ScanContext(ctx, stats.NewRedigoClient(c), 0, io.Discard); err != nil {
+		res.Err = fmt.Errorf("failed scanning: %w", err)
+	}
+	return res
+}
+
+/*
+Summary aggregates the results of all reachable targets, including the partial
+results of interrupted scans, which make the total incomplete.
+*/
+type Summary struct {
+	Targets, Failed int
+	Total           stats.CacheStats
+}
+
+/*
+Summarize computes the fleet summary from the per-target results.
+*/
+func Summarize(results []Result) Summary {
+	s := Summary{Targets: len(results), Total: stats.CacheStats{Stats: map[string]stats.BinStats{}}}
+	for _, res := range results {
+		if res.Err != nil && !res.Stats.Incomplete {
+			s.Failed++
+			continue
+		}
+		s.Total.Merge(res.Stats)
+	}
+	return s
+}
@@ -0,0 +1,222 @@
+package fleet_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/fleet"
+	"github.com/fgm/drupal_redis_stats/redistest"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// newServer starts a server holding keys using 100 bytes each.
+func newServer(t *testing.T, keys ...string) *redistest.Server {
+	s := redistest.NewServer()
+	t.Cleanup(s.Close)
+	for _, key := range keys {
+		s.SetSized(0, key, 100)
+	}
+	return s
+}
+
+func writeInventory(t *testing.T, name, contents string) string {
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed writing inventory: %v", err)
+	}
+	return p
+}
+
+func TestLoadInventory(t *testing.T) {
+	expected := fleet.Inventory{
+		Concurrency: 2,
+		Targets: []fleet.Target{
+			{Name: "a", DSN: "redis://a/0", PasswordEnv: "A_PASS"},
+			{Name: "b", DSN: "redis://b/1", User: "u", PasswordFile: "/run/b", Prefix: "b", Scheme: stats.SchemeD7},
+		},
+	}
+	checks := [...]struct {
+		name, contents string
+	}{
+		{"inventory.yml", `
+concurrency: 2
+targets:
+  - name: a
+    dsn: redis://a/0
+    password_env: A_PASS
+  - name: b
+    dsn: redis://b/1
+    user: u
+    password_file: /run/b
+    prefix: b
+    scheme: D7
+`},
+		{"inventory.json", `{"concurrency": 2, "targets": [
+  {"name": "a", "dsn": "redis://a/0", "password_env": "A_PASS"},
+  {"name": "b", "dsn": "redis://b/1", "user": "u", "password_file": "/run/b", "prefix": "b", "scheme": "d7"}
+]}`},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := fleet.LoadInventory(writeInventory(t, check.name, check.contents))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("got %+v, expected %+v", actual, expected)
+			}
+		})
+	}
+}
+
+func TestLoadInventorySad(t *testing.T) {
+	for name, contents := range map[string]string{
+		"syntax":    "targets: [",
+		"no name":   "targets: [{dsn: redis://a}]",
+		"no dsn":    "targets: [{name: a}]",
+		"duplicate": "targets: [{name: a, dsn: redis://a}, {name: a, dsn: redis://b}]",
+		"scheme":    "targets: [{name: a, dsn: redis://a, scheme: d6}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := fleet.LoadInventory(writeInventory(t, "inventory.yml", contents)); err == nil {
+				t.Error("unexpected success")
+			}
+		})
+	}
+	if _, err := fleet.LoadInventory(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+		t.Error("unexpected success loading missing inventory")
+	}
+}
+
+func TestTargetPassword(t *testing.T) {
+	t.Setenv("FLEET_TEST_PASS", "env-pass")
+	file := writeInventory(t, "pass", "file-pass\n")
+	checks := [...]struct {
+		name     string
+		target   fleet.Target
+		expected string
+		expError bool
+	}{
+		{"none", fleet.Target{}, "", false},
+		{"env", fleet.Target{PasswordEnv: "FLEET_TEST_PASS"}, "env-pass", false},
+		{"file", fleet.Target{PasswordFile: file}, "file-pass", false},
+		{"missing env", fleet.Target{PasswordEnv: "FLEET_TEST_MISSING"}, "", true},
+		{"missing file", fleet.Target{PasswordFile: file + ".missing"}, "", true},
+		{"both", fleet.Target{PasswordEnv: "FLEET_TEST_PASS", PasswordFile: file}, "", true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := check.target.Password()
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != check.expected {
+				t.Errorf("got %q, expected %q", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	inv := fleet.Inventory{Targets: []fleet.Target{
+		{Name: "a", DSN: "redis://a"},
+		{Name: "down", DSN: "redis://down"},
+		{Name: "b", DSN: "redis://b", Prefix: "site-b"},
+	}}
+	servers := map[string]*redistest.Server{
+		"a": newServer(t, "drupal.redis.10.1.0:render:x"),
+		"b": newServer(t, "site-b:page:x", "site-b:page:y"),
+	}
+	var active, maxActive int32
+	dial := func(t fleet.Target) (redis.Conn, error) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		if t.Name == "down" {
+			return nil, errors.New("connection refused")
+		}
+		return redis.DialURL(servers[t.Name].DSN(0))
+	}
+	log := strings.Builder{}
+	results := fleet.Scan(inv, 1, dial, &log)
+
+	if maxActive != 1 {
+		t.Errorf("got concurrency %d, expected 1", maxActive)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, expected 3", len(results))
+	}
+	for i, name := range []string{"a", "down", "b"} {
+		if results[i].Target != name {
+			t.Errorf("got target %s at %d, expected %s", results[i].Target, i, name)
+		}
+	}
+	if results[1].Err == nil || results[1].Error == "" {
+		t.Errorf("unreachable target did not report an error")
+	}
+	if !strings.Contains(log.String(), "down: failed connecting") {
+		t.Errorf("unexpected log: %s", log.String())
+	}
+
+	summary := fleet.Summarize(results)
+	expected := fleet.Summary{Targets: 3, Failed: 1, Total: stats.CacheStats{
+		TotalKeys: 3,
+		Server:    &stats.Server{Product: stats.ProductRedis, Version: redistest.DefaultVersion, Mode: "standalone", MemoryUsage: true},
+		Stats:     map[string]stats.BinStats{"render": {Keys: 1, Size: 100}, "page": {Keys: 2, Size: 200}},
+	}}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("got %+v, expected %+v", summary, expected)
+	}
+}
+
+func TestScanMemoryFailure(t *testing.T) {
+	inv := fleet.Inventory{Targets: []fleet.Target{{Name: "a", DSN: "redis://a"}}}
+	s := newServer(t, "drupal.redis.10.1.0:render:x")
+	s.Hangup("MEMORY")
+	dial := func(fleet.Target) (redis.Conn, error) {
+		return redis.DialURL(s.DSN(0))
+	}
+	results := fleet.Scan(inv, 0, dial, io.Discard)
+	if results[0].Err == nil {
+		t.Error("failing MEMORY USAGE did not report an error")
+	}
+}
+
+func TestScanContextCanceled(t *testing.T) {
+	inv := fleet.Inventory{Targets: []fleet.Target{{Name: "a", DSN: "redis://a"}, {Name: "b", DSN: "redis://b"}}}
+	dial := func(fleet.Target) (redis.Conn, error) {
+		return nil, errors.New("unexpected dial")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := fleet.ScanContext(ctx, inv, 0, dial, io.Discard)
+	for _, res := range results {
+		if !res.Stats.Incomplete || !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("%s: got incomplete %t and error %v, expected an incomplete canceled result", res.Target, res.Stats.Incomplete, res.Err)
+		}
+	}
+	summary := fleet.Summarize(results)
+	if summary.Failed != 0 || !summary.Total.Incomplete {
+		t.Errorf("got %d failed and incomplete %t, expected 0 failed and an incomplete total", summary.Failed, summary.Total.Incomplete)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/fleet"
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func TestWriteFleet(t *testing.T) {
+	results := []fleet.Result{
+		{Target: "up", Stats: stats.CacheStats{TotalKeys: 1, Stats: map[string]stats.BinStats{"render": {Keys: 1, Size: 10}}}},
+		{Target: "down", Err: errors.New("connection refused"), Error: "connection refused"},
+	}
+	w := strings.Builder{}
+	if err := writeFleet(&w, results, output.FormatText, output.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"Target up\n\nBin",
+		"Target down\n\nError: connection refused\n",
+		"Fleet: 2 targets, 1 failed\n\nBin",
+	} {
+		if !strings.Contains(w.String(), expected) {
+			t.Errorf("did not find %q in:\n%s", expected, w.String())
+		}
+	}
+}
+
+func TestFleetDialerSad(t *testing.T) {
+	dial := fleetDialer()
+	for name, target := range map[string]fleet.Target{
+		"bad dsn":          {DSN: ":bad"},
+		"missing password": {DSN: "redis://localhost:1/0", PasswordEnv: "FLEET_TEST_MISSING"},
+		"unreachable":      {DSN: "redis://127.0.0.1:1/0"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := dial(target); err == nil {
+				t.Error("unexpected success")
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/generate"
+)
+
+// runGenerate implements the generate subcommand, filling a database with a
+// synthetic Drupal cache dataset.
+func runGenerate(stdout, stderr io.Writer, args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dsn := fs.String("dsn", "redis://localhost:6379/0", "Redis database to fill. Can include user and password.")
+	flagUser := fs.String("user", "", "user name if Redis is configured with ACL. Overrides the DSN user.")
+	flagPass := fs.String("pass", "", "Password. If it is empty it's asked from the tty. Overrides the DSN password.")
+	flagPrefix := fs.String("prefix", generate.DefaultPrefix, "Drupal cache prefix of the generated keys.")
+	flagBins := fs.String("bins", "", "Items per bin, like render=1000,page=200. Defaults to a mix of core bins with 10000 items.")
+	flagSeed := fs.Int64("seed", 1, "Random seed: the same seed and flags generate the same dataset.")
+	flagExpire := fs.Float64("expire-ratio", 0.1, "Ratio of items with an expiration time, from 0 to 1.")
+	flagScale := fs.Float64("size-scale", 1, "Multiplier of the typical data sizes of each bin.")
+	flagForce := fs.Bool("force", false, "Write to the database even if it is not empty.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: drupal_redis_stats generate [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("failed parsing flags: %w", err)
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("generate does not take arguments")
+	}
+	if *flagExpire < 0 || *flagExpire > 1 {
+		return fmt.Errorf("invalid expire ratio %g: use a value from 0 to 1", *flagExpire)
+	}
+	if *flagScale <= 0 {
+		return fmt.Errorf("invalid size scale %g: use a positive value", *flagScale)
+	}
+	opts := generate.Options{
+		Prefix:      *flagPrefix,
+		Seed:        *flagSeed,
+		ExpireRatio: *flagExpire,
+		SizeScale:   *flagScale,
+	}
+	if *flagBins != "" {
+		bins, err := generate.ParseBins(*flagBins)
+		if err != nil {
+			return err
+		}
+		opts.Bins = bins
+	}
+
+	user, pass, err := getCredentials(fs, stdout, *dsn, *flagUser, *flagPass, nil)
+	if err != nil {
+		return fmt.Errorf("failed obtaining user/pass: %w", err)
+	}
+	c, _, err := open(*dsn, user, pass)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// Refuse to mix test data with actual data, like that of a production site.
+	size, err := redis.Int64(c.Do("DBSIZE"))
+	if err != nil {
+		return fmt.Errorf("failed DBSIZE: %w", err)
+	}
+	if size > 0 && !*flagForce {
+		return fmt.Errorf("database is not empty, with %d keys: use -force to write to it anyway", size)
+	}
+
+	start := time.Now()
+	n, err := generate.Fill(c, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Generated %d cache items in %v\n", n, time.Since(start).Round(time.Millisecond))
+	return nil
+}
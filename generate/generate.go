@@ -0,0 +1,313 @@
+/*
+Package generate builds synthetic Drupal cache datasets, shaped like those of
+the Drupal redis module, to benchmark and test the scanner reproducibly.
+
+Items use cid shapes typical of each core bin, like render arrays keyed by cache
+contexts, entity values, or page URLs, with matching cache tags. Data sizes
+follow a log-normal distribution around a median typical of each bin, and a
+configurable ratio of items has an expiration time.
+
+The same options, including the seed, always generate the same items.
+*/
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Size limits of the generated data, in bytes.
+const (
+	minSize = 16
+	maxSize = 4 << 20
+)
+
+// batchSize is the number of items written per pipeline round trip.
+const batchSize = 500
+
+/*
+DefaultBins is a bin mix like that of a mid-sized Drupal site, with 10000 items.
+*/
+var DefaultBins = map[string]int{
+	"bootstrap":          50,
+	"config":             800,
+	"data":               300,
+	"default":            100,
+	"discovery":          300,
+	"dynamic_page_cache": 1500,
+	"entity":             1200,
+	"menu":               150,
+	"page":               1500,
+	"render":             3900,
+	"toolbar":            200,
+}
+
+/*
+Options configures the generated dataset.
+*/
+type Options struct {
+	// Prefix is the cache key prefix, defaulting to DefaultPrefix.
+	Prefix string
+	// Bins holds the number of items per bin, defaulting to DefaultBins.
+	Bins map[string]int
+	// Seed makes the dataset reproducible: the same seed generates the same items.
+	Seed int64
+	// ExpireRatio is the ratio of items with an expiration time, from 0 to 1.
+	ExpireRatio float64
+	// SizeScale multiplies the typical data sizes, defaulting to 1.
+	SizeScale float64
+	// Now is the reference time for creation and expiration times, defaulting
+	// to the current time.
+	Now time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.Prefix == "" {
+		o.Prefix = DefaultPrefix
+	}
+	if o.Bins == nil {
+		o.Bins = DefaultBins
+	}
+	if o.SizeScale <= 0 {
+		o.SizeScale = 1
+	}
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	return o
+}
+
+// profile describes the items of a bin.
+type profile struct {
+	median int // Median data size, in bytes.
+	cid    func(r *rand.Rand, i int) string
+	tags   func(r *rand.Rand, i int) []string
+}
+
+// hash returns a hex hash like those Drupal appends to cids, derived from r.
+func hash(r *rand.Rand) string {
+	var seed [8]byte
+	r.Read(seed[:])
+	sum := sha256.Sum256(seed[:])
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	entityTypes = []string{"node", "node", "node", "user", "taxonomy_term", "media", "block_content"}
+	viewModes   = []string{"full", "teaser", "default", "search_result"}
+	themes      = []string{"olivero", "claro"}
+	paths       = []string{"/node/%d", "/taxonomy/term/%d", "/user/%d", "/articles?page=%d", "/search/node?keys=term%d"}
+)
+
+func pick(r *rand.Rand, values []string) string {
+	return values[r.Intn(len(values))]
+}
+
+// profiles holds the profiles of core bins, other bins using defaultProfile.
+var profiles = map[string]profile{
+	"bootstrap": {4096,
+		func(r *rand.Rand, i int) string { return fmt.Sprintf("hook_info:%d", i) },
+		func(r *rand.Rand, i int) []string { return nil }},
+	"config": {600,
+		func(r *rand.Rand, i int) string {
+			return fmt.Sprintf("field.field.%s.bundle_%d.field_%d", pick(r, entityTypes), i%20, i)
+		},
+		func(r *rand.Rand, i int) []string { return nil }},
+	"data": {8192,
+		func(r *rand.Rand, i int) string { return fmt.Sprintf("views_data:table_%d:en", i) },
+		func(r *rand.Rand, i int) []string { return []string{"views_data", "entity_field_info"} }},
+	"discovery": {12000,
+		func(r *rand.Rand, i int) string {
+			return fmt.Sprintf("entity_bundle_field_definitions:%s:bundle_%d:en", pick(r, entityTypes), i)
+		},
+		func(r *rand.Rand, i int) []string { return []string{"entity_field_info", "entity_bundles"} }},
+	"dynamic_page_cache": {6000,
+		func(r *rand.Rand, i int) string {
+			return fmt.Sprintf("response:[request_format]=html:[route]=entity.node.canonical.%d:%s", i, hash(r))
+		},
+		func(r *rand.Rand, i int) []string {
+			return []string{"node:" + strconv.Itoa(i), "node_view", "route_match", "rendered"}
+		}},
+	"entity": {3000,
+		func(r *rand.Rand, i int) string { return fmt.Sprintf("values:%s:%d", pick(r, entityTypes), i) },
+		func(r *rand.Rand, i int) []string { return []string{"entity_field_info"} }},
+	"menu": {2000,
+		func(r *rand.Rand, i int) string { return fmt.Sprintf("links:main:tree-data:en:%d:%s", i, hash(r)) },
+		func(r *rand.Rand, i int) []string { return []string{"config:system.menu.main"} }},
+	"page": {25000,
+		func(r *rand.Rand, i int) string {
+			return "http://example.com" + fmt.Sprintf(pick(r, paths), i) + ":html"
+		},
+		func(r *rand.Rand, i int) []string {
+			return []string{"config:system.site", "http_response", "node:" + strconv.Itoa(i), "rendered"}
+		}},
+	"render": {2500,
+		func(r *rand.Rand, i int) string {
+			return fmt.Sprintf("entity_view:%s:%d:%s:[languages:language_interface]=en:[theme]=%s:[user.permissions]=%s",
+				pick(r, entityTypes), i, pick(r, viewModes), pick(r, themes), hash(r))
+		},
+		func(r *rand.Rand, i int) []string {
+			return []string{"config:filter.format.basic_html", "node:" + strconv.Itoa(i), "node_view", "rendered"}
+		}},
+	"toolbar": {4000,
+		func(r *rand.Rand, i int) string { return fmt.Sprintf("toolbar_%d:%s", i, hash(r)) },
+		func(r *rand.Rand, i int) []string { return []string{"config:system.menu.admin"} }},
+}
+
+var defaultProfile = profile{512,
+	func(r *rand.Rand, i int) string { return fmt.Sprintf("item:%d", i) },
+	func(r *rand.Rand, i int) []string { return nil }}
+
+// filler returns the pseudo-random text from which data is cut, built on first
+// use to keep it out of the command startup.
+var filler = sync.OnceValue(func() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789 <>/=\""
+	r := rand.New(rand.NewSource(0))
+	b := make([]byte, maxSize)
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(b)
+})
+
+// data returns a PHP serialized render array of about size bytes.
+func data(r *rand.Rand, size int) string {
+	const overhead = len(`a:1:{s:7:"#markup";s:0:"";}`)
+	n := size - overhead
+	if n < 1 {
+		n = 1
+	}
+	text := filler()
+	offset := r.Intn(len(text) - n + 1)
+	return fmt.Sprintf(`a:1:{s:7:"#markup";s:%d:"%s";}`, n, text[offset:offset+n])
+}
+
+// size returns a data size following a log-normal distribution around median.
+func size(r *rand.Rand, median int, scale float64) int {
+	s := int(float64(median) * scale * math.Exp(r.NormFloat64()))
+	switch {
+	case s < minSize:
+		return minSize
+	case s > maxSize:
+		return maxSize
+	default:
+		return s
+	}
+}
+
+/*
+Generate builds the items described by opts, passing each of them to fn, bins
+in name order. It stops on the first error returned by fn.
+*/
+func Generate(opts Options, fn func(CacheItem) error) error {
+	opts = opts.withDefaults()
+	r := rand.New(rand.NewSource(opts.Seed))
+	names := make([]string, 0, len(opts.Bins))
+	for name := range opts.Bins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, ok := profiles[name]
+		if !ok {
+			p = defaultProfile
+		}
+		for i := 0; i < opts.Bins[name]; i++ {
+			item := CacheItem{
+				Bin:        name,
+				CID:        p.cid(r, i),
+				Data:       data(r, size(r, p.median, opts.SizeScale)),
+				Serialized: true,
+				Tags:       p.tags(r, i),
+				Created:    opts.Now.Add(-time.Duration(r.Int63n(int64(7 * 24 * time.Hour)))),
+			}
+			if r.Float64() < opts.ExpireRatio {
+				item.Expire = opts.Now.Add(time.Minute + time.Duration(r.Int63n(int64(24*time.Hour))))
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Fill writes the items described by opts to the current database of c, with
+HSET and PEXPIRE commands pipelined in batches, like the Drupal redis module
+stores them.
+
+It returns the number of items written.
+*/
+func Fill(c redis.Conn, opts Options) (int, error) {
+	opts = opts.withDefaults()
+	var written, pending int
+	flush := func() error {
+		if err := c.Flush(); err != nil {
+			return fmt.Errorf("failed writing items: %w", err)
+		}
+		var first error
+		for ; pending > 0; pending-- {
+			if _, err := c.Receive(); err != nil && first == nil {
+				first = fmt.Errorf("failed writing items: %w", err)
+			}
+		}
+		return first
+	}
+
+	err := Generate(opts, func(item CacheItem) error {
+		key := item.Key(opts.Prefix)
+		if err := c.Send("HSET", redis.Args{key}.AddFlat(item.Fields())...); err != nil {
+			return fmt.Errorf("failed HSET: %w", err)
+		}
+		pending++
+		if !item.Expire.IsZero() {
+			if err := c.Send("PEXPIRE", key, item.Expire.Sub(opts.Now).Milliseconds()); err != nil {
+				return fmt.Errorf("failed PEXPIRE: %w", err)
+			}
+			pending++
+		}
+		written++
+		if written%batchSize == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return written, err
+}
+
+/*
+ParseBins parses a bin specification like "render=1000,page=200".
+*/
+func ParseBins(spec string) (map[string]int, error) {
+	bins := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		name, count, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid bin %q: use name=count", part)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid count in bin %q: use a non-negative integer", part)
+		}
+		if _, ok := bins[name]; ok {
+			return nil, fmt.Errorf("duplicate bin %q", name)
+		}
+		bins[name] = n
+	}
+	return bins, nil
+}
@@ -0,0 +1,161 @@
+package generate_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/generate"
+	"github.com/fgm/drupal_redis_stats/redistest"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func collect(t *testing.T, opts generate.Options) []generate.CacheItem {
+	var items []generate.CacheItem
+	if err := generate.Generate(opts, func(item generate.CacheItem) error {
+		items = append(items, item)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed generating: %v", err)
+	}
+	return items
+}
+
+func TestGenerate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := generate.Options{
+		Bins:        map[string]int{"render": 400, "page": 100, "custom": 50},
+		Seed:        42,
+		ExpireRatio: 0.25,
+		Now:         now,
+	}
+	items := collect(t, opts)
+	if again := collect(t, opts); !reflect.DeepEqual(items, again) {
+		t.Error("the same options generated different items")
+	}
+	opts.Seed = 43
+	if other := collect(t, opts); reflect.DeepEqual(items, other) {
+		t.Error("different seeds generated the same items")
+	}
+
+	counts := make(map[string]int)
+	sizes := make(map[string]int)
+	keys := make(map[string]bool)
+	var expiring int
+	for _, item := range items {
+		key := item.Key(generate.DefaultPrefix)
+		if keys[key] {
+			t.Fatalf("duplicate key %s", key)
+		}
+		keys[key] = true
+		counts[item.Bin]++
+		sizes[item.Bin] += len(item.Data)
+		if !item.Expire.IsZero() {
+			expiring++
+			if !item.Expire.After(now) {
+				t.Errorf("item %s expires at %v, before %v", key, item.Expire, now)
+			}
+		}
+		if item.Created.After(now) {
+			t.Errorf("item %s created at %v, after %v", key, item.Created, now)
+		}
+	}
+	if !reflect.DeepEqual(counts, map[string]int{"render": 400, "page": 100, "custom": 50}) {
+		t.Errorf("got counts %v", counts)
+	}
+	if expiring < 100 || expiring > 175 {
+		t.Errorf("got %d expiring items out of 550, expected about 25%%", expiring)
+	}
+	// Pages are typically much larger than render arrays and unknown bin items.
+	if sizes["page"]/100 <= sizes["render"]/400 || sizes["render"]/400 <= sizes["custom"]/50 {
+		t.Errorf("unexpected average sizes: %v", sizes)
+	}
+	if !strings.HasPrefix(items[0].CID, "item:") || !strings.HasPrefix(items[50].CID, "http://example.com/") ||
+		!strings.HasPrefix(items[150].CID, "entity_view:") {
+		t.Errorf("unexpected cids %s, %s, %s", items[0].CID, items[50].CID, items[150].CID)
+	}
+}
+
+func TestGenerateSizeScale(t *testing.T) {
+	total := func(scale float64) int {
+		var n int
+		for _, item := range collect(t, generate.Options{Bins: map[string]int{"render": 200}, SizeScale: scale}) {
+			n += len(item.Data)
+		}
+		return n
+	}
+	if small, large := total(0.5), total(2); large < 3*small {
+		t.Errorf("got total sizes %d at scale 0.5, %d at scale 2", small, large)
+	}
+}
+
+func TestFill(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	c, err := redis.DialURL(s.DSN(2))
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer c.Close()
+
+	// More items than a pipeline batch, to exercise batching.
+	bins := map[string]int{"render": 700, "page": 60, "config": 40}
+	n, err := generate.Fill(c, generate.Options{Bins: bins, ExpireRatio: 0.5})
+	if err != nil {
+		t.Fatalf("failed filling: %v", err)
+	}
+	if n != 800 {
+		t.Errorf("got %d items written, expected 800", n)
+	}
+
+	var cs stats.CacheStats
+	if err = cs.Scan(stats.NewRedigoClient(c), 0, io.Discard); err != nil {
+		t.Fatalf("failed scanning: %v", err)
+	}
+	for bin, count := range bins {
+		if actual := cs.Stats[bin].Keys; int(actual) != count {
+			t.Errorf("got %d keys in bin %s, expected %d", actual, bin, count)
+		}
+	}
+	info, _ := redis.String(c.Do("INFO", "keyspace"))
+	if !strings.Contains(info, "db2:keys=800,expires=") || strings.Contains(info, "expires=0,") {
+		t.Errorf("unexpected keyspace:\n%s", info)
+	}
+}
+
+func TestParseBins(t *testing.T) {
+	checks := [...]struct {
+		spec     string
+		expected map[string]int
+		expError bool
+	}{
+		{"render=10", map[string]int{"render": 10}, false},
+		{"render=10, page=0", map[string]int{"render": 10, "page": 0}, false},
+		{"render", nil, true},
+		{"=10", nil, true},
+		{"render=-1", nil, true},
+		{"render=ten", nil, true},
+		{"render=1,render=2", nil, true},
+	}
+	for _, check := range checks {
+		t.Run(check.spec, func(t *testing.T) {
+			actual, err := generate.ParseBins(check.spec)
+			if check.expError {
+				if err == nil {
+					t.Fatalf("unexpected success: %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, check.expected) {
+				t.Errorf("got %v, expected %v", actual, check.expected)
+			}
+		})
+	}
+}
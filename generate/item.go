@@ -0,0 +1,65 @@
+package generate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPrefix is a cache key prefix like those of the Drupal redis module,
+// which uses drupal.redis.<version> unless a cache prefix is configured.
+const DefaultPrefix = "drupal.redis.10.1.0"
+
+/*
+CacheItem is a Drupal cache item, as stored by the Drupal redis module in a
+hash with cid, data, created, expire, serialized, tags, checksum and valid
+fields.
+*/
+type CacheItem struct {
+	Bin, CID string
+	Data     string
+	// Serialized tells whether Data is a PHP serialized value.
+	Serialized bool
+	Tags       []string
+	Created    time.Time
+	// Expire is the expiration time, the zero value meaning permanent, like the
+	// Drupal CACHE_PERMANENT -1 value.
+	Expire time.Time
+}
+
+/*
+Key returns the Redis key of the item: <prefix>:<bin>:<cid>.
+*/
+func (ci CacheItem) Key(prefix string) string {
+	return prefix + ":" + ci.Bin + ":" + ci.CID
+}
+
+/*
+Fields returns the hash fields of the item, like the Drupal redis module sets
+them.
+*/
+func (ci CacheItem) Fields() map[string]string {
+	expire := "-1"
+	if !ci.Expire.IsZero() {
+		expire = strconv.FormatInt(ci.Expire.Unix(), 10)
+	}
+	created := ci.Created
+	if created.IsZero() {
+		created = time.Unix(0, 0)
+	}
+	serialized := "0"
+	if ci.Serialized {
+		serialized = "1"
+	}
+	return map[string]string{
+		"cid":        ci.CID,
+		"data":       ci.Data,
+		"created":    fmt.Sprintf("%.3f", float64(created.UnixMilli())/1000),
+		"expire":     expire,
+		"serialized": serialized,
+		"tags":       strings.Join(ci.Tags, " "),
+		"checksum":   strconv.Itoa(len(ci.Tags)),
+		"valid":      "1",
+	}
+}
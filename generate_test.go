@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+)
+
+func TestRunGenerate(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir()) // Ignore any user configuration.
+	s := redistest.NewServer()
+	defer s.Close()
+	s.Set(1, "existing", "value")
+
+	checks := [...]struct {
+		name     string
+		args     []string
+		expected string
+		expError string
+	}{
+		{"bins", []string{"-dsn", s.DSN(0), "-bins", "render=30,page=5", "-expire-ratio", "0.5"}, "Generated 35 cache items", ""},
+		{"defaults", []string{"-dsn", s.DSN(2)}, "Generated 10000 cache items", ""},
+		{"not empty", []string{"-dsn", s.DSN(1), "-bins", "render=1"}, "", "database is not empty"},
+		{"force", []string{"-dsn", s.DSN(1), "-bins", "render=1", "-force"}, "Generated 1 cache items", ""},
+		{"bad bins", []string{"-bins", "render"}, "", "invalid bin"},
+		{"bad ratio", []string{"-expire-ratio", "2"}, "", "invalid expire ratio"},
+		{"bad scale", []string{"-size-scale", "0"}, "", "invalid size scale"},
+		{"argument", []string{"extra"}, "", "does not take arguments"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			stdout := strings.Builder{}
+			err := run(append([]string{"generate"}, check.args...), &stdout, &strings.Builder{}, noEnv)
+			if check.expError != "" {
+				if err == nil || !strings.Contains(err.Error(), check.expError) {
+					t.Fatalf("expected error containing %q, got %v", check.expError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(stdout.String(), check.expected) {
+				t.Errorf("did not find %q in:\n%s", check.expected, stdout.String())
+			}
+		})
+	}
+
+	cs, err := runJSON(t, noEnv, "-dsn", s.DSN(0))
+	if err != nil {
+		t.Fatalf("failed scanning generated data: %v", err)
+	}
+	if cs.Stats["render"].Keys != 30 || cs.Stats["page"].Keys != 5 || cs.Stats["page"].Size <= cs.Stats["render"].Size/6 {
+		t.Errorf("unexpected scan of generated data: %+v", cs.Stats)
+	}
+}
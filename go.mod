@@ -5,7 +5,13 @@ go 1.21
 require (
 	github.com/gomodule/redigo v1.8.9
 	github.com/morikuni/aec v1.0.0
+	github.com/redis/go-redis/v9 v9.7.3
 	golang.org/x/term v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.17.0 // indirect
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	golang.org/x/sys v0.17.0 // indirect
+)
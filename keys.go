@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// maxKeyLine is the maximum length of a line in a key list.
+const maxKeyLine = 1 << 20
+
+// keysCheckLines is the number of lines read between two checks of the context.
+const keysCheckLines = 1000
+
+// openKeys opens a key list file, or returns stdin for "-".
+func openKeys(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening key list: %w", err)
+	}
+	return f, nil
+}
+
+// scanKeys computes the results of a list of keys, like a scan would.
+//
+// The list holds a key per line, like the output of redis-cli --scan, each
+// optionally followed by a tab and its size in bytes, like the MEMORY USAGE
+// added by a script. Keys without a size count for 0 bytes, and empty lines are
+// skipped. TotalKeys counts all the keys, Drupal cache keys or not.
+//
+// With stats.SchemeAuto, the keys are classified with all schemes, and the
+// results of the scheme matching the most keys are returned.
+func scanKeys(ctx context.Context, r io.Reader, prefix string, scheme stats.Scheme) (stats.CacheStats, error) {
+	var candidates []stats.CacheStats
+	for _, s := range stats.Schemes(scheme) {
+		candidates = append(candidates, stats.CacheStats{Prefix: prefix, Scheme: s, Stats: map[string]stats.BinStats{}})
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), maxKeyLine)
+	for line := 1; sc.Scan(); line++ {
+		if line%keysCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				cs := stats.MostKeys(candidates)
+				cs.Incomplete = true
+				return cs, err
+			}
+		}
+		text := strings.TrimSuffix(sc.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		key, size, err := parseKeyLine(text)
+		if err != nil {
+			return stats.MostKeys(candidates), fmt.Errorf("invalid key list line %d: %w", line, err)
+		}
+		for i := range candidates {
+			candidates[i].TotalKeys++
+			candidates[i].AddKey(key, size)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return stats.MostKeys(candidates), fmt.Errorf("failed reading key list: %w", err)
+	}
+	return stats.MostKeys(candidates), nil
+}
+
+// parseKeyLine parses a key list line: a key, optionally followed by a tab and
+// its size, which may be empty, like for keys deleted before MEMORY USAGE.
+func parseKeyLine(line string) (string, int64, error) {
+	key, size, ok := cutLast(line, "\t")
+	if !ok || size == "" {
+		return key, 0, nil
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return "", 0, fmt.Errorf("invalid size %q for key %q", size, key)
+	}
+	return key, n, nil
+}
+
+// cutLast is strings.Cut, for the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
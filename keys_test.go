@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func TestParseKeyLine(t *testing.T) {
+	checks := [...]struct {
+		name, line string
+		key        string
+		size       int64
+		expError   bool
+	}{
+		{"key only", "drupal.redis.10.1.0:render:a", "drupal.redis.10.1.0:render:a", 0, false},
+		{"with size", "drupal.redis.10.1.0:render:a\t1234", "drupal.redis.10.1.0:render:a", 1234, false},
+		{"empty size", "gone\t", "gone", 0, false},
+		{"tab in key", "a\tb\t12", "a\tb", 12, false},
+		{"bad size", "key\t12k", "", 0, true},
+		{"negative size", "key\t-1", "", 0, true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			key, size, err := parseKeyLine(check.line)
+			if (err != nil) != check.expError || key != check.key || size != check.size {
+				t.Errorf("got %q, %d, %v, expected %q, %d, error %t", key, size, err, check.key, check.size, check.expError)
+			}
+		})
+	}
+}
+
+func TestScanKeys(t *testing.T) {
+	list := "drupal.redis.10.1.0:render:a\t100\r\n" +
+		"drupal.redis.10.1.0:render:b\t50\r\n" +
+		"\r\n" +
+		"drupal.redis.10.1.0:page:c\r\n" +
+		"sessions:xyz\t10\r\n"
+	cs, err := scanKeys(context.Background(), strings.NewReader(list), "", stats.SchemeAuto)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs.TotalKeys != 4 || cs.Stats["render"].Keys != 2 || cs.Stats["render"].Size != 150 || cs.Stats["page"].Keys != 1 {
+		t.Errorf("unexpected results: %+v", cs)
+	}
+
+	d7 := "site:cache_page:a\t10\nsite:cache:b\t20\nsite:lock:c\t5\n"
+	if cs, err = scanKeys(context.Background(), strings.NewReader(d7), "", stats.SchemeAuto); err != nil ||
+		cs.Scheme != stats.SchemeD7 || cs.TotalKeys != 3 || cs.Stats["page"].Size != 10 || cs.Stats["default"].Size != 20 {
+		t.Errorf("unexpected Drupal 7 results: %+v, %v", cs, err)
+	}
+	if cs, err = scanKeys(context.Background(), strings.NewReader(d7), "", stats.SchemeD8); err != nil || len(cs.Stats) != 0 {
+		t.Errorf("unexpected results with the d8 scheme: %+v, %v", cs, err)
+	}
+
+	if _, err = scanKeys(context.Background(), strings.NewReader("a\nb\tx\n"), "", stats.SchemeAuto); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cs, err = scanKeys(ctx, strings.NewReader(strings.Repeat("drupal.redis.10.1.0:render:a\n", 2*keysCheckLines)), "", stats.SchemeAuto)
+	if err == nil || !cs.Incomplete || cs.ScannedKeys() != keysCheckLines-1 {
+		t.Errorf("got %d keys, incomplete %t, error %v, expected an interrupted scan", cs.ScannedKeys(), cs.Incomplete, err)
+	}
+}
+
+func TestRunKeys(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir()) // Ignore any user configuration.
+	path := filepath.Join(t.TempDir(), "keys.tsv")
+	if err := os.WriteFile(path, []byte("site:render:a\t10\nsite:page:b\t20\nother\t5\n"), 0o600); err != nil {
+		t.Fatalf("failed writing key list: %v", err)
+	}
+	cs, err := runJSON(t, noEnv, "-keys", path, "-prefix", "site")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs.TotalKeys != 3 || cs.TotalSize() != 30 || cs.Stats["page"].Size != 20 {
+		t.Errorf("unexpected results: %+v", cs)
+	}
+
+	checks := [...]struct {
+		name     string
+		args     []string
+		expError string
+	}{
+		{"missing", []string{"-keys", filepath.Join(t.TempDir(), "missing")}, "failed opening key list"},
+		{"all-dbs", []string{"-keys", path, "-all-dbs"}, "-all-dbs cannot be used with -keys"},
+		{"rdb", []string{"-keys", path, "-rdb", path}, "cannot be used together"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if _, err := runJSON(t, noEnv, check.args...); err == nil || !strings.Contains(err.Error(), check.expError) {
+				t.Errorf("expected error containing %q, got %v", check.expError, err)
+			}
+		})
+	}
+}
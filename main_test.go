@@ -3,7 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"testing"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
 )
 
 func TestIsFlagPassed(t *testing.T) {
@@ -47,7 +52,7 @@ func TestGetVerboseWriter(t *testing.T) {
 	}
 	for _, check := range checks {
 		t.Run(check.name, func(t *testing.T) {
-			actual := getVerboseWriter(check.quiet)
+			actual := getVerboseWriter(check.quiet, os.Stderr)
 			actualType := fmt.Sprintf("%T", actual)
 			if actualType != check.expectedType {
 				t.Errorf("Expected %s, got %s", actualType, check.expectedType)
@@ -55,3 +60,78 @@ func TestGetVerboseWriter(t *testing.T) {
 		})
 	}
 }
+
+func TestGetOutputOptions(t *testing.T) {
+	checks := [...]struct {
+		name               string
+		human, sort        string
+		top                int
+		expSortBy, expDesc string
+		expError           bool
+	}{
+		{"defaults", "", "", 0, "", "false", false},
+		{"iec size desc", "iec", "-size", 3, "size", "true", false},
+		{"bad units", "bits", "", 0, "", "", true},
+		{"bad sort", "", "color", 0, "", "", true},
+		{"bad top", "", "", -1, "", "", true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			opts, err := getOutputOptions(check.human, false, check.sort, check.top)
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(opts.SortBy) != check.expSortBy || fmt.Sprint(opts.Desc) != check.expDesc {
+				t.Errorf("got %s/%t, expected %s/%s", opts.SortBy, opts.Desc, check.expSortBy, check.expDesc)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.RequirePass("secret")
+	s.AddUser("drupal", "acl")
+	s.Set(2, "key", "value")
+	checks := [...]struct {
+		name, user, pass string
+		old              bool
+		expError         bool
+	}{
+		{"requirepass", "", "secret", false, false},
+		{"acl", "drupal", "acl", false, false},
+		{"no auth", "", "", false, true},
+		{"wrong pass", "drupal", "bad", false, true},
+		{"old requirepass", "", "secret", true, false}, // Last, since HELLO stays disabled.
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if check.old {
+				s.Disable("HELLO")
+			}
+			c, _, err := open(s.DSN(2), check.user, check.pass)
+			if check.expError {
+				if err == nil {
+					c.Close()
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer c.Close()
+			// The database is only selected once authenticated.
+			if n, err := redis.Int(c.Do("DBSIZE")); err != nil || n != 1 {
+				t.Errorf("got %d keys, %v, expected database 2 with 1 key", n, err)
+			}
+		})
+	}
+}
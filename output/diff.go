@@ -0,0 +1,167 @@
+package output
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fgm/drupal_redis_stats/snapshot"
+)
+
+//go:embed templates/diff.go.gotext
+var tplDiff string
+
+type diffTemplateData struct {
+	Title          string
+	Header, Footer []string
+	Rows           [][]string
+	Widths         []int
+}
+
+type diffRow struct {
+	Cells  []string
+	Widths []int
+}
+
+// Row binds cells to the column widths, for use by the diffRow template.
+func (td diffTemplateData) Row(cells []string) diffRow {
+	return diffRow{Cells: cells, Widths: td.Widths}
+}
+
+// formatChange formats the percent change of a bin, or its status when there
+// is no meaningful percentage.
+func formatChange(bd snapshot.BinDiff, change float64) string {
+	switch bd.Status {
+	case snapshot.Added:
+		return "new"
+	case snapshot.Removed:
+		return "gone"
+	default:
+		return fmt.Sprintf("%+.1f", change)
+	}
+}
+
+// newDiffTemplateData formats all cells of the diff table, then computes the
+// column widths from them.
+func newDiffTemplateData(d snapshot.Diff, opts Options) diffTemplateData {
+	formatSize := opts.Units.formatter()
+	formatDelta := func(n int64) string {
+		if n < 0 {
+			return formatSize(n)
+		}
+		return "+" + formatSize(n)
+	}
+	cells := func(name string, bd snapshot.BinDiff) []string {
+		return []string{
+			name,
+			strconv.FormatUint(uint64(bd.New.Keys), 10),
+			fmt.Sprintf("%+d", bd.KeysDelta),
+			formatChange(bd, bd.KeysChange),
+			formatSize(bd.New.Size),
+			formatDelta(bd.SizeDelta),
+			formatChange(bd, bd.SizeChange),
+		}
+	}
+
+	td := diffTemplateData{
+		Title: fmt.Sprintf("Comparing %s to %s",
+			d.OldTime.Format(time.RFC3339), d.NewTime.Format(time.RFC3339)),
+		Header: []string{"Bin", "Keys", "Δ Keys", "%", "Data", "Δ Data", "%"},
+		Footer: cells("Total", d.Total),
+	}
+	for _, bd := range d.Bins {
+		td.Rows = append(td.Rows, cells(bd.Name, bd))
+	}
+	td.computeWidths()
+	return td
+}
+
+// newWatchTemplateData formats the cells of the watch table, showing the
+// growth rate of each bin since the previous scan. On the first scan, when
+// there is no previous scan, the rates are not available.
+func newWatchTemplateData(d snapshot.Diff, opts Options) diffTemplateData {
+	formatSize := opts.Units.formatter()
+	elapsed := d.NewTime.Sub(d.OldTime)
+	formatRate := func(delta int64, format func(int64) string) string {
+		if d.OldTime.IsZero() || elapsed <= 0 {
+			return "-"
+		}
+		rate := int64(math.Round(float64(delta) / elapsed.Seconds()))
+		if rate < 0 {
+			return format(rate) + "/s"
+		}
+		return "+" + format(rate) + "/s"
+	}
+	formatKeys := func(n int64) string { return strconv.FormatInt(n, 10) }
+	cells := func(name string, bd snapshot.BinDiff) []string {
+		return []string{
+			name,
+			strconv.FormatUint(uint64(bd.New.Keys), 10),
+			formatRate(bd.KeysDelta, formatKeys),
+			formatSize(bd.New.Size),
+			formatRate(bd.SizeDelta, formatSize),
+		}
+	}
+
+	title := "Scanned at " + d.NewTime.Format(time.RFC3339)
+	if !d.OldTime.IsZero() {
+		title += fmt.Sprintf(", %s since previous scan", elapsed.Round(time.Second))
+	}
+	td := diffTemplateData{
+		Title:  title,
+		Header: []string{"Bin", "Keys", "Keys rate", "Data", "Data rate"},
+		Footer: cells("Total", d.Total),
+	}
+	for _, bd := range d.Bins {
+		td.Rows = append(td.Rows, cells(bd.Name, bd))
+	}
+	td.computeWidths()
+	return td
+}
+
+// computeWidths sets the column widths from the length of the longest cells.
+func (td *diffTemplateData) computeWidths() {
+	td.Widths = make([]int, len(td.Header))
+	for _, row := range append([][]string{td.Header, td.Footer}, td.Rows...) {
+		for i, cell := range row {
+			if l := utf8.RuneCountInString(cell); l > td.Widths[i] {
+				td.Widths[i] = l
+			}
+		}
+	}
+}
+
+// DiffText outputs the comparison of two snapshots in text format.
+func DiffText(w io.Writer, d *snapshot.Diff, opts Options) error {
+	if d == nil {
+		return errors.New("unexpected nil diff")
+	}
+	tpl := template.Must(template.New("diff").Funcs(funcMap()).Parse(tplDiff))
+	return tpl.Execute(w, newDiffTemplateData(*d, opts))
+}
+
+// WatchText outputs the latest scan results in text format, with the growth
+// rate of each bin since the previous scan. For the first scan, d.OldTime
+// must be the zero time.
+func WatchText(w io.Writer, d *snapshot.Diff, opts Options) error {
+	if d == nil {
+		return errors.New("unexpected nil diff")
+	}
+	tpl := template.Must(template.New("diff").Funcs(funcMap()).Parse(tplDiff))
+	return tpl.Execute(w, newWatchTemplateData(*d, opts))
+}
+
+// DiffJSON outputs the comparison of two snapshots in JSON format.
+func DiffJSON(w io.Writer, d *snapshot.Diff) error {
+	// The Diff type cannot fail serialization.
+	j, _ := json.Marshal(d)
+	_, err := fmt.Fprintf(w, "%s\n", j)
+	return err
+}
@@ -0,0 +1,106 @@
+package output
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// humanBytes formats a byte count using the given unit base and prefixes.
+func humanBytes(n int64, base float64, prefixes string, suffix string) string {
+	if n < 0 {
+		return "-" + humanBytes(-n, base, prefixes, suffix)
+	}
+	if float64(n) < base {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	exp := -1
+	for value >= base && exp < len(prefixes)-1 {
+		value /= base
+		exp++
+	}
+	return fmt.Sprintf("%.1f %c%sB", value, prefixes[exp], suffix)
+}
+
+// humanIEC formats a byte count with binary prefixes, like 1.5 KiB.
+func humanIEC(n int64) string {
+	return humanBytes(n, 1024, "KMGTPE", "i")
+}
+
+// humanSI formats a byte count with decimal prefixes, like 1.5 kB.
+func humanSI(n int64) string {
+	return humanBytes(n, 1000, "kMGTPE", "")
+}
+
+// Units designates how sizes are displayed.
+type Units string
+
+// Supported units.
+const (
+	UnitsBytes Units = ""
+	UnitsIEC   Units = "iec"
+	UnitsSI    Units = "si"
+)
+
+// ParseUnits validates units from their string representation.
+func ParseUnits(s string) (Units, error) {
+	switch u := Units(s); u {
+	case UnitsBytes, UnitsIEC, UnitsSI:
+		return u, nil
+	default:
+		return "", fmt.Errorf("unknown units %q: use %s or %s", s, UnitsIEC, UnitsSI)
+	}
+}
+
+// formatter returns the function formatting sizes in these units.
+func (u Units) formatter() func(int64) string {
+	switch u {
+	case UnitsIEC:
+		return humanIEC
+	case UnitsSI:
+		return humanSI
+	default:
+		return func(n int64) string { return strconv.FormatInt(n, 10) }
+	}
+}
+
+// percent returns part as a percentage of total, or 0 if total is 0.
+func percent(part, total any) float64 {
+	t := toFloat(total)
+	if t == 0 {
+		return 0
+	}
+	return 100 * toFloat(part) / t
+}
+
+// toFloat converts the numeric types found in stats to float64 for templates.
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float64:
+		return n
+	default:
+		panic(fmt.Errorf("unexpected non-numeric value %v of type %T", v, v))
+	}
+}
+
+// sortBins is the template version of stats.CacheStats.Bins, accepting
+// "size" or "-size" style keys, the "-" prefix meaning descending order.
+func sortBins(cs *stats.CacheStats, key string) ([]stats.Bin, error) {
+	by, desc, err := stats.ParseSortSpec(key)
+	if err != nil {
+		return nil, err
+	}
+	return cs.Bins(by, desc), nil
+}
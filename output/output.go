@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	htmltemplate "html/template"
 	"io"
 	"math"
+	"os"
 	"strings"
 	"text/template"
 
@@ -20,6 +22,58 @@ var tplHr string
 //go:embed templates/stats.go.gotext
 var tplStats string
 
+//go:embed templates/markdown.go.gotext
+var tplMarkdown string
+
+//go:embed templates/html.go.html
+var tplHTML string
+
+// Format designates one of the supported output formats.
+type Format string
+
+// Supported output formats.
+const (
+	FormatText     Format = "text"
+	FormatJSON     Format = "json"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+// ParseFormat validates an output format from its string representation.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatText, FormatJSON, FormatMarkdown, FormatHTML:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q: use one of %s, %s, %s, %s",
+			s, FormatText, FormatJSON, FormatMarkdown, FormatHTML)
+	}
+}
+
+// Options configures the tabular output formats: text, markdown, and html.
+type Options struct {
+	Units   Units         // How sizes are displayed.
+	Percent bool          // Add percent-of-total columns to the text format.
+	SortBy  stats.SortKey // Bins order. Empty for the format default.
+	Desc    bool          // Reverse the bins order.
+	Top     int           // Collapse bins beyond the first Top ones. 0 for no limit.
+}
+
+// Write outputs statistics in the requested format.
+func Write(w io.Writer, cs *stats.CacheStats, f Format, opts Options) error {
+	switch f {
+	case FormatJSON:
+		return JSON(w, cs)
+	case FormatMarkdown:
+		return Markdown(w, cs, opts)
+	case FormatHTML:
+		return HTML(w, cs, opts)
+	default:
+		TextWithOptions(w, cs, opts)
+		return nil
+	}
+}
+
 // JSON outputs statistics in JSON format for API usage.
 func JSON(w io.Writer, stats *stats.CacheStats) error {
 	// The CacheStats type cannot fail serialization.
@@ -30,18 +84,46 @@ func JSON(w io.Writer, stats *stats.CacheStats) error {
 
 type templateData struct {
 	Stats                              *stats.CacheStats
+	Bins                               []stats.Bin
 	BinsHeader, KeysHeader, SizeHeader string
-	BinsFooter                         string
+	BinsFooter, PercentHeader          string
 	BinsLen, KeysLen, SizeLen          int
+	PercentLen                         int
+	Percent                            bool
+	formatSize                         func(int64) string
+}
+
+// FormatSize formats a size in the units selected by the options.
+func (td templateData) FormatSize(n int64) string {
+	return td.formatSize(n)
 }
 
-// compileTemplates loads and parses the template, either from the file system in
-// development mode, or from the embedded version in production mode.
+// KeysPercent returns a key count as a percentage of the total key count.
+func (td templateData) KeysPercent(n uint32) float64 {
+	return percent(n, td.Stats.TotalKeys)
+}
+
+// SizePercent returns a size as a percentage of the total size.
+func (td templateData) SizePercent(n int64) float64 {
+	return percent(n, td.Stats.TotalSize())
+}
+
+// funcMap returns the helper functions available to all templates,
+// including user-supplied ones.
+func funcMap() template.FuncMap {
+	return template.FuncMap{
+		"repeat":   strings.Repeat,
+		"iec":      humanIEC,
+		"si":       humanSI,
+		"percent":  percent,
+		"sortBins": sortBins,
+	}
+}
+
+// compileTemplates loads and parses the embedded templates.
 func compileTemplates() (*template.Template, error) {
 	tpl := template.New("")
-	tpl.Funcs(template.FuncMap{
-		"repeat": strings.Repeat,
-	})
+	tpl.Funcs(funcMap())
 
 	for _, contents := range []string{tplHr, tplStats} {
 		tpl = template.Must(tpl.Parse(contents))
@@ -49,31 +131,107 @@ func compileTemplates() (*template.Template, error) {
 	return tpl, nil
 }
 
-// Text outputs statistics in text format for CLI usage.
-func Text(w io.Writer, cs *stats.CacheStats) {
-	if cs == nil {
-		panic(errors.New("unexpected nil stats"))
-	}
-	tpl, _ := compileTemplates()
-
+// newTemplateData builds the data passed to templates, embedded or not.
+//
+// The column widths are computed after applying the Top option, since it may
+// add the OtherBin row, and using the selected units.
+func newTemplateData(cs *stats.CacheStats, opts Options) templateData {
 	const binsHeader = "Bin"
 	const keysHeader = "Keys"
 	const sizeHeader = "Data"
 	const binsFooter = "Total"
+	const percentHeader = "%"
+	const percentLen = len("100.0")
+
+	view := *cs
+	if opts.Top > 0 {
+		view = cs.Top(opts.Top, opts.SortBy)
+	}
+	formatSize := opts.Units.formatter()
 	data := templateData{
-		Stats:      cs,
-		BinsHeader: binsHeader,
-		KeysHeader: keysHeader,
-		SizeHeader: sizeHeader,
-		BinsFooter: binsFooter,
-		BinsLen:    int(math.Max(float64(cs.MaxBinNameLength()), float64(len(binsFooter)))),
-		KeysLen:    int(math.Max(float64(cs.ItemCountLength()), float64(len(keysHeader)))),
-		SizeLen:    int(math.Max(float64(cs.TotalSizeLength()), float64(len(sizeHeader)))),
+		Stats:         &view,
+		Bins:          view.Bins(opts.SortBy, opts.Desc),
+		BinsHeader:    binsHeader,
+		KeysHeader:    keysHeader,
+		SizeHeader:    sizeHeader,
+		BinsFooter:    binsFooter,
+		PercentHeader: percentHeader,
+		BinsLen:       int(math.Max(float64(view.MaxBinNameLength()), float64(len(binsFooter)))),
+		KeysLen:       int(math.Max(float64(view.ItemCountLength()), float64(len(keysHeader)))),
+		SizeLen:       int(math.Max(float64(view.SizeLength(formatSize)), float64(len(sizeHeader)))),
+		PercentLen:    percentLen,
+		Percent:       opts.Percent,
+		formatSize:    formatSize,
 	}
+	return data
+}
+
+// Text outputs statistics in text format for CLI usage, using default options.
+func Text(w io.Writer, cs *stats.CacheStats) {
+	TextWithOptions(w, cs, Options{})
+}
 
-	err := tpl.Execute(w, data)
+// TextWithOptions outputs statistics in text format for CLI usage.
+func TextWithOptions(w io.Writer, cs *stats.CacheStats, opts Options) {
+	if cs == nil {
+		panic(errors.New("unexpected nil stats"))
+	}
+	tpl, _ := compileTemplates()
+
+	err := tpl.Execute(w, newTemplateData(cs, opts))
 	if err != nil {
 		// No failure expected for any data, so let's panic.
 		panic(err)
 	}
 }
+
+// Template outputs statistics using a user-supplied template file.
+//
+// The template receives the same data as the embedded text template, and can
+// use the same helper functions: repeat, iec, si, percent, and sortBins.
+func Template(w io.Writer, cs *stats.CacheStats, path string, opts Options) error {
+	if cs == nil {
+		return errors.New("unexpected nil stats")
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed reading template: %w", err)
+	}
+	tpl, err := template.New(path).Funcs(funcMap()).Parse(string(contents))
+	if err != nil {
+		return fmt.Errorf("failed parsing template: %w", err)
+	}
+	if err = tpl.Execute(w, newTemplateData(cs, opts)); err != nil {
+		return fmt.Errorf("failed executing template: %w", err)
+	}
+	return nil
+}
+
+// Markdown outputs statistics as a GitHub-flavored Markdown table, with bins
+// ordered by decreasing size unless otherwise specified.
+func Markdown(w io.Writer, cs *stats.CacheStats, opts Options) error {
+	if cs == nil {
+		return errors.New("unexpected nil stats")
+	}
+	tpl := template.Must(template.New("markdown").Funcs(funcMap()).Parse(tplMarkdown))
+	return tpl.Execute(w, newTemplateData(cs, opts.withSizeDefault()))
+}
+
+// HTML outputs statistics as a self-contained HTML page, with a sortable table
+// and inline SVG bars charting the size of each bin.
+func HTML(w io.Writer, cs *stats.CacheStats, opts Options) error {
+	if cs == nil {
+		return errors.New("unexpected nil stats")
+	}
+	tpl := htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap(funcMap())).Parse(tplHTML))
+	return tpl.Execute(w, newTemplateData(cs, opts.withSizeDefault()))
+}
+
+// withSizeDefault applies the decreasing size order used by report formats,
+// unless another order was specified.
+func (o Options) withSizeDefault() Options {
+	if o.SortBy == "" {
+		o.SortBy, o.Desc = stats.SortBySize, true
+	}
+	return o
+}
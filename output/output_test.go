@@ -1,14 +1,17 @@
 package output_test
 
 import (
+	"io"
 	"os"
 	"path"
 	"runtime"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/snapshot"
 	"github.com/fgm/drupal_redis_stats/stats"
 )
 
@@ -123,3 +126,243 @@ func init() {
 		panic(err)
 	}
 }
+
+func TestTemplate(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		template string
+		expected string
+		expErr   bool
+	}{
+		{"plain", `{{ .Stats.TotalKeys }}`, "25", false},
+		{"iec", `{{ iec 1536 }}`, "1.5 KiB", false},
+		{"si", `{{ si 1500 }}`, "1.5 kB", false},
+		{"small", `{{ iec 999 }}`, "999 B", false},
+		{"percent", `{{ printf "%.0f" (percent 1 4) }}`, "25", false},
+		{"percent zero", `{{ percent 1 0 }}`, "0", false},
+		{"sort size", `{{ range sortBins .Stats "size" }}{{ .Name }} {{ end }}`, "form default ", false},
+		{"sort -keys", `{{ range sortBins .Stats "-keys" }}{{ .Name }} {{ end }}`, "form default ", false},
+		{"sort name", `{{ range sortBins .Stats "name" }}{{ .Name }} {{ end }}`, "default form ", false},
+		{"bad sort", `{{ sortBins .Stats "color" }}`, "", true},
+		{"bad syntax", `{{ .Stats`, "", true},
+	}
+	dir := t.TempDir()
+	for i, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			name := path.Join(dir, strconv.Itoa(i)+".gotext")
+			if err := os.WriteFile(name, []byte(check.template), 0o600); err != nil {
+				t.Fatalf("failed writing template: %v", err)
+			}
+			w := strings.Builder{}
+			err := output.Template(&w, &sampleStats, name, output.Options{})
+			if check.expErr {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := w.String(); actual != check.expected {
+				t.Errorf("got %q, expected %q", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestTemplateSadMissing(t *testing.T) {
+	err := output.Template(io.Discard, &sampleStats, path.Join(t.TempDir(), "missing"), output.Options{})
+	if err == nil {
+		t.Error("missing template did not cause an error")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, name := range []string{"text", "json", "markdown", "html"} {
+		if f, err := output.ParseFormat(name); err != nil || string(f) != name {
+			t.Errorf("failed parsing %s: got %q, %v", name, f, err)
+		}
+	}
+	if _, err := output.ParseFormat("yaml"); err == nil {
+		t.Error("unexpected success parsing unknown format")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	checks := [...]struct {
+		format   output.Format
+		expected []string
+	}{
+		{output.FormatText, []string{"Total", "default"}},
+		{output.FormatJSON, []string{`"TotalKeys":25`}},
+		{output.FormatMarkdown, []string{
+			"|:---|---:|---:|---:|",
+			"| default | 12 | 37 | 62.7 |",
+			"| form | 13 | 22 | 37.3 |",
+			"| **Total** | **25** | **59** | **100.0** |",
+		}},
+		{output.FormatHTML, []string{
+			"<!DOCTYPE html>",
+			`<td data-value="default">default</td>`,
+			`width="100.0%"`,
+			`width="59.5%"`,
+			"<script>",
+		}},
+	}
+	for _, check := range checks {
+		t.Run(string(check.format), func(t *testing.T) {
+			w := strings.Builder{}
+			if err := output.Write(&w, &sampleStats, check.format, output.Options{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual := w.String()
+			for _, expected := range check.expected {
+				if !strings.Contains(actual, expected) {
+					t.Errorf("did not find %q in output:\n%s", expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteServer(t *testing.T) {
+	cs := sampleStats
+	cs.Server = &stats.Server{Product: stats.ProductValkey, Version: "8.0.1", Mode: "standalone", MemoryUsage: true}
+	checks := [...]struct {
+		format   output.Format
+		expected string
+	}{
+		{output.FormatText, "Total   |   25 |   59\n\nServer: Valkey 8.0.1 (standalone)\n"},
+		{output.FormatJSON, `"Server":{"Product":"Valkey","Version":"8.0.1","Mode":"standalone","MemoryUsage":true}`},
+		{output.FormatMarkdown, "| **100.0** |\n\n_Server: Valkey 8.0.1 (standalone)_\n"},
+		{output.FormatHTML, "<p>Server: Valkey 8.0.1 (standalone)</p>"},
+	}
+	for _, check := range checks {
+		t.Run(string(check.format), func(t *testing.T) {
+			w := strings.Builder{}
+			if err := output.Write(&w, &cs, check.format, output.Options{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := w.String(); !strings.Contains(actual, check.expected) {
+				t.Errorf("did not find %q in output:\n%s", check.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWriteIncomplete(t *testing.T) {
+	cs := sampleStats
+	cs.TotalKeys = 50
+	cs.Incomplete = true
+	checks := [...]struct {
+		format   output.Format
+		expected string
+	}{
+		{output.FormatText, "Total   |   50 |   59\n\nIncomplete scan: interrupted after 25 of 50 keys (50.0%).\n"},
+		{output.FormatJSON, `"Incomplete":true`},
+		{output.FormatMarkdown, "| **100.0** |\n\n**Incomplete scan**: interrupted after 25 of 50 keys (50.0%).\n"},
+		{output.FormatHTML, "<p><strong>Incomplete scan</strong>: interrupted after 25 of 50 keys (50.0%).</p>"},
+	}
+	for _, check := range checks {
+		t.Run(string(check.format), func(t *testing.T) {
+			w := strings.Builder{}
+			if err := output.Write(&w, &cs, check.format, output.Options{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := w.String(); !strings.Contains(actual, check.expected) {
+				t.Errorf("did not find %q in output:\n%s", check.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWriteSadNil(t *testing.T) {
+	for _, f := range []output.Format{output.FormatMarkdown, output.FormatHTML} {
+		if err := output.Write(io.Discard, nil, f, output.Options{}); err == nil {
+			t.Errorf("nil stats did not cause an error for %s", f)
+		}
+	}
+}
+
+func TestTextWithOptions(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		opts     output.Options
+		expected string
+	}{
+		{"percent iec size desc", output.Options{Percent: true, Units: output.UnitsIEC, SortBy: stats.SortBySize, Desc: true}, `Bin     | Keys |     % | Data |     %
+--------+------+-------+------+------
+default |   12 |  48.0 | 37 B |  62.7
+form    |   13 |  52.0 | 22 B |  37.3
+--------+------+-------+------+------
+Total   |   25 | 100.0 | 59 B | 100.0
+`},
+		{"top keys", output.Options{Top: 1, SortBy: stats.SortByKeys}, `Bin     | Keys | Data
+--------+------+-----
+form    |   13 |   22
+(other) |   12 |   37
+--------+------+-----
+Total   |   25 |   59
+`},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			w := strings.Builder{}
+			output.TextWithOptions(&w, &sampleStats, check.opts)
+			if actual := w.String(); actual != check.expected {
+				t.Errorf("got:\n%s\nexpected:\n%s", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	for _, name := range []string{"", "iec", "si"} {
+		if u, err := output.ParseUnits(name); err != nil || string(u) != name {
+			t.Errorf("failed parsing %q: got %q, %v", name, u, err)
+		}
+	}
+	if _, err := output.ParseUnits("bits"); err == nil {
+		t.Error("unexpected success parsing unknown units")
+	}
+}
+
+func TestDiffText(t *testing.T) {
+	from := snapshot.Snapshot{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Stats: sampleStats}
+	to := snapshot.Snapshot{Time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Stats: stats.CacheStats{
+		TotalKeys: 20,
+		Stats: map[string]stats.BinStats{
+			"default": {Keys: 6, Size: 74},
+			"page":    {Keys: 14, Size: 1},
+		},
+	}}
+	d := snapshot.Compare(from, to)
+	w := strings.Builder{}
+	if err := output.DiffText(&w, &d, output.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `Comparing 2024-01-01T00:00:00Z to 2024-01-02T00:00:00Z
+Bin     | Keys | Δ Keys |     % | Data | Δ Data |      %
+--------+------+--------+-------+------+--------+-------
+default |    6 |     -6 | -50.0 |   74 |    +37 | +100.0
+form    |    0 |    -13 |  gone |    0 |    -22 |   gone
+page    |   14 |    +14 |   new |    1 |     +1 |    new
+--------+------+--------+-------+------+--------+-------
+Total   |   20 |     -5 | -20.0 |   75 |    +16 |  +27.1
+`
+	if actual := w.String(); actual != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	d := snapshot.Compare(snapshot.New(sampleStats), snapshot.New(sampleStats))
+	w := strings.Builder{}
+	if err := output.DiffJSON(&w, &d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(w.String(), `"Status":"unchanged"`) {
+		t.Errorf("unexpected JSON: %s", w.String())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fgm/drupal_redis_stats/rdb"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// dsnDatabase returns the database designated by the DSN, 0 by default.
+func dsnDatabase(dsn string) (int, error) {
+	if isSentinelDSN(dsn) {
+		sc, err := parseSentinelDSN(dsn)
+		return sc.db, err
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing Redis DSN: %v", err)
+	}
+	db := strings.Trim(u.Path, "/")
+	if u.Scheme == socketScheme {
+		db = u.Query().Get("db")
+	}
+	if db == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(db)
+	if err != nil {
+		return 0, fmt.Errorf("invalid database in DSN: %w", err)
+	}
+	return n, nil
+}
+
+// scanRDB computes the results of an RDB dump file, like a scan would: those of
+// database db, or with all, those of each database holding Drupal cache keys,
+// and their total.
+func scanRDB(ctx context.Context, path, prefix string, scheme stats.Scheme, db int, all bool, w io.Writer) (stats.CacheStats, []stats.DatabaseStats, error) {
+	total := stats.CacheStats{Prefix: prefix, Stats: map[string]stats.BinStats{}}
+	f, err := os.Open(path)
+	if err != nil {
+		return total, nil, fmt.Errorf("failed opening RDB file: %w", err)
+	}
+	defer f.Close()
+	read, err := rdb.ScanContext(ctx, f, prefix, scheme, w)
+	var dbs []stats.DatabaseStats
+	for _, ds := range read {
+		switch {
+		case !all:
+			if ds.Index == db {
+				total = ds.Stats
+			}
+		case len(ds.Stats.Stats) > 0:
+			total.Merge(ds.Stats)
+			dbs = append(dbs, ds)
+		}
+	}
+	// The database may not have been read yet when the scan was interrupted.
+	if err != nil && ctx.Err() != nil {
+		total.Incomplete = true
+	}
+	return total, dbs, err
+}
@@ -0,0 +1,68 @@
+package rdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errLZF = errors.New("invalid LZF compressed string")
+
+// lzfMaxRatio bounds the expansion of LZF data: a 3 bytes back reference
+// produces at most 264 bytes.
+const lzfMaxRatio = 264
+
+// lzfMaxPrealloc is the largest output buffer allocated before decompressing,
+// larger ones growing with the data actually produced instead.
+const lzfMaxPrealloc = 1 << 20
+
+// lzfDecompress decompresses the LZF compressed strings of RDB files, of
+// length n once decompressed.
+//
+// LZF data is a sequence of literal runs, with a control byte below 32 holding
+// their length minus 1, and back references, with a control byte holding their
+// length minus 2 in its 3 high bits, extended by another byte if they are all
+// set, and their offset minus 1 in its 5 low bits and the next byte.
+func lzfDecompress(in []byte, n uint64) ([]byte, error) {
+	// Do not trust the length to allocate the buffer, in case the file is corrupt.
+	if n > uint64(len(in))*lzfMaxRatio {
+		return nil, fmt.Errorf("%w: %d bytes cannot expand to %d", errLZF, len(in), n)
+	}
+	out := make([]byte, 0, min(n, lzfMaxPrealloc))
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			run := ctrl + 1
+			if i+run > len(in) || uint64(len(out)+run) > n {
+				return nil, errLZF
+			}
+			out = append(out, in[i:i+run]...)
+			i += run
+			continue
+		}
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errLZF
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 || uint64(len(out)+length+2) > n {
+			return nil, errLZF
+		}
+		// References may overlap the bytes they produce, so copy byte by byte.
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if uint64(len(out)) != n {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", errLZF, len(out), n)
+	}
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package rdb
+
+import (
+	"math/bits"
+	"strconv"
+)
+
+// Sizes of Redis structures on 64 bits platforms, per Redis 7, used to estimate
+// memory usage like the MEMORY USAGE command does.
+const (
+	objectSize          = 16 // robj.
+	dictEntrySize       = 24
+	dictStructSize      = 56
+	pointerSize         = 8
+	quicklistStructSize = 40
+	quicklistNodeSize   = 32
+	zsetStructSize      = 16 + 32 // zset and zskiplist.
+	skiplistNodeSize    = 24 + 16 // With a single level, the most frequent.
+	skiplistLevels      = 32
+	streamSize          = 88
+	raxNodeSize         = 32 // Approximate cost of a radix tree entry.
+	pendingEntrySize    = 32 // streamNACK.
+	consumerSize        = 48
+)
+
+// minDictSlots is the initial number of slots of Redis hash tables.
+const minDictSlots = 4
+
+// embstrMaxLen is the maximum length of strings allocated along with their
+// object, with the EMBSTR encoding.
+const embstrMaxLen = 44
+
+/*
+alloc returns the size allocated by jemalloc, the Redis default allocator, for
+n bytes: sizes are rounded to multiples of 8, then 16 up to 128 bytes, then to
+4 classes per doubling.
+*/
+func alloc(n int64) int64 {
+	switch {
+	case n <= 0:
+		return 0
+	case n <= 8:
+		return 8
+	case n <= 128:
+		return (n + 15) &^ 15
+	}
+	step := int64(1) << (bits.Len64(uint64(n-1)) - 3)
+	return (n + step - 1) &^ (step - 1)
+}
+
+// sdsSize returns the memory used by an SDS string of length n, including its
+// header and terminating NUL.
+func sdsSize(n int) int64 {
+	var header int64
+	switch {
+	case n < 1<<5:
+		header = 1
+	case n < 1<<8:
+		header = 3
+	case n < 1<<16:
+		header = 5
+	case n < 1<<32:
+		header = 9
+	default:
+		header = 17
+	}
+	return alloc(header + int64(n) + 1)
+}
+
+// keySize returns the memory used by a key of length n in the main dictionary,
+// which MEMORY USAGE adds to the size of its value.
+func keySize(n int) int64 {
+	return sdsSize(n) + dictEntrySize
+}
+
+// stringSize returns the memory used by a string value, depending on its
+// encoding: INT for integers, EMBSTR for short strings, RAW otherwise.
+func stringSize(s []byte) int64 {
+	if len(s) <= 20 {
+		if i, err := strconv.ParseInt(string(s), 10, 64); err == nil && strconv.FormatInt(i, 10) == string(s) {
+			return objectSize
+		}
+	}
+	if len(s) <= embstrMaxLen {
+		return alloc(objectSize + 3 + int64(len(s)) + 1)
+	}
+	return objectSize + sdsSize(len(s))
+}
+
+// blobSize returns the memory used by a value encoded as a single blob, like a
+// listpack, a ziplist, or an intset, of length n.
+func blobSize(n int) int64 {
+	return objectSize + alloc(int64(n))
+}
+
+// dictSize returns the memory used by a hash table holding n entries, without
+// the entries themselves.
+func dictSize(n uint64) int64 {
+	slots := uint64(minDictSlots)
+	if n > slots {
+		slots = 1 << bits.Len64(n-1)
+	}
+	return objectSize + dictStructSize + pointerSize*int64(slots)
+}
+
+// skiplistSize returns the memory used by a sorted set holding n entries,
+// without the entries themselves.
+func skiplistSize(n uint64) int64 {
+	return dictSize(n) + zsetStructSize + alloc(24+16*skiplistLevels)
+}
+
+// skiplistEntrySize returns the memory used by a sorted set member of length n.
+func skiplistEntrySize(n int) int64 {
+	return sdsSize(n) + dictEntrySize + alloc(skiplistNodeSize)
+}
+
+// quicklistSize returns the memory used by a list of n nodes, without the data
+// of the nodes.
+func quicklistSize(n uint64) int64 {
+	return objectSize + quicklistStructSize + int64(n)*quicklistNodeSize
+}
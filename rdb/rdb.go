@@ -0,0 +1,715 @@
+/*
+Package rdb reads Redis RDB dump files, like the dump.rdb written by SAVE,
+BGSAVE, or at shutdown, to analyze the Drupal cache without a server.
+
+It supports RDB versions 9 to 11, written by Redis 5.0 to 7.2, and by
+compatible servers like Valkey 7.2. It returns each key with its type, expiry,
+and an estimate of its memory usage, close to the MEMORY USAGE of the key on the
+server which wrote the file.
+*/
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Supported RDB versions.
+const (
+	MinVersion = 9
+	MaxVersion = 11
+)
+
+// Opcodes preceding the entries, or replacing them.
+const (
+	opFunction2    = 0xF5
+	opFunctionPre  = 0xF6
+	opFreq         = 0xF7
+	opIdle         = 0xF8
+	opModuleAux    = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMS = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+)
+
+/*
+Type is the type of a value in an RDB file, which includes its encoding.
+*/
+type Type byte
+
+// Value types.
+const (
+	TypeString           Type = 0
+	TypeList             Type = 1
+	TypeSet              Type = 2
+	TypeZSet             Type = 3
+	TypeHash             Type = 4
+	TypeZSet2            Type = 5
+	TypeModule           Type = 6
+	TypeModule2          Type = 7
+	TypeHashZipmap       Type = 9
+	TypeListZiplist      Type = 10
+	TypeSetIntset        Type = 11
+	TypeZSetZiplist      Type = 12
+	TypeHashZiplist      Type = 13
+	TypeListQuicklist    Type = 14
+	TypeStreamListpacks  Type = 15
+	TypeHashListpack     Type = 16
+	TypeZSetListpack     Type = 17
+	TypeListQuicklist2   Type = 18
+	TypeStreamListpacks2 Type = 19
+	TypeSetListpack      Type = 20
+	TypeStreamListpacks3 Type = 21
+)
+
+/*
+Kind returns the Redis type of values of this type, as returned by the TYPE
+command, like "hash".
+*/
+func (t Type) Kind() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList, TypeListZiplist, TypeListQuicklist, TypeListQuicklist2:
+		return "list"
+	case TypeSet, TypeSetIntset, TypeSetListpack:
+		return "set"
+	case TypeZSet, TypeZSet2, TypeZSetZiplist, TypeZSetListpack:
+		return "zset"
+	case TypeHash, TypeHashZipmap, TypeHashZiplist, TypeHashListpack:
+		return "hash"
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return "stream"
+	case TypeModule, TypeModule2:
+		return "module"
+	default:
+		return "unknown"
+	}
+}
+
+// String encodings, flagged by the 2 high bits of a length.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Module value opcodes.
+const (
+	moduleEOF    = 0
+	moduleSInt   = 1
+	moduleUInt   = 2
+	moduleFloat  = 3
+	moduleDouble = 4
+	moduleString = 5
+)
+
+// jonesTable is the table of the CRC-64/Jones checksum ending RDB files, in the
+// reflected form used by the hash/crc64 package.
+var jonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+/*
+Entry is a key read from an RDB file.
+*/
+type Entry struct {
+	DB     int
+	Key    string
+	Type   Type
+	Expiry time.Time // Zero if the key does not expire.
+	Size   int64     // Estimated memory usage, in bytes.
+}
+
+/*
+Reader reads the entries of an RDB file in order.
+*/
+type Reader struct {
+	r       *crcReader
+	version int
+	db      int
+	dbSizes map[int]uint64
+	aux     map[string]string
+	done    bool
+}
+
+// crcReader computes the checksum of the bytes read.
+type crcReader struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc = ^crc64.Update(^cr.crc, jonesTable, p[:n])
+	return n, err
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc = ^crc64.Update(^cr.crc, jonesTable, []byte{b})
+	}
+	return b, err
+}
+
+/*
+NewReader returns a Reader for the RDB file read from r, after checking its
+header.
+*/
+func NewReader(r io.Reader) (*Reader, error) {
+	rr := &Reader{
+		r:       &crcReader{r: bufio.NewReaderSize(r, 64<<10)},
+		dbSizes: map[int]uint64{},
+		aux:     map[string]string{},
+	}
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(rr.r, header); err != nil {
+		return nil, fmt.Errorf("failed reading RDB header: %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, errors.New("not an RDB file: missing REDIS header")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RDB version %q", header[5:])
+	}
+	if version < MinVersion || version > MaxVersion {
+		return nil, fmt.Errorf("unsupported RDB version %d, expected %d to %d", version, MinVersion, MaxVersion)
+	}
+	rr.version = version
+	return rr, nil
+}
+
+/*
+Version returns the RDB version of the file.
+*/
+func (r *Reader) Version() int {
+	return r.version
+}
+
+/*
+Aux returns the auxiliary fields read so far, like redis-ver, most of which
+precede the entries.
+*/
+func (r *Reader) Aux() map[string]string {
+	return r.aux
+}
+
+/*
+DBSize returns the number of keys in the database, per the resize hint
+preceding its entries, once they are being read.
+*/
+func (r *Reader) DBSize(db int) uint64 {
+	return r.dbSizes[db]
+}
+
+/*
+Next returns the next entry, or io.EOF at the end of the file, once its
+checksum is verified.
+*/
+func (r *Reader) Next() (Entry, error) {
+	if r.done {
+		return Entry{}, io.EOF
+	}
+	e := Entry{DB: r.db}
+	for {
+		op, err := r.r.ReadByte()
+		if err != nil {
+			return Entry{}, r.unexpected(err)
+		}
+		switch op {
+		case opEOF:
+			r.done = true
+			if err = r.checksum(); err != nil {
+				return Entry{}, err
+			}
+			return Entry{}, io.EOF
+		case opSelectDB:
+			db, _, err := r.length()
+			if err != nil {
+				return Entry{}, err
+			}
+			r.db, e.DB = int(db), int(db)
+		case opResizeDB:
+			size, _, err := r.length()
+			if err != nil {
+				return Entry{}, err
+			}
+			if _, _, err = r.length(); err != nil { // Expires size.
+				return Entry{}, err
+			}
+			r.dbSizes[r.db] = size
+		case opAux:
+			key, err := r.string()
+			if err != nil {
+				return Entry{}, err
+			}
+			value, err := r.string()
+			if err != nil {
+				return Entry{}, err
+			}
+			r.aux[string(key)] = string(value)
+		case opExpireTimeMS:
+			ms, err := r.uint64()
+			if err != nil {
+				return Entry{}, err
+			}
+			e.Expiry = time.UnixMilli(int64(ms))
+		case opExpireTime:
+			var s [4]byte
+			if _, err = io.ReadFull(r.r, s[:]); err != nil {
+				return Entry{}, r.unexpected(err)
+			}
+			e.Expiry = time.Unix(int64(binary.LittleEndian.Uint32(s[:])), 0)
+		case opIdle:
+			if _, _, err = r.length(); err != nil {
+				return Entry{}, err
+			}
+		case opFreq:
+			if _, err = r.r.ReadByte(); err != nil {
+				return Entry{}, r.unexpected(err)
+			}
+		case opModuleAux:
+			if err = r.moduleAux(); err != nil {
+				return Entry{}, err
+			}
+		case opFunction2:
+			if _, err = r.string(); err != nil {
+				return Entry{}, err
+			}
+		case opFunctionPre:
+			return Entry{}, errors.New("unsupported pre-release function data in RDB file")
+		default:
+			e.Type = Type(op)
+			key, err := r.string()
+			if err != nil {
+				return Entry{}, err
+			}
+			e.Key = string(key)
+			size, err := r.value(e.Type)
+			if err != nil {
+				return Entry{}, fmt.Errorf("failed reading key %q: %w", e.Key, err)
+			}
+			e.Size = size + keySize(len(key))
+			return e, nil
+		}
+	}
+}
+
+// unexpected converts the end of the file to an error, since only the EOF
+// opcode ends a complete file.
+func (r *Reader) unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("failed reading RDB file: %w", err)
+}
+
+// checksum verifies the checksum ending the file, unless it is 0, when the
+// server does not compute it, per its rdbchecksum setting.
+func (r *Reader) checksum() error {
+	expected := r.r.crc
+	stored, err := r.uint64()
+	if err != nil {
+		return err
+	}
+	if stored != 0 && stored != expected {
+		return fmt.Errorf("invalid RDB checksum %016x, expected %016x", stored, expected)
+	}
+	return nil
+}
+
+// length reads a length, or the encoding of a string if encoded is true.
+func (r *Reader) length() (n uint64, encoded bool, err error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, false, r.unexpected(err)
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := r.r.ReadByte()
+		if err != nil {
+			return 0, false, r.unexpected(err)
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		var buf [8]byte
+		switch b {
+		case 0x80:
+			if _, err = io.ReadFull(r.r, buf[:4]); err != nil {
+				return 0, false, r.unexpected(err)
+			}
+			return uint64(binary.BigEndian.Uint32(buf[:4])), false, nil
+		case 0x81:
+			if _, err = io.ReadFull(r.r, buf[:]); err != nil {
+				return 0, false, r.unexpected(err)
+			}
+			return binary.BigEndian.Uint64(buf[:]), false, nil
+		default:
+			return 0, false, fmt.Errorf("invalid RDB length encoding %#x", b)
+		}
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+// bytes reads n raw bytes.
+func (r *Reader) bytes(n uint64) ([]byte, error) {
+	// Do not trust large lengths to allocate a buffer, in case the file is
+	// corrupt: let it grow with the data actually read instead.
+	if n > 1<<20 {
+		buf, err := io.ReadAll(io.LimitReader(r.r, int64(n)))
+		if err == nil && uint64(len(buf)) < n {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, r.unexpected(err)
+		}
+		return buf, nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, r.unexpected(err)
+	}
+	return buf, nil
+}
+
+// string reads a string, decoding integer and LZF encodings.
+func (r *Reader) string() ([]byte, error) {
+	n, encoded, err := r.length()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return r.bytes(n)
+	}
+	var buf [4]byte
+	switch n {
+	case encInt8:
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, r.unexpected(err)
+		}
+		return strconv.AppendInt(nil, int64(int8(b)), 10), nil
+	case encInt16:
+		if _, err = io.ReadFull(r.r, buf[:2]); err != nil {
+			return nil, r.unexpected(err)
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(buf[:2]))), 10), nil
+	case encInt32:
+		if _, err = io.ReadFull(r.r, buf[:]); err != nil {
+			return nil, r.unexpected(err)
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(buf[:]))), 10), nil
+	case encLZF:
+		clen, _, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := r.bytes(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, ulen)
+	default:
+		return nil, fmt.Errorf("invalid RDB string encoding %d", n)
+	}
+}
+
+// uint64 reads a little-endian 64 bits integer.
+func (r *Reader) uint64() (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return 0, r.unexpected(err)
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// score reads a sorted set score in the string format of TypeZSet.
+func (r *Reader) score() error {
+	n, err := r.r.ReadByte()
+	if err != nil {
+		return r.unexpected(err)
+	}
+	switch n {
+	case 253, 254, 255: // NaN, +Inf, -Inf.
+		return nil
+	default:
+		_, err = r.bytes(uint64(n))
+		return err
+	}
+}
+
+// value reads a value of type t, returning its estimated memory usage.
+func (r *Reader) value(t Type) (int64, error) {
+	switch t {
+	case TypeString:
+		s, err := r.string()
+		if err != nil {
+			return 0, err
+		}
+		return stringSize(s), nil
+
+	case TypeHashZipmap, TypeListZiplist, TypeSetIntset, TypeZSetZiplist, TypeHashZiplist, TypeHashListpack, TypeZSetListpack, TypeSetListpack:
+		blob, err := r.string()
+		if err != nil {
+			return 0, err
+		}
+		return blobSize(len(blob)), nil
+
+	case TypeSet, TypeHash:
+		n, _, err := r.length()
+		if err != nil {
+			return 0, err
+		}
+		size := dictSize(n)
+		for i := uint64(0); i < n; i++ {
+			member, err := r.string()
+			if err != nil {
+				return 0, err
+			}
+			size += dictEntrySize + sdsSize(len(member))
+			if t == TypeHash {
+				value, err := r.string()
+				if err != nil {
+					return 0, err
+				}
+				size += sdsSize(len(value))
+			}
+		}
+		return size, nil
+
+	case TypeZSet, TypeZSet2:
+		n, _, err := r.length()
+		if err != nil {
+			return 0, err
+		}
+		size := skiplistSize(n)
+		for i := uint64(0); i < n; i++ {
+			member, err := r.string()
+			if err != nil {
+				return 0, err
+			}
+			if t == TypeZSet {
+				err = r.score()
+			} else {
+				_, err = r.uint64()
+			}
+			if err != nil {
+				return 0, err
+			}
+			size += skiplistEntrySize(len(member))
+		}
+		return size, nil
+
+	case TypeList:
+		// Stored as a plain list by Redis before 3.2, loaded as a quicklist.
+		n, _, err := r.length()
+		if err != nil {
+			return 0, err
+		}
+		var packed int
+		for i := uint64(0); i < n; i++ {
+			element, err := r.string()
+			if err != nil {
+				return 0, err
+			}
+			packed += len(element) + 2
+		}
+		return quicklistSize(1) + alloc(int64(packed)+7), nil
+
+	case TypeListQuicklist, TypeListQuicklist2:
+		n, _, err := r.length()
+		if err != nil {
+			return 0, err
+		}
+		size := quicklistSize(n)
+		for i := uint64(0); i < n; i++ {
+			if t == TypeListQuicklist2 {
+				if _, _, err = r.length(); err != nil { // Container: plain or packed.
+					return 0, err
+				}
+			}
+			node, err := r.string()
+			if err != nil {
+				return 0, err
+			}
+			size += alloc(int64(len(node)))
+		}
+		return size, nil
+
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return r.stream(t)
+
+	case TypeModule2:
+		return r.module()
+
+	case TypeModule:
+		return 0, errors.New("unsupported module data in RDB version 1 format")
+
+	default:
+		return 0, fmt.Errorf("unsupported RDB value type %d", t)
+	}
+}
+
+// stream reads a stream value, returning a rough estimate of its memory usage,
+// based on the size of its listpacks.
+func (r *Reader) stream(t Type) (int64, error) {
+	n, _, err := r.length()
+	if err != nil {
+		return 0, err
+	}
+	size := int64(objectSize + streamSize)
+	for i := uint64(0); i < n; i++ {
+		if _, err = r.string(); err != nil { // Node key: the master entry ID.
+			return 0, err
+		}
+		lp, err := r.string()
+		if err != nil {
+			return 0, err
+		}
+		size += alloc(int64(len(lp))) + raxNodeSize
+	}
+	// Length, last ID, then first ID, max deleted ID and entries added since
+	// version 2.
+	lengths := 3
+	if t >= TypeStreamListpacks2 {
+		lengths += 5
+	}
+	if err = r.lengths(lengths); err != nil {
+		return 0, err
+	}
+	groups, _, err := r.length()
+	if err != nil {
+		return 0, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if _, err = r.string(); err != nil { // Name.
+			return 0, err
+		}
+		lengths = 2 // Last delivered ID.
+		if t >= TypeStreamListpacks2 {
+			lengths++ // Entries read.
+		}
+		if err = r.lengths(lengths); err != nil {
+			return 0, err
+		}
+		pending, _, err := r.length()
+		if err != nil {
+			return 0, err
+		}
+		for j := uint64(0); j < pending; j++ {
+			// Entry ID, delivery time, then delivery count.
+			if _, err = r.bytes(16 + 8); err != nil {
+				return 0, err
+			}
+			if err = r.lengths(1); err != nil {
+				return 0, err
+			}
+		}
+		size += int64(pending) * (raxNodeSize + pendingEntrySize)
+		consumers, _, err := r.length()
+		if err != nil {
+			return 0, err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			name, err := r.string()
+			if err != nil {
+				return 0, err
+			}
+			times := 8 // Seen time, then active time since version 3.
+			if t >= TypeStreamListpacks3 {
+				times += 8
+			}
+			if _, err = r.bytes(uint64(times)); err != nil {
+				return 0, err
+			}
+			pending, _, err := r.length()
+			if err != nil {
+				return 0, err
+			}
+			if _, err = r.bytes(16 * pending); err != nil { // Entry IDs.
+				return 0, err
+			}
+			size += sdsSize(len(name)) + consumerSize + int64(pending)*raxNodeSize
+		}
+	}
+	return size, nil
+}
+
+// lengths skips n lengths.
+func (r *Reader) lengths(n int) error {
+	for i := 0; i < n; i++ {
+		if _, _, err := r.length(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// module reads a module value in the self-describing version 2 format,
+// returning its size in the file, since its memory usage is unknown.
+func (r *Reader) module() (int64, error) {
+	if _, _, err := r.length(); err != nil { // Module ID.
+		return 0, err
+	}
+	return r.moduleValues()
+}
+
+// moduleAux reads module auxiliary data, in the same format as module values.
+func (r *Reader) moduleAux() error {
+	// Module ID, then when opcode and when.
+	if err := r.lengths(3); err != nil {
+		return err
+	}
+	_, err := r.moduleValues()
+	return err
+}
+
+// moduleValues reads the values of a module, up to their EOF opcode.
+func (r *Reader) moduleValues() (int64, error) {
+	var size int64
+	for {
+		op, _, err := r.length()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case moduleEOF:
+			return size, nil
+		case moduleSInt, moduleUInt:
+			_, _, err = r.length()
+			size += 8
+		case moduleFloat:
+			_, err = r.bytes(4)
+			size += 4
+		case moduleDouble:
+			_, err = r.bytes(8)
+			size += 8
+		case moduleString:
+			var s []byte
+			s, err = r.string()
+			size += int64(len(s))
+		default:
+			return 0, fmt.Errorf("invalid module value opcode %d", op)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// builder writes RDB files for tests.
+type builder struct {
+	bytes.Buffer
+}
+
+func newBuilder(version string) *builder {
+	b := &builder{}
+	b.WriteString("REDIS" + version)
+	return b
+}
+
+func (b *builder) length(n uint64) *builder {
+	switch {
+	case n < 1<<6:
+		b.WriteByte(byte(n))
+	case n < 1<<14:
+		b.Write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n < 1<<32:
+		b.WriteByte(0x80)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		b.WriteByte(0x81)
+		b.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+	return b
+}
+
+func (b *builder) str(s string) *builder {
+	b.length(uint64(len(s)))
+	b.WriteString(s)
+	return b
+}
+
+func (b *builder) op(op byte) *builder {
+	b.WriteByte(op)
+	return b
+}
+
+func (b *builder) raw(p ...byte) *builder {
+	b.Write(p)
+	return b
+}
+
+// end writes the EOF opcode and the checksum of the file.
+func (b *builder) end() []byte {
+	b.WriteByte(opEOF)
+	crc := ^crc64.Update(^uint64(0), jonesTable, b.Bytes())
+	b.Write(binary.LittleEndian.AppendUint64(nil, crc))
+	return b.Bytes()
+}
+
+func TestChecksum(t *testing.T) {
+	// The check value of CRC-64/Jones, per the Redis sources.
+	if actual := ^crc64.Update(^uint64(0), jonesTable, []byte("123456789")); actual != 0xe9c6d914c4b8d9ca {
+		t.Errorf("got %016x, expected e9c6d914c4b8d9ca", actual)
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		in       []byte
+		n        uint64
+		expected string
+	}{
+		{"literal", []byte{2, 'a', 'b', 'c'}, 3, "abc"},
+		{"reference", []byte{2, 'a', 'b', 'c', 0x20, 2}, 6, "abcabc"},
+		{"extended overlapping reference", []byte{2, 'a', 'b', 'c', 0xE0, 0, 2}, 12, "abcabcabcabc"},
+		{"truncated literal", []byte{2, 'a'}, 3, ""},
+		{"reference before start", []byte{0, 'a', 0x20, 5}, 4, ""},
+		{"wrong length", []byte{2, 'a', 'b', 'c'}, 4, ""},
+		{"longer than length", []byte{2, 'a', 'b', 'c', 0x20, 2}, 4, ""},
+		{"huge length", []byte{2, 'a', 'b', 'c'}, 1<<63 - 1, ""},
+		{"impossible length", []byte{2, 'a', 'b', 'c'}, 64 << 30, ""},
+		{"truncated reference", []byte{2, 'a', 'b', 'c', 0x20}, 6, ""},
+		{"truncated extended reference", []byte{2, 'a', 'b', 'c', 0xE0}, 12, ""},
+		{"truncated extended reference offset", []byte{2, 'a', 'b', 'c', 0xE0, 0}, 12, ""},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := lzfDecompress(check.in, check.n)
+			if check.expected == "" {
+				if !errors.Is(err, errLZF) {
+					t.Fatalf("expected an LZF error, got %q, %v", actual, err)
+				}
+				return
+			}
+			if err != nil || string(actual) != check.expected {
+				t.Errorf("got %q, %v, expected %q", actual, err, check.expected)
+			}
+		})
+	}
+}
+
+func TestAlloc(t *testing.T) {
+	checks := [...]struct {
+		n, expected int64
+	}{
+		{0, 0}, {1, 8}, {9, 16}, {24, 32}, {128, 128}, {129, 160}, {257, 320}, {640, 640}, {641, 768},
+	}
+	for _, check := range checks {
+		if actual := alloc(check.n); actual != check.expected {
+			t.Errorf("alloc(%d): got %d, expected %d", check.n, actual, check.expected)
+		}
+	}
+}
+
+// testFile returns an RDB file holding values of all supported kinds.
+func testFile() []byte {
+	b := newBuilder("0011").
+		op(opAux).str("redis-ver").str("7.2.4").
+		op(opAux).str("ctime").raw(0xC2).raw(binary.LittleEndian.AppendUint32(nil, 1700000000)...).
+		op(opModuleAux).length(12345).length(moduleUInt).length(2).length(moduleString).str("aux").length(moduleEOF).
+		op(opFunction2).str("#!lua name=lib\n").
+		op(opSelectDB).length(0).
+		op(opResizeDB).length(9).length(1)
+	// Strings: integer, embedded, raw, and LZF compressed.
+	b.op(byte(TypeString)).str("int").raw(0xC1, 0x39, 0x30)
+	b.op(byte(TypeString)).str("embstr").str("hello")
+	b.op(byte(TypeString)).str("raw").str(strings.Repeat("x", 50))
+	b.op(byte(TypeString)).str("lzf").raw(0xC3).length(7).length(12).raw(2, 'a', 'b', 'c', 0xE0, 0, 2)
+	// A hash table encoded hash, expiring, with idle and frequency hints.
+	b.op(opExpireTimeMS).raw(binary.LittleEndian.AppendUint64(nil, 1800000000000)...).
+		op(opIdle).length(10).op(opFreq).raw(5).
+		op(byte(TypeHash)).str("h").length(2).str("a").str("b").str("cc").str("dd")
+	// Blobs: listpack hash, intset, and quicklist of listpacks.
+	b.op(byte(TypeHashListpack)).str("lp").str(strings.Repeat("l", 30))
+	b.op(byte(TypeSetIntset)).str("is").str("12345678")
+	b.op(byte(TypeListQuicklist2)).str("ql").length(2).length(2).str("node1").length(1).str("plain")
+	// A sorted set, with binary scores.
+	b.op(byte(TypeZSet2)).str("z").length(1).str("m").raw(make([]byte, 8)...)
+	// A stream with a consumer group, a pending entry, and a consumer.
+	b.op(byte(TypeStreamListpacks3)).str("s").length(1).str(strings.Repeat("\x00", 16)).str("listpack").
+		length(1).length(1).length(1).length(1).length(1).length(0).length(0).length(1).
+		length(1).str("group").length(1).length(1).length(1).
+		length(1).raw(make([]byte, 16+8)...).length(1).
+		length(1).str("consumer").raw(make([]byte, 16)...).length(1).raw(make([]byte, 16)...)
+	// A module value.
+	b.op(byte(TypeModule2)).str("mod").length(12345).length(moduleDouble).raw(make([]byte, 8)...).length(moduleEOF)
+	// Another database, with an expiry in seconds.
+	b.op(opSelectDB).length(2).op(opResizeDB).length(1).length(1).
+		op(opExpireTime).raw(binary.LittleEndian.AppendUint32(nil, 1600000000)...).
+		op(byte(TypeString)).str("old").str("v")
+	return b.end()
+}
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(testFile()))
+	if err != nil {
+		t.Fatalf("failed creating reader: %v", err)
+	}
+	if r.Version() != 11 {
+		t.Errorf("got version %d, expected 11", r.Version())
+	}
+	checks := [...]struct {
+		key    string
+		db     int
+		kind   string
+		expiry time.Time
+		size   int64
+	}{
+		{"int", 0, "string", time.Time{}, 16 + 8 + 24},
+		{"embstr", 0, "string", time.Time{}, 32 + 8 + 24},
+		{"raw", 0, "string", time.Time{}, 16 + 64 + 8 + 24},
+		{"lzf", 0, "string", time.Time{}, 32 + 8 + 24},
+		{"h", 0, "hash", time.UnixMilli(1800000000000), 104 + 40 + 40 + 8 + 24},
+		{"lp", 0, "hash", time.Time{}, 16 + 32 + 8 + 24},
+		{"is", 0, "set", time.Time{}, 16 + 8 + 8 + 24},
+		{"ql", 0, "list", time.Time{}, 16 + 40 + 2*32 + 8 + 8 + 8 + 24},
+		{"z", 0, "zset", time.Time{}, 104 + 48 + 640 + 8 + 24 + 48 + 8 + 24},
+		{"s", 0, "stream", time.Time{}, 0},
+		{"mod", 0, "module", time.Time{}, 8 + 8 + 24},
+		{"old", 2, "string", time.Unix(1600000000, 0), 32 + 8 + 24},
+	}
+	for _, check := range checks {
+		t.Run(check.key, func(t *testing.T) {
+			e, err := r.Next()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if e.Key != check.key || e.DB != check.db || e.Type.Kind() != check.kind || !e.Expiry.Equal(check.expiry) {
+				t.Errorf("got %+v, expected %s in database %d, of kind %s, expiring at %v", e, check.key, check.db, check.kind, check.expiry)
+			}
+			if check.size != 0 && e.Size != check.size {
+				t.Errorf("got size %d, expected %d", e.Size, check.size)
+			}
+		})
+	}
+	if _, err = r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if r.Aux()["redis-ver"] != "7.2.4" || r.Aux()["ctime"] != "1700000000" || r.DBSize(0) != 9 || r.DBSize(2) != 1 {
+		t.Errorf("unexpected metadata: %v, sizes %d and %d", r.Aux(), r.DBSize(0), r.DBSize(2))
+	}
+}
+
+func TestReaderSad(t *testing.T) {
+	file := testFile()
+	corrupt := bytes.Clone(file)
+	corrupt[len(corrupt)-1] ^= 0xFF
+	noChecksum := append(bytes.Clone(file[:len(file)-8]), make([]byte, 8)...)
+	checks := [...]struct {
+		name     string
+		file     []byte
+		expError string // Empty for no error.
+	}{
+		{"not RDB", []byte("HELLO0011"), "not an RDB file"},
+		{"bad version", []byte("REDIS00x1"), "invalid RDB version"},
+		{"old version", newBuilder("0008").end(), "unsupported RDB version 8"},
+		{"new version", newBuilder("0012").end(), "unsupported RDB version 12"},
+		{"truncated", file[:len(file)/2], "unexpected EOF"},
+		{"bad checksum", corrupt, "invalid RDB checksum"},
+		{"no checksum", noChecksum, ""},
+		{"huge LZF length", newBuilder("0011").op(byte(TypeString)).str("k").raw(0xC3).length(4).
+			raw(0x81, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF).raw(2, 'a', 'b', 'c').end(), "invalid LZF"},
+		{"module v1", newBuilder("0009").op(byte(TypeModule)).str("m").end(), "unsupported module data"},
+		{"unknown type", newBuilder("0010").op(42).str("k").end(), "unsupported RDB value type 42"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(check.file))
+			for err == nil {
+				_, err = r.Next()
+			}
+			if check.expError == "" {
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("expected EOF, got %v", err)
+				}
+				return
+			}
+			if !strings.Contains(err.Error(), check.expError) {
+				t.Errorf("expected error containing %q, got %v", check.expError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package rdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+	"github.com/fgm/drupal_redis_stats/stats/progress"
+)
+
+// progressKeys is the number of keys read between two progress updates, like
+// a SCAN batch.
+const progressKeys = 1000
+
+/*
+ScanContext reads an RDB file from r, and returns the statistics of the Drupal
+cache keys in each of its databases, in file order, like those of a scan.
+
+  - prefix is the Drupal cache prefix, as in stats.CacheStats.Prefix.
+  - scheme is the key scheme, as in stats.CacheStats.Scheme. With
+    stats.SchemeAuto, the keys of each database are classified with all schemes,
+    keeping the results of the scheme matching the most keys.
+  - w is a logging output (think os.Stderr), not the main output, on which the
+    progress of each database is reported, like by stats.CacheStats.ScanContext.
+
+The TotalKeys of each database counts all its keys, like DBSIZE. Keys which had
+already expired when the file was written are ignored, since the server would
+have deleted them on load.
+
+When ctx is done, it stops reading, marks the database being read incomplete,
+and returns the context error along with the results read until then.
+*/
+func ScanContext(ctx context.Context, r io.Reader, prefix string, scheme stats.Scheme, w io.Writer) ([]stats.DatabaseStats, error) {
+	rr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var dbs []stats.DatabaseStats
+	var db *database // The database being read.
+	// end reports the end of the database being read, and adds its results.
+	end := func(err error) {
+		if db == nil {
+			return
+		}
+		db.pr.Done(db.status(), err)
+		res := stats.DatabaseStats{Index: db.index, Stats: stats.MostKeys(db.candidates)}
+		res.Stats.Incomplete = ctx.Err() != nil
+		dbs = append(dbs, res)
+		db = nil
+	}
+	for {
+		if err = ctx.Err(); err != nil {
+			end(err)
+			return dbs, err
+		}
+		e, err := rr.Next()
+		if errors.Is(err, io.EOF) {
+			end(nil)
+			return dbs, nil
+		}
+		if err != nil {
+			end(err)
+			return dbs, err
+		}
+		if db == nil || e.DB != db.index {
+			end(nil)
+			db = newDatabase(e.DB, rr, prefix, scheme, w)
+		}
+		db.seen++
+		if !e.Expiry.IsZero() && e.Expiry.Before(db.created) {
+			continue
+		}
+		for i := range db.candidates {
+			db.candidates[i].TotalKeys++
+			db.candidates[i].AddKey(e.Key, e.Size)
+		}
+		if db.seen%progressKeys == 0 {
+			db.pr.Update(db.status())
+		}
+	}
+}
+
+// database holds the results of a database being read, for each candidate
+// scheme.
+type database struct {
+	index      int
+	total      uint64    // The number of keys, per the resize hint.
+	created    time.Time // The time the file was written.
+	seen       uint64
+	candidates []stats.CacheStats
+	pr         progress.Reporter
+}
+
+// newDatabase starts reading a database, reporting its progress on w.
+func newDatabase(index int, rr *Reader, prefix string, scheme stats.Scheme, w io.Writer) *database {
+	db := &database{index: index, total: rr.DBSize(index), created: rr.created(), pr: progress.New(w)}
+	for _, s := range stats.Schemes(scheme) {
+		db.candidates = append(db.candidates, stats.CacheStats{Prefix: prefix, Scheme: s, Stats: map[string]stats.BinStats{}})
+	}
+	db.pr.Start(db.status())
+	return db
+}
+
+// status returns the progress of the database, for the best candidate.
+func (db *database) status() progress.Status {
+	cs := stats.MostKeys(db.candidates)
+	return progress.Status{
+		Total:   db.total,
+		Seen:    db.seen,
+		Matched: uint64(cs.ScannedKeys()),
+		Bins:    len(cs.Stats),
+		Size:    cs.TotalSize(),
+	}
+}
+
+// created returns the time the file was written, from its ctime auxiliary
+// field, or the zero time if it is missing.
+func (r *Reader) created() time.Time {
+	ctime, err := strconv.ParseInt(r.aux["ctime"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(ctime, 0)
+}
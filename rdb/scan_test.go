@@ -0,0 +1,73 @@
+package rdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func TestScanContext(t *testing.T) {
+	const prefix = "drupal.redis.10.1.0"
+	b := newBuilder("0010").
+		op(opAux).str("ctime").raw(0xC2).raw(binary.LittleEndian.AppendUint32(nil, 1700000000)...).
+		op(opSelectDB).length(0).op(opResizeDB).length(4).length(1)
+	b.op(byte(TypeHashListpack)).str(prefix + ":render:a").str("listpack")
+	b.op(byte(TypeHash)).str(prefix + ":render:b").length(1).str("data").str("value")
+	b.op(opExpireTime).raw(binary.LittleEndian.AppendUint32(nil, 1600000000)...).
+		op(byte(TypeHashListpack)).str(prefix + ":page:expired").str("listpack")
+	b.op(byte(TypeString)).str("other").str("value")
+	b.op(opSelectDB).length(3).op(opResizeDB).length(1).length(0).
+		op(byte(TypeHashListpack)).str("site:config:c").str("listpack")
+	// A Drupal 7 site, with a lock which is not a cache key.
+	b.op(opSelectDB).length(5).op(opResizeDB).length(3).length(0)
+	for _, key := range []string{"site:cache_page:a", "site:cache:b", "site:lock:c"} {
+		b.op(byte(TypeHashListpack)).str(key).str("listpack")
+	}
+	file := b.end()
+
+	dbs, err := ScanContext(context.Background(), bytes.NewReader(file), "", stats.SchemeAuto, io.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dbs) != 3 || dbs[0].Index != 0 || dbs[1].Index != 3 || dbs[2].Index != 5 {
+		t.Fatalf("unexpected databases: %+v", dbs)
+	}
+	cs := dbs[0].Stats
+	if cs.TotalKeys != 3 || len(cs.Stats) != 1 || cs.Stats["render"].Keys != 2 || cs.Incomplete {
+		t.Errorf("unexpected database 0 results: %+v", cs)
+	}
+	// The listpack, and the hash table with its entry, plus their keys.
+	keys := 2 * keySize(len(prefix+":render:a"))
+	if expected := blobSize(8) + dictSize(1) + dictEntrySize + sdsSize(4) + sdsSize(5) + keys; cs.TotalSize() != expected {
+		t.Errorf("got size %d, expected %d", cs.TotalSize(), expected)
+	}
+	if dbs[1].Stats.TotalKeys != 1 || len(dbs[1].Stats.Stats) != 0 {
+		t.Errorf("unexpected database 3 results: %+v", dbs[1].Stats)
+	}
+	if d7 := dbs[2].Stats; d7.Scheme != stats.SchemeD7 || d7.TotalKeys != 3 || d7.Stats["page"].Keys != 1 || d7.Stats["default"].Keys != 1 || cs.Scheme != stats.SchemeD8 {
+		t.Errorf("unexpected database 5 results: %+v", d7)
+	}
+	dbs, err = ScanContext(context.Background(), bytes.NewReader(file), "", stats.SchemeD8, io.Discard)
+	if err != nil || len(dbs[2].Stats.Stats) != 0 {
+		t.Errorf("unexpected results with the d8 scheme: %+v, %v", dbs, err)
+	}
+
+	dbs, err = ScanContext(context.Background(), bytes.NewReader(file), "site", stats.SchemeAuto, io.Discard)
+	if err != nil || dbs[1].Stats.Stats["config"].Keys != 1 || len(dbs[0].Stats.Stats) != 0 {
+		t.Errorf("unexpected results with prefix: %+v, %v", dbs, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if dbs, err = ScanContext(ctx, bytes.NewReader(file), "", stats.SchemeAuto, io.Discard); !errors.Is(err, context.Canceled) || len(dbs) != 0 {
+		t.Errorf("got %+v, %v, expected no results and a canceled error", dbs, err)
+	}
+	if _, err = ScanContext(context.Background(), bytes.NewReader(file[:len(file)-3]), "", stats.SchemeAuto, io.Discard); err == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
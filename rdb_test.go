@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRDB writes an RDB file holding string keys in databases 0 and 2, without
+// a checksum, and returns its path.
+func writeRDB(t *testing.T) string {
+	var b bytes.Buffer
+	b.WriteString("REDIS0011")
+	for _, db := range [...]struct {
+		index byte
+		keys  []string
+	}{
+		{0, []string{"drupal.redis.10.1.0:render:a", "drupal.redis.10.1.0:render:b", "drupal.redis.10.1.0:page:c", "unrelated"}},
+		{2, []string{"drupal.redis.10.1.0:config:d"}},
+	} {
+		b.Write([]byte{0xFE, db.index})
+		for _, key := range db.keys {
+			b.Write([]byte{0, byte(len(key))}) // String type, then the key length.
+			b.WriteString(key)
+			b.Write([]byte{5})
+			b.WriteString("value")
+		}
+	}
+	b.Write([]byte{0xFF, 0, 0, 0, 0, 0, 0, 0, 0})
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		t.Fatalf("failed writing RDB file: %v", err)
+	}
+	return path
+}
+
+func TestDSNDatabase(t *testing.T) {
+	checks := [...]struct {
+		dsn      string
+		expected int
+		expError bool
+	}{
+		{"redis://localhost:6379", 0, false},
+		{"redis://localhost:6379/3", 3, false},
+		{"unix:///run/redis.sock?db=2", 2, false},
+		{"redis-sentinel://localhost:26379/mymaster/4", 4, false},
+		{"redis://localhost:6379/x", 0, true},
+	}
+	for _, check := range checks {
+		t.Run(check.dsn, func(t *testing.T) {
+			actual, err := dsnDatabase(check.dsn)
+			if (err != nil) != check.expError || actual != check.expected {
+				t.Errorf("got %d, %v, expected %d, error %t", actual, err, check.expected, check.expError)
+			}
+		})
+	}
+}
+
+func TestRunRDB(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir()) // Ignore any user configuration.
+	path := writeRDB(t)
+
+	cs, err := runJSON(t, noEnv, "-rdb", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs.TotalKeys != 4 || cs.Stats["render"].Keys != 2 || cs.Stats["page"].Keys != 1 || cs.Stats["render"].Size == 0 {
+		t.Errorf("unexpected database 0 results: %+v", cs)
+	}
+	if cs, err = runJSON(t, noEnv, "-rdb", path, "-dsn", "redis://localhost/2"); err != nil || cs.Stats["config"].Keys != 1 {
+		t.Errorf("unexpected database 2 results: %+v, %v", cs, err)
+	}
+
+	stdout := strings.Builder{}
+	if err = run([]string{"-q", "-rdb", path, "-all-dbs"}, &stdout, &strings.Builder{}, noEnv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"Database 0", "Database 2", "All 2 databases"} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("did not find %q in output:\n%s", expected, stdout.String())
+		}
+	}
+
+	checks := [...]struct {
+		name     string
+		args     []string
+		expError string
+	}{
+		{"missing", []string{"-rdb", filepath.Join(t.TempDir(), "missing.rdb")}, "failed opening RDB file"},
+		{"watch", []string{"-rdb", path, "-watch", "1s"}, "cannot be used with"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if _, err := runJSON(t, noEnv, check.args...); err == nil || !strings.Contains(err.Error(), check.expError) {
+				t.Errorf("expected error containing %q, got %v", check.expError, err)
+			}
+		})
+	}
+}
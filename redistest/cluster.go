@@ -0,0 +1,136 @@
+package redistest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+ClusterNode is a node of the cluster reported by a server, per SetCluster.
+*/
+type ClusterNode struct {
+	ID string
+	// Addr is the host:port address of the node, which may be another Server.
+	Addr string
+	// TLSPort, if not 0, is reported as the tls-port of the node in CLUSTER
+	// SHARDS, like for nodes configured with both port and tls-port.
+	TLSPort int
+	// Primary is the ID of the primary of a replica, empty for primaries.
+	Primary string
+	// Health is online, failed, or loading, as in CLUSTER SHARDS. Empty means
+	// online. CLUSTER NODES only reports failed nodes, with the fail flag.
+	Health string
+	// Slots are the slot ranges served by a primary, as [start, end] pairs.
+	Slots [][2]int
+}
+
+/*
+SetCluster makes the server report being part of a cluster of these nodes, in
+CLUSTER SHARDS, CLUSTER NODES, and the mode reported by HELLO and INFO. Each
+primary forms a shard with its replicas.
+
+The server does not check that the keys it holds belong to its slots.
+*/
+func (s *Server) SetCluster(nodes []ClusterNode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cluster = append([]ClusterNode(nil), nodes...)
+}
+
+// mode returns the server mode reported by HELLO and INFO.
+func (s *Server) mode() string {
+	if s.cluster != nil {
+		return "cluster"
+	}
+	return "standalone"
+}
+
+// clusterCommand implements the CLUSTER subcommands.
+func (s *Server) clusterCommand(args []string) interface{} {
+	if s.cluster == nil {
+		return replyError("ERR This instance has cluster support disabled")
+	}
+	sub := strings.ToUpper(args[0])
+	if s.disabled["CLUSTER "+sub] {
+		return errorf("ERR unknown subcommand '%s'. Try CLUSTER HELP.", args[0])
+	}
+	switch sub {
+	case "SHARDS":
+		return s.clusterShards()
+	case "NODES":
+		return s.clusterNodes()
+	default:
+		return errorf("ERR unknown subcommand '%s'. Try CLUSTER HELP.", args[0])
+	}
+}
+
+// clusterShards returns the CLUSTER SHARDS reply: for each primary, its slot
+// bounds, then its own fields followed by those of its replicas.
+func (s *Server) clusterShards() interface{} {
+	var shards []interface{}
+	for _, primary := range s.cluster {
+		if primary.Primary != "" {
+			continue
+		}
+		var bounds []interface{}
+		for _, r := range primary.Slots {
+			bounds = append(bounds, int64(r[0]), int64(r[1]))
+		}
+		nodes := []interface{}{shardNode(primary)}
+		for _, n := range s.cluster {
+			if n.Primary == primary.ID {
+				nodes = append(nodes, shardNode(n))
+			}
+		}
+		shards = append(shards, []interface{}{"slots", bounds, "nodes", nodes})
+	}
+	return shards
+}
+
+// shardNode returns the fields of a node in the CLUSTER SHARDS reply.
+func shardNode(n ClusterNode) []interface{} {
+	host, port, _ := net.SplitHostPort(n.Addr)
+	p, _ := strconv.ParseInt(port, 10, 64)
+	role, health := "master", n.Health
+	if n.Primary != "" {
+		role = "replica"
+	}
+	if health == "" {
+		health = "online"
+	}
+	fields := []interface{}{"id", n.ID, "port", p}
+	if n.TLSPort != 0 {
+		fields = append(fields, "tls-port", int64(n.TLSPort))
+	}
+	return append(fields, "ip", host, "endpoint", host, "role", role, "health", health)
+}
+
+// clusterNodes returns the CLUSTER NODES reply, one line per node:
+//
+//	<id> <ip:port@cport> <flags> <primary> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ... <slot>
+func (s *Server) clusterNodes() interface{} {
+	var b strings.Builder
+	for _, n := range s.cluster {
+		_, port, _ := net.SplitHostPort(n.Addr)
+		busPort, _ := strconv.Atoi(port)
+		busPort += 10000
+		flags, primary, link := "master", "-", "connected"
+		if n.Primary != "" {
+			flags, primary = "slave", n.Primary
+		}
+		if n.Addr == s.Addr {
+			flags = "myself," + flags
+		}
+		if n.Health == "failed" {
+			flags, link = flags+",fail", "disconnected"
+		}
+		fmt.Fprintf(&b, "%s %s@%d %s %s 0 0 1 %s", n.ID, n.Addr, busPort, flags, primary, link)
+		for _, r := range n.Slots {
+			fmt.Fprintf(&b, " %d-%d", r[0], r[1])
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
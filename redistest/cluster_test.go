@@ -0,0 +1,72 @@
+package redistest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+)
+
+func TestServerCluster(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	c := newConn(t, s)
+	if _, err := c.Do("CLUSTER", "SHARDS"); err == nil || !strings.Contains(err.Error(), "cluster support disabled") {
+		t.Errorf("got %v, expected cluster support to be disabled", err)
+	}
+
+	s.SetCluster([]redistest.ClusterNode{
+		{ID: "p0", Addr: s.Addr, Slots: [][2]int{{0, 8191}}},
+		{ID: "r0", Addr: "127.0.0.1:7001", Primary: "p0"},
+		{ID: "p1", Addr: "127.0.0.1:7002", TLSPort: 7102, Slots: [][2]int{{8192, 16383}}, Health: "failed"},
+	})
+	shards, err := redis.Values(c.Do("CLUSTER", "SHARDS"))
+	if err != nil || len(shards) != 2 {
+		t.Fatalf("got %d shards, %v, expected 2", len(shards), err)
+	}
+	first, _ := redis.Values(shards[0], nil)
+	if bounds, _ := redis.Ints(first[1], nil); len(bounds) != 2 || bounds[1] != 8191 {
+		t.Errorf("got slots %v, expected 0-8191", bounds)
+	}
+	if nodes, _ := redis.Values(first[3], nil); len(nodes) != 2 {
+		t.Errorf("got %d nodes in the first shard, expected the primary and its replica", len(nodes))
+	}
+	second, _ := redis.Values(shards[1], nil)
+	nodes, _ := redis.Values(second[3], nil)
+	fields, _ := redis.Values(nodes[0], nil)
+	port, _ := redis.Int(fields[3], nil)
+	tlsPort, _ := redis.Int(fields[5], nil)
+	role, _ := redis.String(fields[11], nil)
+	health, _ := redis.String(fields[13], nil)
+	if port != 7002 || tlsPort != 7102 || role != "master" || health != "failed" {
+		t.Errorf("got ports %d and %d, role %s and health %s, expected a failed primary on 7002 and 7102", port, tlsPort, role, health)
+	}
+
+	text, err := redis.String(c.Do("CLUSTER", "NODES"))
+	if err != nil {
+		t.Fatalf("failed CLUSTER NODES: %v", err)
+	}
+	for _, expected := range []string{
+		"p0 " + s.Addr + "@",
+		" myself,master - 0 0 1 connected 0-8191\n",
+		"r0 127.0.0.1:7001@17001 slave p0 0 0 1 connected\n",
+		"p1 127.0.0.1:7002@17002 master,fail - 0 0 1 disconnected 8192-16383\n",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("did not find %q in CLUSTER NODES:\n%s", expected, text)
+		}
+	}
+	if info, _ := redis.String(c.Do("INFO", "server")); !strings.Contains(info, "redis_mode:cluster\r\n") {
+		t.Errorf("cluster mode not reported by INFO:\n%s", info)
+	}
+
+	s.Disable("cluster shards")
+	if _, err = c.Do("CLUSTER", "SHARDS"); err == nil || !strings.Contains(err.Error(), "unknown subcommand") {
+		t.Errorf("got %v, expected an unknown subcommand error", err)
+	}
+	if _, err = c.Do("CLUSTER", "NODES"); err != nil {
+		t.Errorf("failed CLUSTER NODES with SHARDS disabled: %v", err)
+	}
+}
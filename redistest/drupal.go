@@ -0,0 +1,94 @@
+package redistest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/generate"
+)
+
+// DefaultPrefix is the default Drupal redis module key prefix.
+const DefaultPrefix = generate.DefaultPrefix
+
+/*
+CacheItem is a Drupal cache item, as stored by the Drupal redis module.
+*/
+type CacheItem = generate.CacheItem
+
+/*
+Set stores a string value.
+*/
+func (s *Server) Set(db int, key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbs[db][key] = &entry{str: value}
+}
+
+/*
+SetSized stores a string value padded for MEMORY USAGE to report size bytes for
+the key, to assert exact scan results. It panics if size is too small for the
+key and its overhead, since it is meant for tests.
+*/
+func (s *Server) SetSized(db int, key string, size int64) {
+	pad := size - (&entry{}).memoryUsage(key)
+	if pad < 0 {
+		panic(fmt.Errorf("redistest: size %d too small for key %q", size, key))
+	}
+	s.Set(db, key, strings.Repeat("x", int(pad)))
+}
+
+/*
+HSet stores a hash, replacing any previous value.
+*/
+func (s *Server) HSet(db int, key string, fields map[string]string) {
+	hash := make(map[string]string, len(fields))
+	for k, v := range fields {
+		hash[k] = v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbs[db][key] = &entry{hash: hash}
+}
+
+/*
+Expire sets the time to live of an existing key.
+*/
+func (s *Server) Expire(db int, key string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.dbs[db][key]; ok {
+		e.expireAt = s.now().Add(ttl)
+	}
+}
+
+/*
+AddCacheItem stores a Drupal cache item under the given key prefix, with a
+time to live matching its expiration time, like the Drupal redis module.
+*/
+func (s *Server) AddCacheItem(db int, prefix string, item CacheItem) {
+	key := item.Key(prefix)
+	s.HSet(db, key, item.Fields())
+	if !item.Expire.IsZero() {
+		s.Expire(db, key, item.Expire.Sub(s.now()))
+	}
+}
+
+/*
+Seed stores count cache items for each bin, under the given key prefix, with
+deterministic cids and data sizes: item i of a bin has cid "<bin>:<i>" and i+1
+bytes of data.
+*/
+func (s *Server) Seed(db int, prefix string, bins map[string]int) {
+	for bin, count := range bins {
+		for i := 0; i < count; i++ {
+			s.AddCacheItem(db, prefix, CacheItem{
+				Bin:  bin,
+				CID:  bin + ":" + strconv.Itoa(i),
+				Data: strings.Repeat("x", i+1),
+				Tags: []string{bin},
+			})
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package redistest
+
+// match reports whether s matches the glob-style pattern, with the syntax of
+// the Redis SCAN MATCH and KEYS patterns:
+//
+//   - * matches any sequence of characters, / included, unlike path.Match
+//   - ? matches any single character
+//   - [abc], [^abc] and [a-z] match character classes
+//   - \ escapes the next character
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			if ok, pattern = matchClass(pattern[1:], s[0]); !ok {
+				return false
+			}
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern,
+// just after its opening bracket, and returns the pattern after the class.
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	var found bool
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			found = found || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			found = found || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+		default:
+			found = found || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // Closing bracket.
+	}
+	return found != negate, pattern
+}
@@ -0,0 +1,34 @@
+package redistest
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	checks := [...]struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"*", "", true},
+		{"*", "drupal.redis.10.1.0:page:http://example.com/node/1", true},
+		{"drupal.redis.*", "drupal.redis.10.1.0:render:a", true},
+		{"drupal.redis.*", "other:render:a", false},
+		{"site\\*:*", "site*:page:a", true},
+		{"site\\*:*", "siteX:page:a", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[abc]x", "bx", true},
+		{"[^abc]x", "bx", false},
+		{"[a-c]x", "cx", true},
+		{"[c-a]x", "bx", true},
+		{"[\\]]x", "]x", true},
+		{"a**b", "axyb", true},
+		{"a*b", "axyc", false},
+		{"abc", "ab", false},
+	}
+	for _, check := range checks {
+		t.Run(check.pattern+" "+check.s, func(t *testing.T) {
+			if actual := match(check.pattern, check.s); actual != check.expected {
+				t.Errorf("got %t, expected %t", actual, check.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// status is a RESP simple string reply, like OK.
+type status string
+
+// replyError is a RESP error reply, starting with its code, like ERR.
+type replyError string
+
+// hangup is the reply closing the connection instead.
+type hangup struct{}
+
+// errorf builds an error reply.
+func errorf(format string, args ...interface{}) replyError {
+	return replyError(fmt.Sprintf(format, args...))
+}
+
+// readCommand reads a command sent as an array of bulk strings, or as an
+// inline command, like those typed in telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid multibulk length %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		l, err := strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+		if err != nil || l < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, l+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:l])
+	}
+	return args, nil
+}
+
+// writeReply encodes a reply in RESP2.
+func writeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case replyError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Errorf("unsupported reply type %T", v))
+	}
+}
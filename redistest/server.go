@@ -0,0 +1,682 @@
+/*
+Package redistest provides an in-process Redis server, to test code using Redis
+end to end, from dialing to output, without external services.
+
+The server speaks RESP2 over TCP, optionally with TLS, or unix domain sockets,
+and implements the commands used by the scanner and a few commands to seed data:
+
+  - connection: AUTH, HELLO, CLIENT SETNAME, PING, QUIT, SELECT
+  - inspection: DBSIZE, INFO with server, stats, memory and keyspace sections,
+    MEMORY USAGE, PTTL, SCAN with MATCH, COUNT and TYPE, TYPE
+  - cluster: CLUSTER SHARDS and CLUSTER NODES, once SetCluster is called
+  - data: DEL, FLUSHDB, GET, HGETALL, HSET, PEXPIRE, SET
+
+MEMORY USAGE is an estimate, but a deterministic one, allowing exact assertions.
+*/
+package redistest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Databases is the number of logical databases, like the Redis default.
+const Databases = 16
+
+// DefaultVersion is the Redis version reported by default by HELLO and INFO.
+const DefaultVersion = "7.2.4"
+
+/*
+Server is an in-process Redis server.
+
+Its exported fields can be modified before connecting to it.
+*/
+type Server struct {
+	// Addr is the host:port address the server listens on.
+	Addr string
+	// Version is the Redis version reported by HELLO and INFO.
+	Version string
+
+	ln       net.Listener
+	tls      bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	dbs      [Databases]map[string]*entry
+	users    map[string]string   // ACL users by name, with their password.
+	disabled map[string]bool     // Commands and subcommands failing as unknown.
+	hangups  map[string]bool     // Commands closing the connection.
+	conns    map[net.Conn]string // Active connections, with their name.
+	cluster  []ClusterNode       // Nil for standalone servers.
+	nextID   int64
+	commands int64 // Commands processed, for INFO stats.
+	load     int64 // instantaneous_ops_per_sec, for INFO stats.
+	now      func() time.Time
+}
+
+/*
+NewServer starts a server listening on a random localhost port.
+
+It panics if it cannot listen, since it is meant for tests. Use Close to stop it.
+*/
+func NewServer() *Server {
+	return newServer(listen("tcp", "127.0.0.1:0"))
+}
+
+/*
+NewUnixServer starts a server listening on a unix domain socket at path, like
+servers configured with unixsocket.
+
+It panics if it cannot listen, since it is meant for tests. Use Close to stop it.
+*/
+func NewUnixServer(path string) *Server {
+	return newServer(listen("unix", path))
+}
+
+/*
+NewTLSServer starts a server accepting TLS connections on a random localhost
+port, like servers configured with tls-port. The configuration provides the
+server certificate, and the client authentication policy.
+
+It panics if it cannot listen, since it is meant for tests. Use Close to stop it.
+*/
+func NewTLSServer(config *tls.Config) *Server {
+	s := newServer(tls.NewListener(listen("tcp", "127.0.0.1:0"), config))
+	s.tls = true
+	return s
+}
+
+func listen(network, addr string) net.Listener {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		panic(fmt.Errorf("redistest: failed listening: %w", err))
+	}
+	return ln
+}
+
+func newServer(ln net.Listener) *Server {
+	s := &Server{
+		Addr:     ln.Addr().String(),
+		Version:  DefaultVersion,
+		ln:       ln,
+		users:    make(map[string]string),
+		disabled: make(map[string]bool),
+		hangups:  make(map[string]bool),
+		conns:    make(map[net.Conn]string),
+		now:      time.Now,
+	}
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]*entry)
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+/*
+DSN returns the URL to connect to the given database: a redis:// URL, a
+rediss:// one for TLS servers, or a unix:///path/to/socket?db=N one for servers
+listening on a unix socket.
+*/
+func (s *Server) DSN(db int) string {
+	switch {
+	case s.ln.Addr().Network() == "unix":
+		return fmt.Sprintf("unix://%s?db=%d", s.Addr, db)
+	case s.tls:
+		return fmt.Sprintf("rediss://%s/%d", s.Addr, db)
+	default:
+		return fmt.Sprintf("redis://%s/%d", s.Addr, db)
+	}
+}
+
+/*
+Close stops the server, closing all connections, and waits for them to end.
+*/
+func (s *Server) Close() {
+	_ = s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+/*
+RequirePass requires clients to authenticate with this password, like the
+requirepass Redis directive. It sets the password of the default user.
+*/
+func (s *Server) RequirePass(pass string) {
+	s.AddUser("default", pass)
+}
+
+/*
+AddUser adds an ACL user, requiring clients to authenticate.
+*/
+func (s *Server) AddUser(user, pass string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user] = pass
+}
+
+/*
+Disable makes a command fail as unknown, like on servers which do not support
+or which rename it, for instance MEMORY on Redis < 4 or HELLO on Redis < 6. The
+command can also be a CLUSTER subcommand, like "CLUSTER SHARDS" on Redis < 7.
+*/
+func (s *Server) Disable(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disabled[strings.ToUpper(command)] = true
+}
+
+/*
+Hangup makes the server close the connection when it receives the command,
+without replying, like on network failures or crashes.
+*/
+func (s *Server) Hangup(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hangups[strings.ToUpper(command)] = true
+}
+
+/*
+SetLoad sets the instantaneous_ops_per_sec value reported by INFO stats, to
+emulate a busy server.
+*/
+func (s *Server) SetLoad(opsPerSec int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load = opsPerSec
+}
+
+/*
+ClientNames returns the names of the active connections set with CLIENT
+SETNAME or HELLO SETNAME, unnamed connections being reported as "".
+*/
+func (s *Server) ClientNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.conns))
+	for _, name := range s.conns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = ""
+		s.nextID++
+		id := s.nextID
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(c, id)
+	}
+}
+
+// conn is the state of a client connection.
+type conn struct {
+	net.Conn
+	id            int64
+	db            int
+	authenticated bool
+	quit          bool
+}
+
+func (s *Server) handle(nc net.Conn, id int64) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		_ = nc.Close()
+	}()
+	c := &conn{Conn: nc, id: id}
+	r, w := bufio.NewReader(nc), bufio.NewWriter(nc)
+	for !c.quit {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply := s.do(c, args)
+		if _, ok := reply.(hangup); ok {
+			return
+		}
+		writeReply(w, reply)
+		// Only flush once pipelined commands have been handled.
+		if r.Buffered() == 0 || c.quit {
+			if err = w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// errWrongArgs builds the error for a command with the wrong number of arguments.
+func errWrongArgs(cmd string) replyError {
+	return errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+// errNotInteger is the error for arguments which should be integers.
+const errNotInteger = replyError("ERR value is not an integer or out of range")
+
+// errWrongType is the error for commands applied to a key of the wrong type.
+const errWrongType = replyError("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// arity maps commands to their minimum number of arguments, command included.
+var arity = map[string]int{
+	"AUTH": 2, "CLIENT": 2, "CLUSTER": 2, "DBSIZE": 1, "DEL": 2, "FLUSHDB": 1, "GET": 2,
+	"HELLO": 1, "HGETALL": 2, "HSET": 4, "INFO": 1, "MEMORY": 3, "PEXPIRE": 3,
+	"PING": 1, "PTTL": 2, "QUIT": 1, "SCAN": 2, "SELECT": 2, "SET": 3, "TYPE": 2,
+}
+
+func (s *Server) do(c *conn, args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands++
+
+	if min, ok := arity[cmd]; !ok || s.disabled[cmd] {
+		quoted := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			quoted = append(quoted, "'"+arg+"'")
+		}
+		return errorf("ERR unknown command '%s', with args beginning with: %s", args[0], strings.Join(quoted, " "))
+	} else if len(args) < min {
+		return errWrongArgs(cmd)
+	}
+	if s.hangups[cmd] {
+		return hangup{}
+	}
+	if len(s.users) > 0 && !c.authenticated && cmd != "AUTH" && cmd != "HELLO" && cmd != "QUIT" {
+		return replyError("NOAUTH Authentication required.")
+	}
+
+	db := s.dbs[c.db]
+	switch cmd {
+	case "AUTH":
+		return s.auth(c, args[1:])
+	case "HELLO":
+		return s.hello(c, args[1:])
+	case "CLUSTER":
+		return s.clusterCommand(args[1:])
+	case "CLIENT":
+		if strings.ToUpper(args[1]) != "SETNAME" || len(args) != 3 {
+			return errorf("ERR unknown subcommand '%s'.", args[1])
+		}
+		s.conns[c.Conn] = args[2]
+		return status("OK")
+	case "PING":
+		if len(args) > 1 {
+			return args[1]
+		}
+		return status("PONG")
+	case "QUIT":
+		c.quit = true
+		return status("OK")
+	case "SELECT":
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errNotInteger
+		}
+		if index < 0 || index >= Databases {
+			return replyError("ERR DB index is out of range")
+		}
+		c.db = index
+		return status("OK")
+	case "DBSIZE":
+		return int64(len(s.liveKeys(db)))
+	case "INFO":
+		return s.info(args[1:])
+	case "MEMORY":
+		if strings.ToUpper(args[1]) != "USAGE" {
+			return errorf("ERR unknown subcommand '%s'.", args[1])
+		}
+		if e := s.lookup(db, args[2]); e != nil {
+			return e.memoryUsage(args[2])
+		}
+		return nil
+	case "PTTL":
+		e := s.lookup(db, args[1])
+		switch {
+		case e == nil:
+			return int64(-2)
+		case e.expireAt.IsZero():
+			return int64(-1)
+		default:
+			return e.expireAt.Sub(s.now()).Milliseconds()
+		}
+	case "SCAN":
+		return s.scan(db, args[1:])
+	case "TYPE":
+		if e := s.lookup(db, args[1]); e != nil {
+			return status(e.typ())
+		}
+		return status("none")
+	case "DEL":
+		var n int64
+		for _, key := range args[1:] {
+			if s.lookup(db, key) != nil {
+				delete(db, key)
+				n++
+			}
+		}
+		return n
+	case "FLUSHDB":
+		s.dbs[c.db] = make(map[string]*entry)
+		return status("OK")
+	case "GET":
+		e := s.lookup(db, args[1])
+		switch {
+		case e == nil:
+			return nil
+		case e.hash != nil:
+			return errWrongType
+		default:
+			return e.str
+		}
+	case "SET":
+		db[args[1]] = &entry{str: args[2]}
+		return status("OK")
+	case "HGETALL":
+		e := s.lookup(db, args[1])
+		if e == nil {
+			return []string{}
+		}
+		if e.hash == nil {
+			return errWrongType
+		}
+		return e.fields()
+	case "HSET":
+		if len(args)%2 != 0 {
+			return errWrongArgs(cmd)
+		}
+		e := s.lookup(db, args[1])
+		if e == nil {
+			e = &entry{hash: make(map[string]string)}
+			db[args[1]] = e
+		} else if e.hash == nil {
+			return errWrongType
+		}
+		var added int64
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := e.hash[args[i]]; !ok {
+				added++
+			}
+			e.hash[args[i]] = args[i+1]
+		}
+		return added
+	case "PEXPIRE":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errNotInteger
+		}
+		e := s.lookup(db, args[1])
+		if e == nil {
+			return int64(0)
+		}
+		if ms <= 0 {
+			delete(db, args[1])
+		} else {
+			e.expireAt = s.now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return int64(1)
+	default:
+		// Only reachable if arity lists a command not handled here.
+		return errorf("ERR unknown command '%s'", args[0])
+	}
+}
+
+func (s *Server) auth(c *conn, args []string) interface{} {
+	user, pass := "default", args[0]
+	if len(args) == 2 {
+		user, pass = args[0], args[1]
+	} else if len(args) > 2 {
+		return errWrongArgs("AUTH")
+	}
+	if len(s.users) == 0 {
+		return replyError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if expected, ok := s.users[user]; !ok || expected != pass {
+		return replyError("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	c.authenticated = true
+	return status("OK")
+}
+
+func (s *Server) hello(c *conn, args []string) interface{} {
+	if len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err != nil {
+			return replyError("ERR Protocol version is not an integer or out of range")
+		} else if v != 2 {
+			// This server only speaks RESP2.
+			return replyError("NOPROTO unsupported protocol version")
+		}
+		args = args[1:]
+	}
+	var name *string
+	for len(args) > 0 {
+		switch {
+		case strings.ToUpper(args[0]) == "AUTH" && len(args) >= 3:
+			if err, ok := s.auth(c, args[1:3]).(replyError); ok {
+				return err
+			}
+			args = args[3:]
+		case strings.ToUpper(args[0]) == "SETNAME" && len(args) >= 2:
+			name = &args[1]
+			args = args[2:]
+		default:
+			return errorf("ERR Syntax error in HELLO option '%s'", args[0])
+		}
+	}
+	if len(s.users) > 0 && !c.authenticated {
+		return replyError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	if name != nil {
+		s.conns[c.Conn] = *name
+	}
+	return []interface{}{
+		"server", "redis",
+		"version", s.Version,
+		"proto", int64(2),
+		"id", c.id,
+		"mode", s.mode(),
+		"role", "master",
+		"modules", []interface{}{},
+	}
+}
+
+func (s *Server) info(args []string) interface{} {
+	section := "default"
+	if len(args) > 0 {
+		section = strings.ToLower(args[0])
+	}
+	var sections []string
+	all := section == "default" || section == "all" || section == "everything"
+	if all || section == "server" {
+		sections = append(sections, strings.Join([]string{
+			"# Server",
+			"redis_version:" + s.Version,
+			"redis_mode:" + s.mode(),
+			"tcp_port:" + s.port(),
+		}, "\r\n"))
+	}
+	if all || section == "stats" {
+		sections = append(sections, fmt.Sprintf("# Stats\r\ntotal_commands_processed:%d\r\ninstantaneous_ops_per_sec:%d", s.commands, s.load))
+	}
+	if all || section == "memory" {
+		var used int64
+		for _, db := range s.dbs {
+			for key, e := range db {
+				if !e.expired(s.now()) {
+					used += e.memoryUsage(key)
+				}
+			}
+		}
+		sections = append(sections, fmt.Sprintf("# Memory\r\nused_memory:%d", used))
+	}
+	if all || section == "keyspace" {
+		lines := []string{"# Keyspace"}
+		for i, db := range s.dbs {
+			keys := s.liveKeys(db)
+			if len(keys) == 0 {
+				continue
+			}
+			var expires int
+			for _, key := range keys {
+				if !db[key].expireAt.IsZero() {
+					expires++
+				}
+			}
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0", i, len(keys), expires))
+		}
+		sections = append(sections, strings.Join(lines, "\r\n"))
+	}
+	return strings.Join(sections, "\r\n\r\n") + "\r\n"
+}
+
+// port returns the TCP port of the server, 0 on unix sockets, like Redis.
+func (s *Server) port() string {
+	if s.ln.Addr().Network() == "unix" {
+		return "0"
+	}
+	return s.Addr[strings.LastIndex(s.Addr, ":")+1:]
+}
+
+// scan implements SCAN, the cursor being the index of the next key in name order.
+func (s *Server) scan(db map[string]*entry, args []string) interface{} {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return replyError("ERR invalid cursor")
+	}
+	pattern, count, typ := "*", 10, ""
+	for args = args[1:]; len(args) > 0; args = args[2:] {
+		if len(args) < 2 {
+			return replyError("ERR syntax error")
+		}
+		switch strings.ToUpper(args[0]) {
+		case "MATCH":
+			pattern = args[1]
+		case "COUNT":
+			if count, err = strconv.Atoi(args[1]); err != nil {
+				return errNotInteger
+			}
+			if count < 1 {
+				return replyError("ERR syntax error")
+			}
+		case "TYPE":
+			typ = strings.ToLower(args[1])
+		default:
+			return replyError("ERR syntax error")
+		}
+	}
+	keys := s.liveKeys(db)
+	if cursor > uint64(len(keys)) {
+		cursor = uint64(len(keys))
+	}
+	end := cursor + uint64(count)
+	next := end
+	if end >= uint64(len(keys)) {
+		end, next = uint64(len(keys)), 0
+	}
+	batch := []string{}
+	for _, key := range keys[cursor:end] {
+		if match(pattern, key) && (typ == "" || db[key].typ() == typ) {
+			batch = append(batch, key)
+		}
+	}
+	return []interface{}{strconv.FormatUint(next, 10), batch}
+}
+
+// lookup returns the live entry for key, removing it if it expired.
+func (s *Server) lookup(db map[string]*entry, key string) *entry {
+	e, ok := db[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(s.now()) {
+		delete(db, key)
+		return nil
+	}
+	return e
+}
+
+// liveKeys returns the sorted names of the keys which did not expire.
+func (s *Server) liveKeys(db map[string]*entry) []string {
+	keys := make([]string, 0, len(db))
+	now := s.now()
+	for key, e := range db {
+		if !e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// entry is a key value, either a string or a hash.
+type entry struct {
+	str      string
+	hash     map[string]string
+	expireAt time.Time // Zero for keys without expiration.
+}
+
+func (e *entry) typ() string {
+	if e.hash != nil {
+		return "hash"
+	}
+	return "string"
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// fields returns the hash fields and values, sorted by field name.
+func (e *entry) fields() []string {
+	names := make([]string, 0, len(e.hash))
+	for name := range e.hash {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		res = append(res, name, e.hash[name])
+	}
+	return res
+}
+
+// Memory usage estimate constants, in bytes.
+const (
+	keyOverhead   = 56 // Key object, dictionary entry and expiration.
+	fieldOverhead = 16 // Hash field and value headers.
+)
+
+// memoryUsage estimates the memory used by the entry and its key.
+func (e *entry) memoryUsage(key string) int64 {
+	size := int64(keyOverhead + len(key))
+	if e.hash == nil {
+		return size + int64(len(e.str))
+	}
+	for k, v := range e.hash {
+		size += int64(fieldOverhead + len(k) + len(v))
+	}
+	return size
+}
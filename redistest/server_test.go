@@ -0,0 +1,256 @@
+package redistest_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+)
+
+func newConn(t *testing.T, s *redistest.Server) redis.Conn {
+	c, err := redis.DialURL(s.DSN(0))
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestServerScan(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.Seed(0, redistest.DefaultPrefix, map[string]int{"render": 15, "page": 3})
+	s.Set(0, "other", "value")
+	c := newConn(t, s)
+
+	if n, err := redis.Int(c.Do("DBSIZE")); err != nil || n != 19 {
+		t.Errorf("got DBSIZE %d, %v, expected 19", n, err)
+	}
+
+	checks := [...]struct {
+		name     string
+		args     []interface{}
+		expSteps int
+		expKeys  int
+	}{
+		{"default count", []interface{}{"MATCH", "drupal.redis.*"}, 2, 18},
+		{"count 5", []interface{}{"MATCH", "drupal.redis.*", "COUNT", 5}, 4, 18},
+		{"bin", []interface{}{"MATCH", "*:page:*"}, 2, 3},
+		{"type", []interface{}{"TYPE", "string"}, 2, 1},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			var cursor, steps, keys int
+			for {
+				arr, err := redis.Values(c.Do("SCAN", append([]interface{}{cursor}, check.args...)...))
+				if err != nil {
+					t.Fatalf("failed SCAN: %v", err)
+				}
+				cursor, _ = redis.Int(arr[0], nil)
+				batch, _ := redis.Strings(arr[1], nil)
+				steps++
+				keys += len(batch)
+				if cursor == 0 {
+					break
+				}
+			}
+			if steps != check.expSteps || keys != check.expKeys {
+				t.Errorf("got %d steps and %d keys, expected %d and %d", steps, keys, check.expSteps, check.expKeys)
+			}
+		})
+	}
+}
+
+func TestServerKeys(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	item := redistest.CacheItem{Bin: "render", CID: "entity_view:node:1", Data: "<p>Hi</p>", Tags: []string{"node:1", "rendered"}}
+	s.AddCacheItem(0, redistest.DefaultPrefix, item)
+	key := item.Key(redistest.DefaultPrefix)
+	s.Set(0, "volatile", "v")
+	s.Expire(0, "volatile", time.Hour)
+	c := newConn(t, s)
+
+	fields, err := redis.StringMap(c.Do("HGETALL", key))
+	if err != nil {
+		t.Fatalf("failed HGETALL: %v", err)
+	}
+	if !reflect.DeepEqual(fields, item.Fields()) {
+		t.Errorf("got %v, expected %v", fields, item.Fields())
+	}
+
+	// The documented estimate: key overhead and name, then each field overhead,
+	// name and value.
+	size := int64(56 + len(key))
+	for k, v := range item.Fields() {
+		size += int64(16 + len(k) + len(v))
+	}
+	checks := [...]struct {
+		cmd      string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"TYPE", []interface{}{key}, "hash"},
+		{"TYPE", []interface{}{"volatile"}, "string"},
+		{"TYPE", []interface{}{"missing"}, "none"},
+		{"PTTL", []interface{}{key}, int64(-1)},
+		{"PTTL", []interface{}{"missing"}, int64(-2)},
+		{"MEMORY", []interface{}{"USAGE", key}, size},
+		{"MEMORY", []interface{}{"USAGE", "missing"}, nil},
+		{"GET", []interface{}{"volatile"}, []byte("v")},
+	}
+	for _, check := range checks {
+		t.Run(fmt.Sprint(check.cmd, check.args), func(t *testing.T) {
+			actual, err := c.Do(check.cmd, check.args...)
+			if err != nil {
+				t.Fatalf("failed %s: %v", check.cmd, err)
+			}
+			if !reflect.DeepEqual(actual, check.expected) {
+				t.Errorf("got %#v, expected %#v", actual, check.expected)
+			}
+		})
+	}
+	if ttl, _ := redis.Int64(c.Do("PTTL", "volatile")); ttl <= 0 || ttl > time.Hour.Milliseconds() {
+		t.Errorf("got PTTL %d, expected at most one hour", ttl)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.RequirePass("secret")
+	s.AddUser("drupal", "acl")
+	c := newConn(t, s)
+
+	var redisErr redis.Error
+	if _, err := c.Do("DBSIZE"); !errors.As(err, &redisErr) || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Errorf("expected NOAUTH, got %v", err)
+	}
+	if _, err := c.Do("AUTH", "wrong"); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Errorf("expected WRONGPASS, got %v", err)
+	}
+	if _, err := c.Do("HELLO", 3); err == nil || !strings.HasPrefix(err.Error(), "NOPROTO") {
+		t.Errorf("expected NOPROTO, got %v", err)
+	}
+	fields, err := redis.Values(c.Do("HELLO", 2, "AUTH", "drupal", "acl", "SETNAME", "test"))
+	if err != nil {
+		t.Fatalf("failed HELLO: %v", err)
+	}
+	if version, _ := redis.String(fields[3], nil); version != redistest.DefaultVersion {
+		t.Errorf("got version %s, expected %s", version, redistest.DefaultVersion)
+	}
+	if names := s.ClientNames(); !reflect.DeepEqual(names, []string{"test"}) {
+		t.Errorf("got client names %v", names)
+	}
+	if _, err = c.Do("DBSIZE"); err != nil {
+		t.Errorf("failed after authentication: %v", err)
+	}
+}
+
+func TestUnixServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	s := redistest.NewUnixServer(path)
+	defer s.Close()
+	if dsn := s.DSN(3); dsn != "unix://"+path+"?db=3" {
+		t.Errorf("got DSN %s, expected unix://%s?db=3", dsn, path)
+	}
+	c, err := redis.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer c.Close()
+	if info, err := redis.String(c.Do("INFO", "server")); err != nil || !strings.Contains(info, "tcp_port:0\r\n") {
+		t.Errorf("got INFO %q, %v, expected port 0", info, err)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.Version = "6.2.14"
+	s.Seed(2, "site", map[string]int{"config": 2})
+	s.Disable("memory")
+	s.SetLoad(1234)
+	c := newConn(t, s)
+
+	info, err := redis.String(c.Do("INFO"))
+	if err != nil {
+		t.Fatalf("failed INFO: %v", err)
+	}
+	for _, expected := range []string{"redis_version:6.2.14\r\n", "instantaneous_ops_per_sec:1234\r\n", "used_memory:", "db2:keys=2,expires=0,avg_ttl=0"} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("did not find %q in INFO:\n%s", expected, info)
+		}
+	}
+	if keyspace, _ := redis.String(c.Do("INFO", "keyspace")); strings.Contains(keyspace, "redis_version") {
+		t.Errorf("INFO keyspace returned other sections:\n%s", keyspace)
+	}
+	if _, err = c.Do("MEMORY", "USAGE", "site:config:config:0"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expected disabled MEMORY, got %v", err)
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+	tpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed creating certificate: %v", err)
+	}
+	s := redistest.NewTLSServer(&tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	defer s.Close()
+	if dsn := s.DSN(1); dsn != "rediss://"+s.Addr+"/1" {
+		t.Errorf("got DSN %s, expected rediss://%s/1", dsn, s.Addr)
+	}
+	c, err := redis.DialURL(s.DSN(0), redis.DialTLSSkipVerify(true))
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer c.Close()
+	if pong, err := redis.String(c.Do("PING")); err != nil || pong != "PONG" {
+		t.Errorf("got %q, %v, expected PONG", pong, err)
+	}
+}
+
+func TestServerHangup(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.Hangup("memory")
+	c := newConn(t, s)
+	var redisErr redis.Error
+	if _, err := c.Do("MEMORY", "USAGE", "key"); err == nil || errors.As(err, &redisErr) {
+		t.Errorf("got %v, expected a connection error", err)
+	}
+}
+
+func TestServerSetSized(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.SetSized(0, "key", 100)
+	c := newConn(t, s)
+	if size, err := redis.Int64(c.Do("MEMORY", "USAGE", "key")); err != nil || size != 100 {
+		t.Errorf("got size %d, %v, expected 100", size, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a size too small for the key")
+		}
+	}()
+	s.SetSized(0, "key", 10)
+}
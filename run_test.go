@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+	"github.com/fgm/drupal_redis_stats/stats"
+	"github.com/fgm/drupal_redis_stats/stats/progress"
+)
+
+// newTestServer starts a fake server seeded with Drupal cache items: in
+// database 0 with the default prefix, and in database 1 with a "site" prefix.
+func newTestServer(t *testing.T) *redistest.Server {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir()) // Ignore any user configuration.
+	s := redistest.NewServer()
+	t.Cleanup(s.Close)
+	s.Seed(0, redistest.DefaultPrefix, map[string]int{"render": 12, "page": 3, "config": 5})
+	s.Set(0, "unrelated", "value")
+	s.Seed(1, "site", map[string]int{"data": 4})
+	return s
+}
+
+// noEnv is a lookupEnv for an empty environment.
+func noEnv(string) (string, bool) { return "", false }
+
+// runJSON runs the command with JSON output, and decodes its results.
+func runJSON(t *testing.T, lookupEnv func(string) (string, bool), args ...string) (stats.CacheStats, error) {
+	var cs stats.CacheStats
+	stdout := strings.Builder{}
+	err := run(append([]string{"-q", "-json"}, args...), &stdout, &strings.Builder{}, lookupEnv)
+	if err != nil {
+		return cs, err
+	}
+	if err = json.Unmarshal([]byte(stdout.String()), &cs); err != nil {
+		t.Fatalf("failed decoding output %q: %v", stdout.String(), err)
+	}
+	return cs, nil
+}
+
+func TestRun(t *testing.T) {
+	s := newTestServer(t)
+	s.RequirePass("secret")
+	s.AddUser("drupal", "acl")
+	host := strings.TrimPrefix(s.DSN(0), "redis://")
+	env := map[string]string{"DRS_USER": "drupal", "DRS_PASS": "acl"}
+	dir := t.TempDir()
+	secretFile, aclFile := filepath.Join(dir, "secret"), filepath.Join(dir, "acl")
+	for path, pass := range map[string]string{secretFile: "secret\n", aclFile: "acl\n"} {
+		if err := os.WriteFile(path, []byte(pass), 0o600); err != nil {
+			t.Fatalf("failed writing password file: %v", err)
+		}
+	}
+
+	checks := [...]struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expKeys  map[string]uint32
+		expError string
+	}{
+		{"requirepass in DSN", []string{"-dsn", "redis://secret@" + host}, nil,
+			map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"acl flags", []string{"-dsn", s.DSN(0), "-user", "drupal", "-pass", "acl"}, nil,
+			map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"acl from environment", []string{"-dsn", s.DSN(0)}, env,
+			map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"DSN credentials over environment", []string{"-dsn", "redis://drupal:acl@" + host}, map[string]string{"DRS_USER": "other", "DRS_PASS": "wrong"},
+			map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"prefix", []string{"-dsn", "redis://secret@" + strings.TrimSuffix(host, "0") + "1", "-prefix", "site"}, nil,
+			map[string]uint32{"data": 4}, ""},
+		{"pass flag on database 1", []string{"-dsn", s.DSN(1), "-pass", "secret", "-prefix", "site"}, nil,
+			map[string]uint32{"data": 4}, ""},
+		{"pass file on database 1", []string{"-dsn", s.DSN(1), "-pass-file", secretFile, "-prefix", "site"}, nil,
+			map[string]uint32{"data": 4}, ""},
+		{"pass flag overriding DSN", []string{"-dsn", "redis://drupal:wrong@" + host, "-pass", "acl"}, nil,
+			map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"pass file overriding DSN", []string{"-dsn", "redis://drupal:wrong@" + host, "-pass-file", aclFile}, nil,
+			map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"pass file with DSN user", []string{"-dsn", "redis://drupal@" + host, "-user", "drupal", "-pass-file", aclFile}, nil,
+			map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"wrong password", []string{"-dsn", "redis://wrong@" + host}, nil, nil, "WRONGPASS"},
+		{"no password", []string{"-dsn", s.DSN(0)}, nil, nil, "NOAUTH"},
+		{"bad flag", []string{"-nope"}, nil, nil, "failed parsing flags"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			lookupEnv := func(name string) (string, bool) {
+				v, ok := check.env[name]
+				return v, ok
+			}
+			cs, err := runJSON(t, lookupEnv, check.args...)
+			if check.expError != "" {
+				if err == nil || !strings.Contains(err.Error(), check.expError) {
+					t.Fatalf("expected error containing %q, got %v", check.expError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual := make(map[string]uint32, len(cs.Stats))
+			for name, bs := range cs.Stats {
+				actual[name] = bs.Keys
+				if bs.Size <= 0 {
+					t.Errorf("got size %d for bin %s, expected a positive size", bs.Size, name)
+				}
+			}
+			if len(actual) != len(check.expKeys) {
+				t.Errorf("got bins %v, expected %v", actual, check.expKeys)
+			}
+			for name, keys := range check.expKeys {
+				if actual[name] != keys {
+					t.Errorf("got %d keys for bin %s, expected %d", actual[name], name, keys)
+				}
+			}
+		})
+	}
+}
+
+func TestRunText(t *testing.T) {
+	s := newTestServer(t)
+	stdout := strings.Builder{}
+	if err := run([]string{"-q", "-dsn", s.DSN(0), "-sort", "name"}, &stdout, &strings.Builder{}, noEnv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(stdout.String(), "\n")
+	for i, prefix := range []string{"Bin ", "------", "config ", "page ", "render ", "------", "Total "} {
+		if i >= len(lines) || !strings.HasPrefix(lines[i], prefix) {
+			t.Fatalf("line %d does not start with %q in output:\n%s", i, prefix, stdout.String())
+		}
+	}
+	if !strings.Contains(stdout.String(), "Server: Redis "+redistest.DefaultVersion+" (standalone)") {
+		t.Errorf("server not reported in output:\n%s", stdout.String())
+	}
+}
+
+func TestRunOldServer(t *testing.T) {
+	s := newTestServer(t)
+	s.Version = "3.2.12"
+	s.RequirePass("secret")
+	s.Disable("HELLO")
+	s.Disable("MEMORY")
+	cs, err := runJSON(t, noEnv, "-dsn", "redis://secret@"+strings.TrimPrefix(s.DSN(0), "redis://"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := stats.Server{Product: stats.ProductRedis, Version: "3.2.12", Mode: "standalone"}
+	if cs.Server == nil || *cs.Server != expected {
+		t.Errorf("got server %v, expected %v", cs.Server, expected)
+	}
+	if cs.Stats["render"].Keys != 12 || cs.TotalSize() != 0 {
+		t.Errorf("unexpected results without MEMORY USAGE: %+v", cs)
+	}
+}
+
+func TestRunAllDBs(t *testing.T) {
+	s := newTestServer(t)
+	s.Seed(3, redistest.DefaultPrefix, map[string]int{"render": 2})
+	cs, err := runJSON(t, noEnv, "-dsn", s.DSN(0), "-all-dbs", "-save", filepath.Join(t.TempDir(), "all.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Database 1 uses another prefix, so only databases 0 and 3 are counted.
+	if actual := cs.Stats["render"].Keys; actual != 14 {
+		t.Errorf("got %d render keys, expected 14", actual)
+	}
+}
+
+func TestRunScheme(t *testing.T) {
+	s := newTestServer(t)
+	// Drupal 7 cache tables, and a lock which is not a cache key.
+	s.Seed(2, "site", map[string]int{"cache_page": 3, "cache": 2})
+	s.Set(2, "site:lock:cron", "value")
+	checks := [...]struct {
+		name      string
+		args      []string
+		expScheme stats.Scheme
+		expKeys   map[string]uint32
+		expError  string
+	}{
+		{"auto d7", []string{"-dsn", s.DSN(2)}, stats.SchemeD7, map[string]uint32{"page": 3, "default": 2}, ""},
+		{"auto d8", []string{"-dsn", s.DSN(0)}, stats.SchemeD8, map[string]uint32{"render": 12, "page": 3, "config": 5}, ""},
+		{"d7 with prefix", []string{"-dsn", s.DSN(2), "-scheme", "d7", "-prefix", "site"}, stats.SchemeD7, map[string]uint32{"page": 3, "default": 2}, ""},
+		{"d8 on d7 keys", []string{"-dsn", s.DSN(2), "-scheme", "d8+"}, stats.SchemeD8, map[string]uint32{}, ""},
+		{"invalid", []string{"-dsn", s.DSN(2), "-scheme", "d6"}, "", nil, "invalid key scheme"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			cs, err := runJSON(t, noEnv, check.args...)
+			if check.expError != "" {
+				if err == nil || !strings.Contains(err.Error(), check.expError) {
+					t.Fatalf("expected error containing %q, got %v", check.expError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cs.Scheme != check.expScheme || len(cs.Stats) != len(check.expKeys) {
+				t.Fatalf("got %+v, expected scheme %s and keys %v", cs, check.expScheme, check.expKeys)
+			}
+			for bin, keys := range check.expKeys {
+				if actual := cs.Stats[bin].Keys; actual != keys {
+					t.Errorf("got %d %s keys, expected %d", actual, bin, keys)
+				}
+			}
+		})
+	}
+}
+
+func TestRunSnapshotDiff(t *testing.T) {
+	s := newTestServer(t)
+	snap := filepath.Join(t.TempDir(), "before.json")
+	if _, err := runJSON(t, noEnv, "-dsn", s.DSN(0), "-save", snap); err != nil {
+		t.Fatalf("failed saving: %v", err)
+	}
+	s.Seed(0, redistest.DefaultPrefix, map[string]int{"menu": 2})
+
+	stdout := strings.Builder{}
+	if err := run([]string{"-q", "-dsn", s.DSN(0), "-diff", snap}, &stdout, &strings.Builder{}, noEnv); err != nil {
+		t.Fatalf("failed comparing: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "menu") || !strings.Contains(stdout.String(), "new") {
+		t.Errorf("new bin not reported in diff:\n%s", stdout.String())
+	}
+}
+
+func TestRunDiffFormat(t *testing.T) {
+	// The DSN is unreachable: the format must be rejected before dialing.
+	err := run([]string{"-q", "-dsn", "redis://127.0.0.1:1/0", "-diff", "before.json", "-format", "markdown"},
+		&strings.Builder{}, &strings.Builder{}, noEnv)
+	if err == nil || !strings.Contains(err.Error(), "not supported for diffs") {
+		t.Errorf("got %v, expected an unsupported format error", err)
+	}
+}
+
+func TestRunInventorySad(t *testing.T) {
+	checks := [...]struct {
+		name string
+		args []string
+	}{
+		{"save", []string{"-save", "out.json"}},
+		{"diff", []string{"-diff", "in.json"}},
+		{"template", []string{"-template", "out.tmpl"}},
+		{"watch", []string{"-watch", "1s"}},
+		{"cluster", []string{"-cluster"}},
+		{"all-dbs", []string{"-all-dbs"}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			// The inventory does not exist: the flags must be rejected before loading it.
+			args := append([]string{"-q", "-inventory", "missing.yml"}, check.args...)
+			err := run(args, &strings.Builder{}, &strings.Builder{}, noEnv)
+			if err == nil || !strings.Contains(err.Error(), "cannot be used with -inventory") {
+				t.Errorf("got %v, expected an incompatible flag error", err)
+			}
+		})
+	}
+}
+
+func TestRunSectionsFormat(t *testing.T) {
+	checks := [...]struct {
+		name string
+		args []string
+	}{
+		{"all-dbs", []string{"-all-dbs"}},
+		{"cluster per-node", []string{"-cluster", "-per-node"}},
+		{"inventory", []string{"-inventory", "missing.yml"}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			// The DSN is unreachable: the format must be rejected before dialing.
+			args := append([]string{"-q", "-dsn", "redis://127.0.0.1:1/0", "-format", "html"}, check.args...)
+			err := run(args, &strings.Builder{}, &strings.Builder{}, noEnv)
+			if err == nil || !strings.Contains(err.Error(), "not supported for multi-part output") {
+				t.Errorf("got %v, expected an unsupported format error", err)
+			}
+		})
+	}
+}
+
+func TestRunPerNodeOptions(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		args     []string
+		expError string
+	}{
+		{"no cluster", []string{"-per-node"}, "can only be used with -cluster"},
+		{"watch", []string{"-cluster", "-per-node", "-watch", "1s"}, "cannot be used with -watch"},
+		{"template", []string{"-cluster", "-per-node", "-template", "report.tmpl"}, "cannot be used with -watch"},
+		{"save", []string{"-cluster", "-per-node", "-save", "after.json"}, "cannot be used with -watch"},
+		{"diff", []string{"-cluster", "-per-node", "-diff", "before.json"}, "cannot be used with -watch"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			// The DSN is unreachable: the flags must be rejected before dialing.
+			args := append([]string{"-q", "-dsn", "redis://127.0.0.1:1/0"}, check.args...)
+			err := run(args, &strings.Builder{}, &strings.Builder{}, noEnv)
+			if err == nil || !strings.Contains(err.Error(), check.expError) {
+				t.Errorf("got %v, expected an error containing %q", err, check.expError)
+			}
+		})
+	}
+}
+
+func TestRunWatchOptions(t *testing.T) {
+	checks := [...]struct {
+		name string
+		args []string
+	}{
+		{"json", []string{"-json"}},
+		{"format", []string{"-format", "markdown"}},
+		{"template", []string{"-template", "report.tmpl"}},
+		{"save", []string{"-save", "after.json"}},
+		{"diff", []string{"-diff", "before.json"}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			args := append([]string{"-q", "-dsn", "redis://127.0.0.1:1/0", "-watch", "1s"}, check.args...)
+			err := run(args, &strings.Builder{}, &strings.Builder{}, noEnv)
+			if err == nil || !strings.Contains(err.Error(), "-watch only redraws the text output") {
+				t.Errorf("got %v, expected a -watch combination error", err)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	s := newTestServer(t)
+	stdout := strings.Builder{}
+	err := run([]string{"-q", "-dsn", s.DSN(0), "-timeout", "1ns"}, &stdout, &strings.Builder{}, noEnv)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "incomplete scan") {
+		t.Fatalf("got %v, expected an incomplete scan", err)
+	}
+	// The partial results are displayed before the error.
+	if expected := "Incomplete scan: interrupted after 0 of 21 keys (0.0%)."; !strings.Contains(stdout.String(), expected) {
+		t.Errorf("did not find %q in output:\n%s", expected, stdout.String())
+	}
+
+	cs, err := runJSON(t, noEnv, "-dsn", s.DSN(0), "-timeout", "1m")
+	if err != nil || cs.Incomplete {
+		t.Errorf("got incomplete %t and error %v, expected complete results", cs.Incomplete, err)
+	}
+}
+
+func TestRunThrottle(t *testing.T) {
+	s := newTestServer(t)
+	s.SetLoad(500)
+	cs, err := runJSON(t, noEnv, "-dsn", s.DSN(0), "-rate", "10000", "-max-latency", "1s", "-busy-ops", "100", "-pause-ops", "1000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs.Incomplete || cs.Stats["render"].Keys != 12 {
+		t.Errorf("unexpected throttled results: %+v", cs)
+	}
+
+	checks := [...]struct {
+		name     string
+		args     []string
+		expError string
+	}{
+		{"negative", []string{"-dsn", s.DSN(0), "-rate", "-1"}, "cannot be negative"},
+		{"inventory", []string{"-inventory", "fleet.yml", "-rate", "10"}, "cannot be used with -inventory"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if _, err := runJSON(t, noEnv, check.args...); err == nil || !strings.Contains(err.Error(), check.expError) {
+				t.Errorf("expected error containing %q, got %v", check.expError, err)
+			}
+		})
+	}
+}
+
+func TestRunProgressJSON(t *testing.T) {
+	s := newTestServer(t)
+	checks := [...]struct {
+		name string
+		args []string
+	}{
+		{"scan", nil},
+		{"checkpoint", []string{"-checkpoint", filepath.Join(t.TempDir(), "scan.json")}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			stderr := strings.Builder{}
+			args := append([]string{"-json", "-progress", "json", "-dsn", s.DSN(0)}, check.args...)
+			if err := run(args, &strings.Builder{}, &stderr, noEnv); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var events []progress.Event
+			for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+				var e progress.Event
+				if err := json.Unmarshal([]byte(line), &e); err != nil {
+					t.Fatalf("failed decoding event %q: %v", line, err)
+				}
+				events = append(events, e)
+			}
+			if len(events) < 3 || events[0].Event != progress.EventStarted || *events[0].DBSize != 21 {
+				t.Fatalf("expected a started event with dbsize 21, got %s", stderr.String())
+			}
+			for _, e := range events[1 : len(events)-1] {
+				if e.Event != progress.EventBatch {
+					t.Errorf("expected batch events between started and finished, got %s", e.Event)
+				}
+			}
+			last := events[len(events)-1]
+			// All keys count as seen, including the one not in a Drupal cache bin.
+			if last.Event != progress.EventFinished || !*last.Complete || *last.Seen != 21 || *last.Matched != 20 || *last.Bins != 3 {
+				t.Errorf("unexpected finished event: %+v", last)
+			}
+		})
+	}
+
+	sad := [...]struct {
+		name     string
+		args     []string
+		expError string
+	}{
+		{"mode", []string{"-progress", "bar"}, "invalid -progress "},
+		{"quiet mode", []string{"-q", "-progress", "bar"}, "invalid -progress "},
+		{"stdin", []string{"-progress-fd", "0"}, "invalid -progress-fd 0"},
+		{"stdout", []string{"-progress", "json", "-progress-fd", "1"}, "invalid -progress-fd 1"},
+	}
+	for _, check := range sad {
+		t.Run(check.name, func(t *testing.T) {
+			args := append([]string{"-dsn", s.DSN(0)}, check.args...)
+			if err := run(args, &strings.Builder{}, &strings.Builder{}, noEnv); err == nil || !strings.Contains(err.Error(), check.expError) {
+				t.Errorf("expected error containing %q, got %v", check.expError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// section is a titled part of a multi-part report, like the results of a
+// cluster node or of a database. If Err is set, it is reported instead of Stats.
+type section struct {
+	Title string
+	Stats *stats.CacheStats
+	Err   error
+}
+
+// checkSectionsFormat returns an error if multi-part output cannot use the
+// format.
+func checkSectionsFormat(format output.Format) error {
+	if format == output.FormatHTML {
+		return fmt.Errorf("format %s is not supported for multi-part output", format)
+	}
+	return nil
+}
+
+// writeSections outputs each section, followed by the total, in a format
+// supporting concatenation.
+//
+// The JSON format outputs v, which is expected to hold the same information.
+func writeSections(w io.Writer, sections []section, total section, v any, format output.Format, opts output.Options) error {
+	if err := checkSectionsFormat(format); err != nil {
+		return err
+	}
+	if format == output.FormatJSON {
+		// The multi-part types cannot fail serialization.
+		j, _ := json.Marshal(v)
+		_, err := fmt.Fprintf(w, "%s\n", j)
+		return err
+	}
+	for i, s := range append(sections[:len(sections):len(sections)], total) {
+		if _, err := fmt.Fprintf(w, "%s\n\n", s.Title); err != nil {
+			return err
+		}
+		if s.Err != nil {
+			if _, err := fmt.Fprintf(w, "Error: %v\n", s.Err); err != nil {
+				return err
+			}
+		} else if err := output.Write(w, s.Stats, format, opts); err != nil {
+			return err
+		}
+		if i < len(sections) {
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
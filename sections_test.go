@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/output"
+)
+
+// errorList is an error which cannot be compared, like some wrapping errors.
+type errorList []error
+
+func (el errorList) Error() string { return errors.Join(el...).Error() }
+
+func TestWriteSections(t *testing.T) {
+	err := errorList{errors.New("timeout")}
+	sections := []section{{Title: "Total", Err: err}}
+	total := section{Title: "Total", Err: err}
+	w := strings.Builder{}
+	if err := writeSections(&w, sections, total, nil, output.FormatText, output.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Sections are separated even when one looks like the total.
+	if expected := "Total\n\nError: timeout\n\nTotal\n\nError: timeout\n"; w.String() != expected {
+		t.Errorf("got %q, expected %q", w.String(), expected)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const sentinelScheme = "redis-sentinel"
+
+// sentinelConfig describes how to discover the current master for a service.
+//
+// Its credentials are those of the sentinels, which may differ from those of
+// the data nodes.
+type sentinelConfig struct {
+	addrs      []string // host:port of each sentinel.
+	master     string   // The service name.
+	user, pass string
+	db         int
+}
+
+// isSentinelDSN reports whether the DSN designates sentinels instead of a node.
+func isSentinelDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, sentinelScheme+"://")
+}
+
+// parseSentinelDSN parses a DSN like:
+//
+//	redis-sentinel://[user:pass@]host:port[,host:port...]/service[/db]
+//
+// The credentials in the DSN are those of the sentinels.
+func parseSentinelDSN(dsn string) (sentinelConfig, error) {
+	var sc sentinelConfig
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return sc, fmt.Errorf("failed parsing sentinel DSN: %w", err)
+	}
+	if u.Scheme != sentinelScheme {
+		return sc, fmt.Errorf("invalid sentinel DSN scheme %q, expected %q", u.Scheme, sentinelScheme)
+	}
+	sc.addrs = splitSentinelAddrs(u.Host)
+	if len(sc.addrs) == 0 {
+		return sc, errors.New("no sentinel address in DSN")
+	}
+	sc.user = u.User.Username()
+	if pass, ok := u.User.Password(); ok {
+		sc.pass = pass
+	} else {
+		// Like Redis URLs, a single auth element is a password, not a user.
+		sc.user, sc.pass = "", sc.user
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(path) > 2 || path[0] == "":
+		return sc, fmt.Errorf("invalid sentinel DSN path %q, expected /service[/db]", u.Path)
+	case len(path) == 2:
+		if sc.db, err = strconv.Atoi(path[1]); err != nil {
+			return sc, fmt.Errorf("invalid database in sentinel DSN: %w", err)
+		}
+	}
+	sc.master = path[0]
+	return sc, nil
+}
+
+// splitSentinelAddrs splits a comma-separated list of sentinel addresses,
+// applying the default sentinel port when none is given.
+func splitSentinelAddrs(list string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "26379")
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// masterAddr queries the sentinels in turn until one of them returns the
+// address of the master for the service.
+func masterAddr(sc sentinelConfig, dial func(addr string) (redis.Conn, error)) (string, error) {
+	var errs []error
+	for _, addr := range sc.addrs {
+		master, err := queryMaster(sc, addr, dial)
+		if err == nil {
+			return master, nil
+		}
+		errs = append(errs, fmt.Errorf("sentinel %s: %w", addr, err))
+	}
+	return "", fmt.Errorf("failed obtaining master for %q: %w", sc.master, errors.Join(errs...))
+}
+
+func queryMaster(sc sentinelConfig, addr string, dial func(addr string) (redis.Conn, error)) (string, error) {
+	c, err := dial(addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if sc.pass != "" {
+		if err = authenticate(c, sc.user != "", sc.user, sc.pass); err != nil {
+			return "", err
+		}
+	}
+	reply, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", sc.master))
+	switch {
+	case errors.Is(err, redis.ErrNil):
+		return "", fmt.Errorf("unknown service %q", sc.master)
+	case err != nil:
+		return "", fmt.Errorf("failed SENTINEL get-master-addr-by-name: %w", err)
+	case len(reply) != 2:
+		return "", fmt.Errorf("unexpected SENTINEL reply: %v", reply)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
+
+// resolveSentinel queries the sentinels for the current master, and returns a
+// DSN for it, without credentials: data node credentials are only obtained
+// from the -user and -pass flags.
+func resolveSentinel(sc sentinelConfig, dial func(addr string) (redis.Conn, error)) (string, error) {
+	master, err := masterAddr(sc, dial)
+	if err != nil {
+		return "", err
+	}
+	u := url.URL{Scheme: "redis", Host: master, Path: "/" + strconv.Itoa(sc.db)}
+	return u.String(), nil
+}
+
+// dialSentinel is the default dialer for sentinels.
+func dialSentinel(addr string) (redis.Conn, error) {
+	return redis.Dial("tcp", addr)
+}
+
+// getSentinelConfig builds the sentinel configuration from either a sentinel
+// DSN or the -sentinel flags, the flags overriding the DSN. When using the
+// flags with a normal DSN, only the database is used from that DSN.
+//
+// It returns false if sentinels are not used.
+func getSentinelConfig(fs *flag.FlagSet, dsn, addrs, master, user, pass string) (sentinelConfig, bool, error) {
+	var sc sentinelConfig
+	var err error
+
+	switch {
+	case isSentinelDSN(dsn):
+		if sc, err = parseSentinelDSN(dsn); err != nil {
+			return sc, true, err
+		}
+	case addrs != "":
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return sc, true, fmt.Errorf("failed parsing Redis DSN: %w", err)
+		}
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			if sc.db, err = strconv.Atoi(db); err != nil {
+				return sc, true, fmt.Errorf("invalid database in DSN: %w", err)
+			}
+		}
+	default:
+		return sc, false, nil
+	}
+
+	if isFlagPassed(fs, "sentinel") {
+		sc.addrs = splitSentinelAddrs(addrs)
+	}
+	if isFlagPassed(fs, "sentinel-master") {
+		sc.master = master
+	}
+	if isFlagPassed(fs, "sentinel-user") {
+		sc.user = user
+	}
+	if isFlagPassed(fs, "sentinel-pass") {
+		sc.pass = pass
+	}
+	if len(sc.addrs) == 0 {
+		return sc, true, errors.New("no sentinel address")
+	}
+	if sc.master == "" {
+		return sc, true, errors.New("no sentinel service name: use -sentinel-master")
+	}
+	return sc, true, nil
+}
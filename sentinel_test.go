@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// sentinelMockConn answers SENTINEL get-master-addr-by-name for a single
+// service, optionally requiring authentication.
+type sentinelMockConn struct {
+	MockConn
+	master        string
+	authenticated bool
+}
+
+func (smc *sentinelMockConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	switch commandName {
+	case "AUTH":
+		_, err := smc.MockConn.Do(commandName, args...)
+		smc.authenticated = err == nil
+		return "OK", err
+	case "SENTINEL":
+		if smc.Pass != "" && !smc.authenticated {
+			return nil, errors.New("NOAUTH Authentication required")
+		}
+		if args[1] != smc.master {
+			return nil, nil
+		}
+		return []interface{}{[]byte("10.0.0.1"), []byte("6380")}, nil
+	default:
+		return nil, errors.New("bad command")
+	}
+}
+
+func TestParseSentinelDSN(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		dsn      string
+		expected sentinelConfig
+		expError bool
+	}{
+		{"minimal", "redis-sentinel://s1/mymaster", sentinelConfig{addrs: []string{"s1:26379"}, master: "mymaster"}, false},
+		{"full", "redis-sentinel://u:p@s1:1,s2:2/mymaster/3",
+			sentinelConfig{addrs: []string{"s1:1", "s2:2"}, master: "mymaster", user: "u", pass: "p", db: 3}, false},
+		{"only pass", "redis-sentinel://p@s1/mymaster", sentinelConfig{addrs: []string{"s1:26379"}, master: "mymaster", pass: "p"}, false},
+		{"bad scheme", "redis://s1/mymaster", sentinelConfig{}, true},
+		{"no service", "redis-sentinel://s1/", sentinelConfig{}, true},
+		{"bad db", "redis-sentinel://s1/mymaster/x", sentinelConfig{}, true},
+		{"too deep", "redis-sentinel://s1/mymaster/1/2", sentinelConfig{}, true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := parseSentinelDSN(check.dsn)
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, check.expected) {
+				t.Errorf("got %+v, expected %+v", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestGetSentinelConfig(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		args     []string
+		expected sentinelConfig
+		expUsed  bool
+		expError bool
+	}{
+		{"none", []string{"-dsn", "redis://localhost/2"}, sentinelConfig{}, false, false},
+		{"flags", []string{"-dsn", "redis://localhost/2", "-sentinel", "s1,s2:2", "-sentinel-master", "m"},
+			sentinelConfig{addrs: []string{"s1:26379", "s2:2"}, master: "m", db: 2}, true, false},
+		{"dsn with overrides", []string{"-dsn", "redis-sentinel://u:p@s1/m", "-sentinel-user", "u2", "-sentinel-pass", "p2"},
+			sentinelConfig{addrs: []string{"s1:26379"}, master: "m", user: "u2", pass: "p2"}, true, false},
+		{"flags without master", []string{"-sentinel", "s1"}, sentinelConfig{}, true, true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			dsn := fs.String("dsn", "", "")
+			addrs := fs.String("sentinel", "", "")
+			master := fs.String("sentinel-master", "", "")
+			user := fs.String("sentinel-user", "", "")
+			pass := fs.String("sentinel-pass", "", "")
+			if err := fs.Parse(check.args); err != nil {
+				t.Fatalf("failed parsing: %v", err)
+			}
+			actual, used, err := getSentinelConfig(fs, *dsn, *addrs, *master, *user, *pass)
+			if used != check.expUsed {
+				t.Errorf("got used %t, expected %t", used, check.expUsed)
+			}
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, check.expected) {
+				t.Errorf("got %+v, expected %+v", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestResolveSentinel(t *testing.T) {
+	errDown := errors.New("connection refused")
+	dial := func(addr string) (redis.Conn, error) {
+		switch addr {
+		case "down:26379":
+			return nil, errDown
+		case "acl:26379":
+			return &sentinelMockConn{MockConn: MockConn{User: "su", Pass: "sp"}, master: "m"}, nil
+		default:
+			return &sentinelMockConn{master: "m"}, nil
+		}
+	}
+	checks := [...]struct {
+		name     string
+		sc       sentinelConfig
+		expected string
+		expError bool
+	}{
+		{"first up", sentinelConfig{addrs: []string{"up:26379"}, master: "m", db: 1}, "redis://10.0.0.1:6380/1", false},
+		{"failover", sentinelConfig{addrs: []string{"down:26379", "up:26379"}, master: "m"}, "redis://10.0.0.1:6380/0", false},
+		{"sentinel auth", sentinelConfig{addrs: []string{"acl:26379"}, master: "m", user: "su", pass: "sp"}, "redis://10.0.0.1:6380/0", false},
+		{"sentinel bad auth", sentinelConfig{addrs: []string{"acl:26379"}, master: "m", user: "su", pass: "bad"}, "", true},
+		{"sentinel no auth", sentinelConfig{addrs: []string{"acl:26379"}, master: "m"}, "", true},
+		{"unknown service", sentinelConfig{addrs: []string{"up:26379"}, master: "other"}, "", true},
+		{"all down", sentinelConfig{addrs: []string{"down:26379"}, master: "m"}, "", true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := resolveSentinel(check.sc, dial)
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != check.expected {
+				t.Errorf("got %s, expected %s", actual, check.expected)
+			}
+		})
+	}
+}
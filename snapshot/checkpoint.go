@@ -0,0 +1,79 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+/*
+Checkpoint is the state of a scan in progress, saved periodically to resume the
+scan from its SCAN cursor, held in Stats.Cursor, if it is interrupted.
+*/
+type Checkpoint struct {
+	Time time.Time
+	// Target identifies the scanned database, like a DSN without credentials, to
+	// avoid resuming a scan on another one.
+	Target string
+	Stats  stats.CacheStats
+}
+
+/*
+NewCheckpoint creates a checkpoint of the given partial results, timestamped at
+the current time.
+*/
+func NewCheckpoint(target string, cs stats.CacheStats) Checkpoint {
+	cs.Incomplete = true
+	return Checkpoint{Time: time.Now().UTC(), Target: target, Stats: cs}
+}
+
+/*
+Save writes the checkpoint to a file in JSON format.
+
+It writes to a temporary file renamed over the previous checkpoint, so the file
+always holds a complete checkpoint, even if the command is killed while saving.
+*/
+func (cp Checkpoint) Save(path string) error {
+	j, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding checkpoint: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed creating checkpoint: %w", err)
+	}
+	defer os.Remove(f.Name()) // No-op after the rename.
+	if _, err = f.Write(append(j, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed writing checkpoint: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed writing checkpoint: %w", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+/*
+LoadCheckpoint reads a checkpoint from a file written by Checkpoint.Save.
+*/
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	var cp Checkpoint
+	j, err := os.ReadFile(path)
+	if err != nil {
+		return cp, fmt.Errorf("failed reading checkpoint: %w", err)
+	}
+	if err = json.Unmarshal(j, &cp); err != nil {
+		return cp, fmt.Errorf("failed decoding checkpoint %s: %w", path, err)
+	}
+	if cp.Stats.Stats == nil {
+		cp.Stats.Stats = map[string]stats.BinStats{}
+	}
+	return cp, nil
+}
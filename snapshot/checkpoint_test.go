@@ -0,0 +1,54 @@
+package snapshot_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/snapshot"
+)
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "scan.checkpoint")
+	partial := oldStats
+	partial.Cursor = 1234
+	for _, cs := range []int{0, 1} { // Saving again replaces the checkpoint.
+		partial.TotalKeys += uint32(cs)
+		if err := snapshot.NewCheckpoint("redis://localhost:6379/0", partial).Save(name); err != nil {
+			t.Fatalf("failed saving: %v", err)
+		}
+	}
+	actual, err := snapshot.LoadCheckpoint(name)
+	if err != nil {
+		t.Fatalf("failed loading: %v", err)
+	}
+	expected := partial
+	expected.Incomplete = true
+	if actual.Target != "redis://localhost:6379/0" || actual.Time.IsZero() {
+		t.Errorf("got target %q and time %v", actual.Target, actual.Time)
+	}
+	if !reflect.DeepEqual(actual.Stats, expected) {
+		t.Errorf("got stats %v, expected %v", actual.Stats, expected)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files, expected only the checkpoint", len(entries))
+	}
+}
+
+func TestLoadCheckpointSad(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.checkpoint")
+	if err := os.WriteFile(invalid, []byte("{"), 0o644); err != nil {
+		t.Fatalf("failed writing: %v", err)
+	}
+	for _, name := range []string{filepath.Join(dir, "missing.checkpoint"), invalid} {
+		if _, err := snapshot.LoadCheckpoint(name); err == nil {
+			t.Errorf("unexpected success loading %s", name)
+		}
+	}
+	if err := snapshot.NewCheckpoint("", oldStats).Save(filepath.Join(dir, "missing", "scan.checkpoint")); err == nil {
+		t.Error("unexpected success saving to a missing directory")
+	}
+}
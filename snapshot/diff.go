@@ -0,0 +1,97 @@
+package snapshot
+
+import (
+	"sort"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+/*
+Status describes how a bin evolved between two snapshots.
+*/
+type Status string
+
+// Possible bin evolutions.
+const (
+	Added     Status = "added"
+	Removed   Status = "removed"
+	Changed   Status = "changed"
+	Unchanged Status = "unchanged"
+)
+
+/*
+BinDiff holds the evolution of a single bin between two snapshots.
+
+The percent changes are 0 when the old value is 0, as for added bins.
+*/
+type BinDiff struct {
+	Name       string
+	Status     Status
+	Old, New   stats.BinStats
+	KeysDelta  int64
+	SizeDelta  int64
+	KeysChange float64
+	SizeChange float64
+}
+
+/*
+Diff holds the evolution of all bins between two snapshots.
+*/
+type Diff struct {
+	OldTime, NewTime time.Time
+	Bins             []BinDiff // Ordered by name.
+	Total            BinDiff   // Total keys per DBSIZE, and total size.
+}
+
+func newBinDiff(name string, from, to stats.BinStats) BinDiff {
+	bd := BinDiff{
+		Name:      name,
+		Old:       from,
+		New:       to,
+		KeysDelta: int64(to.Keys) - int64(from.Keys),
+		SizeDelta: to.Size - from.Size,
+	}
+	if from.Keys != 0 {
+		bd.KeysChange = 100 * float64(bd.KeysDelta) / float64(from.Keys)
+	}
+	if from.Size != 0 {
+		bd.SizeChange = 100 * float64(bd.SizeDelta) / float64(from.Size)
+	}
+	if bd.KeysDelta == 0 && bd.SizeDelta == 0 {
+		bd.Status = Unchanged
+	} else {
+		bd.Status = Changed
+	}
+	return bd
+}
+
+/*
+Compare computes the per-bin evolution from one snapshot to another.
+*/
+func Compare(from, to Snapshot) Diff {
+	d := Diff{OldTime: from.Time, NewTime: to.Time}
+	for name, oldBin := range from.Stats.Stats {
+		newBin, ok := to.Stats.Stats[name]
+		bd := newBinDiff(name, oldBin, newBin)
+		if !ok {
+			bd.Status = Removed
+		}
+		d.Bins = append(d.Bins, bd)
+	}
+	for name, newBin := range to.Stats.Stats {
+		if _, ok := from.Stats.Stats[name]; ok {
+			continue
+		}
+		bd := newBinDiff(name, stats.BinStats{}, newBin)
+		bd.Status = Added
+		d.Bins = append(d.Bins, bd)
+	}
+	sort.Slice(d.Bins, func(i, j int) bool { return d.Bins[i].Name < d.Bins[j].Name })
+
+	d.Total = newBinDiff("",
+		stats.BinStats{Keys: from.Stats.TotalKeys, Size: from.Stats.TotalSize()},
+		stats.BinStats{Keys: to.Stats.TotalKeys, Size: to.Stats.TotalSize()},
+	)
+	return d
+}
@@ -0,0 +1,60 @@
+/*
+Package snapshot persists scan results and compares them.
+*/
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+/*
+Snapshot is a timestamped scan result, as saved to a file.
+*/
+type Snapshot struct {
+	Time  time.Time
+	Stats stats.CacheStats
+}
+
+/*
+New creates a snapshot of the given stats, timestamped at the current time.
+*/
+func New(cs stats.CacheStats) Snapshot {
+	return Snapshot{Time: time.Now().UTC(), Stats: cs}
+}
+
+/*
+Save writes the snapshot to a file in JSON format, replacing any existing file.
+*/
+func (s Snapshot) Save(path string) error {
+	j, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding snapshot: %w", err)
+	}
+	if err = os.WriteFile(path, append(j, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed writing snapshot: %w", err)
+	}
+	return nil
+}
+
+/*
+Load reads a snapshot from a file written by Save.
+*/
+func Load(path string) (Snapshot, error) {
+	var s Snapshot
+	j, err := os.ReadFile(path)
+	if err != nil {
+		return s, fmt.Errorf("failed reading snapshot: %w", err)
+	}
+	if err = json.Unmarshal(j, &s); err != nil {
+		return s, fmt.Errorf("failed decoding snapshot %s: %w", path, err)
+	}
+	if s.Stats.Stats == nil {
+		s.Stats.Stats = map[string]stats.BinStats{}
+	}
+	return s, nil
+}
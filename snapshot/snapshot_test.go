@@ -0,0 +1,73 @@
+package snapshot_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/snapshot"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+var (
+	oldStats = stats.CacheStats{
+		TotalKeys: 30,
+		Stats: map[string]stats.BinStats{
+			"config": {Keys: 10, Size: 1000},
+			"render": {Keys: 15, Size: 4000},
+			"menu":   {Keys: 5, Size: 500},
+		},
+	}
+	newStats = stats.CacheStats{
+		TotalKeys: 33,
+		Stats: map[string]stats.BinStats{
+			"config": {Keys: 10, Size: 1000},
+			"render": {Keys: 20, Size: 2000},
+			"page":   {Keys: 3, Size: 300},
+		},
+	}
+)
+
+func TestSaveLoad(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "snapshot.json")
+	expected := snapshot.New(oldStats)
+	if err := expected.Save(name); err != nil {
+		t.Fatalf("failed saving: %v", err)
+	}
+	actual, err := snapshot.Load(name)
+	if err != nil {
+		t.Fatalf("failed loading: %v", err)
+	}
+	if !actual.Time.Equal(expected.Time) {
+		t.Errorf("got time %v, expected %v", actual.Time, expected.Time)
+	}
+	if !reflect.DeepEqual(actual.Stats, expected.Stats) {
+		t.Errorf("got stats %v, expected %v", actual.Stats, expected.Stats)
+	}
+}
+
+func TestLoadSad(t *testing.T) {
+	if _, err := snapshot.Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("unexpected success loading a missing file")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	from := snapshot.Snapshot{Time: time.Unix(0, 0), Stats: oldStats}
+	to := snapshot.Snapshot{Time: time.Unix(3600, 0), Stats: newStats}
+	d := snapshot.Compare(from, to)
+
+	expected := []snapshot.BinDiff{
+		{Name: "config", Status: snapshot.Unchanged, Old: stats.BinStats{Keys: 10, Size: 1000}, New: stats.BinStats{Keys: 10, Size: 1000}},
+		{Name: "menu", Status: snapshot.Removed, Old: stats.BinStats{Keys: 5, Size: 500}, KeysDelta: -5, SizeDelta: -500, KeysChange: -100, SizeChange: -100},
+		{Name: "page", Status: snapshot.Added, New: stats.BinStats{Keys: 3, Size: 300}, KeysDelta: 3, SizeDelta: 300},
+		{Name: "render", Status: snapshot.Changed, Old: stats.BinStats{Keys: 15, Size: 4000}, New: stats.BinStats{Keys: 20, Size: 2000}, KeysDelta: 5, SizeDelta: -2000, KeysChange: 100.0 / 3, SizeChange: -50},
+	}
+	if !reflect.DeepEqual(d.Bins, expected) {
+		t.Errorf("got\n%+v\nexpected\n%+v", d.Bins, expected)
+	}
+	if d.Total.KeysDelta != 3 || d.Total.SizeDelta != -2200 {
+		t.Errorf("got total deltas %d/%d, expected 3/-2200", d.Total.KeysDelta, d.Total.SizeDelta)
+	}
+}
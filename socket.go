@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const socketScheme = "unix"
+
+// dial connects to the server designated by the DSN: either a redis:// or
+// rediss:// URL supported by redigo, or a unix:///path/to/socket?db=N URL.
+//
+// It neither authenticates nor selects the DSN database, since servers
+// requiring authentication reject SELECT until then: it returns that database,
+// for setup to select it once authenticated.
+func dial(dsn string, options ...redis.DialOption) (redis.Conn, int, error) {
+	db, err := dsnDatabase(dsn)
+	if err != nil {
+		return nil, 0, err
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed parsing Redis DSN: %w", err)
+	}
+	if u.Scheme != socketScheme {
+		u.User, u.Path, u.RawPath = nil, "", ""
+		c, err := redis.DialURL(u.String(), options...)
+		return c, db, err
+	}
+	if u.Path == "" {
+		return nil, 0, errors.New("no socket path in DSN")
+	}
+	c, err := redis.Dial("unix", u.Path, options...)
+	return c, db, err
+}
+
+// socketDSN builds a unix:// DSN for the socket, keeping the database and
+// credentials of the given redis:// DSN.
+func socketDSN(dsn, socket string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing Redis DSN: %w", err)
+	}
+	su := url.URL{Scheme: socketScheme, User: u.User, Path: socket}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err = strconv.Atoi(db); err != nil {
+			return "", fmt.Errorf("invalid database in DSN: %w", err)
+		}
+		su.RawQuery = url.Values{"db": {db}}.Encode()
+	}
+	return su.String(), nil
+}
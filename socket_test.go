@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+)
+
+func TestSocketDSN(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		dsn      string
+		expected string
+		expError bool
+	}{
+		{"default", "redis://localhost:6379/0", "unix:///run/redis.sock?db=0", false},
+		{"no db", "redis://localhost", "unix:///run/redis.sock", false},
+		{"credentials", "redis://u:p@localhost/3", "unix://u:p@/run/redis.sock?db=3", false},
+		{"bad db", "redis://localhost/x", "", true},
+		{"bad dsn", ":localhost", "", true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := socketDSN(check.dsn, "/run/redis.sock")
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != check.expected {
+				t.Errorf("got %s, expected %s", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestOpenSocket(t *testing.T) {
+	s := redistest.NewUnixServer(filepath.Join(t.TempDir(), "redis.sock"))
+	defer s.Close()
+	s.RequirePass("secret")
+	s.AddUser("drupal", "acl")
+	s.Set(2, "key", "value")
+	socket := strings.TrimPrefix(strings.TrimSuffix(s.DSN(2), "?db=2"), "unix://")
+
+	checks := [...]struct {
+		name, dsn string
+		old       bool
+	}{
+		{"acl", "unix://drupal:acl@" + socket + "?db=2", false},
+		{"requirepass", "unix://secret@" + socket + "?db=2", false},
+		{"old requirepass", "unix://secret@" + socket + "?db=2", true}, // Last, since HELLO stays disabled.
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if check.old {
+				s.Disable("HELLO")
+			}
+			// Like main, obtain credentials from the DSN.
+			user, pass, err := getCredentials(newCredentialsFlagSet(t, "-dsn", check.dsn), io.Discard, check.dsn, "", "", nil)
+			if err != nil {
+				t.Fatalf("failed obtaining credentials: %v", err)
+			}
+			c, _, err := open(check.dsn, user, pass)
+			if err != nil {
+				t.Fatalf("failed opening: %v", err)
+			}
+			defer c.Close()
+			// The database is only selected once authenticated.
+			if n, err := redis.Int(c.Do("DBSIZE")); err != nil || n != 1 {
+				t.Errorf("got %d keys, %v, expected database 2 with 1 key", n, err)
+			}
+		})
+	}
+}
+
+func TestRunSocket(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir()) // Ignore any user configuration.
+	socket := filepath.Join(t.TempDir(), "redis.sock")
+	s := redistest.NewUnixServer(socket)
+	defer s.Close()
+	s.RequirePass("secret")
+	s.Seed(1, "site", map[string]int{"data": 4})
+	cs, err := runJSON(t, noEnv, "-socket", socket, "-dsn", "redis://secret@localhost/1", "-prefix", "site")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs.Stats["data"].Keys != 4 {
+		t.Errorf("got %+v, expected 4 data keys", cs.Stats)
+	}
+}
+
+func TestDialSocketSad(t *testing.T) {
+	for _, dsn := range []string{"unix://", "unix:///run/redis.sock?db=x", "unix://%zz/"} {
+		if _, _, err := dial(dsn); err == nil {
+			t.Errorf("unexpected success dialing %s", dsn)
+		}
+	}
+}
+
+// newCredentialsFlagSet returns a parsed flag set with the flags used by
+// getCredentials and open.
+func newCredentialsFlagSet(t *testing.T, args ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("user", "", "")
+	fs.String("pass", "", "")
+	fs.String("dsn", "", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("failed parsing: %v", err)
+	}
+	return fs
+}
@@ -0,0 +1,117 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Bin is a named BinStats, allowing bins to be handled in a stable order.
+*/
+type Bin struct {
+	Name string
+	BinStats
+}
+
+/*
+SortKey designates the column used to order bins.
+*/
+type SortKey string
+
+// Supported sort keys.
+const (
+	SortByName SortKey = "name"
+	SortByKeys SortKey = "keys"
+	SortBySize SortKey = "size"
+)
+
+/*
+ParseSortKey validates a sort key from its string representation.
+*/
+func ParseSortKey(s string) (SortKey, error) {
+	switch k := SortKey(s); k {
+	case SortByName, SortByKeys, SortBySize:
+		return k, nil
+	default:
+		return "", fmt.Errorf("unknown sort key %q: use one of %s, %s, %s",
+			s, SortByName, SortByKeys, SortBySize)
+	}
+}
+
+/*
+ParseSortSpec parses a sort specification like "size" or "-size", the "-"
+prefix meaning descending order.
+*/
+func ParseSortSpec(spec string) (by SortKey, desc bool, err error) {
+	desc = strings.HasPrefix(spec, "-")
+	by, err = ParseSortKey(strings.TrimPrefix(spec, "-"))
+	return by, desc, err
+}
+
+/*
+Bins returns the bins in cs, ordered by the given key.
+
+Ties on keys or size are broken by name, so the order is always deterministic.
+The OtherBin entry, if any, always comes last.
+*/
+func (cs CacheStats) Bins(by SortKey, desc bool) []Bin {
+	bins := make([]Bin, 0, len(cs.Stats))
+	for name, bs := range cs.Stats {
+		bins = append(bins, Bin{Name: name, BinStats: bs})
+	}
+	less := func(i, j int) bool {
+		a, b := bins[i], bins[j]
+		if a.Name == OtherBin || b.Name == OtherBin {
+			return b.Name == OtherBin && a.Name != OtherBin
+		}
+		switch by {
+		case SortByKeys:
+			if a.Keys != b.Keys {
+				return (a.Keys < b.Keys) != desc
+			}
+		case SortBySize:
+			if a.Size != b.Size {
+				return (a.Size < b.Size) != desc
+			}
+		default:
+			return (a.Name < b.Name) != desc
+		}
+		return a.Name < b.Name
+	}
+	sort.Slice(bins, less)
+	return bins
+}
+
+/*
+OtherBin is the name of the bin collecting the bins dropped by Top.
+
+Its parentheses prevent collisions with actual bin names, which are words.
+*/
+const OtherBin = "(other)"
+
+/*
+Top returns a copy of cs limited to the n largest bins by the given key, the
+other bins being collapsed into a single OtherBin entry.
+
+Since largest names make little sense, any key other than SortByKeys selects
+bins by size. If n is 0 or cs has no more than n bins, the copy holds all bins.
+*/
+func (cs CacheStats) Top(n int, by SortKey) CacheStats {
+	res := cs
+	res.Stats = make(map[string]BinStats, len(cs.Stats))
+	if by != SortByKeys {
+		by = SortBySize
+	}
+	for i, bin := range cs.Bins(by, true) {
+		if n <= 0 || i < n || len(cs.Stats) <= n {
+			res.Stats[bin.Name] = bin.BinStats
+			continue
+		}
+		other := res.Stats[OtherBin]
+		other.Keys += bin.Keys
+		other.Size += bin.Size
+		res.Stats[OtherBin] = other
+	}
+	return res
+}
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCacheStats_Top(t *testing.T) {
+	cs := CacheStats{
+		TotalKeys: 10,
+		Stats: map[string]BinStats{
+			"a": {Keys: 1, Size: 400},
+			"b": {Keys: 2, Size: 300},
+			"c": {Keys: 3, Size: 200},
+			"d": {Keys: 4, Size: 100},
+		},
+	}
+	checks := [...]struct {
+		name     string
+		n        int
+		by       SortKey
+		expected map[string]BinStats
+	}{
+		{"no limit", 0, SortBySize, cs.Stats},
+		{"large limit", 4, SortBySize, cs.Stats},
+		{"by size", 2, SortBySize, map[string]BinStats{
+			"a": {1, 400}, "b": {2, 300}, OtherBin: {7, 300},
+		}},
+		{"by name means by size", 2, SortByName, map[string]BinStats{
+			"a": {1, 400}, "b": {2, 300}, OtherBin: {7, 300},
+		}},
+		{"by keys", 1, SortByKeys, map[string]BinStats{
+			"d": {4, 100}, OtherBin: {6, 900},
+		}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual := cs.Top(check.n, check.by)
+			if !reflect.DeepEqual(actual.Stats, check.expected) {
+				t.Errorf("got %v, expected %v", actual.Stats, check.expected)
+			}
+			if actual.TotalKeys != cs.TotalKeys || actual.TotalSize() != cs.TotalSize() {
+				t.Errorf("totals changed")
+			}
+		})
+	}
+}
+
+func TestCacheStats_Bins(t *testing.T) {
+	cs := CacheStats{Stats: map[string]BinStats{
+		OtherBin: {Keys: 9, Size: 9},
+		"b":      {Keys: 1, Size: 5},
+		"a":      {Keys: 1, Size: 7},
+	}}
+	checks := [...]struct {
+		spec     string
+		expected []string
+	}{
+		{"name", []string{"a", "b", OtherBin}},
+		{"-name", []string{"b", "a", OtherBin}},
+		{"keys", []string{"a", "b", OtherBin}},
+		{"-keys", []string{"a", "b", OtherBin}},
+		{"size", []string{"b", "a", OtherBin}},
+		{"-size", []string{"a", "b", OtherBin}},
+	}
+	for _, check := range checks {
+		t.Run(check.spec, func(t *testing.T) {
+			by, desc, err := ParseSortSpec(check.spec)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual []string
+			for _, bin := range cs.Bins(by, desc) {
+				actual = append(actual, bin.Name)
+			}
+			if !reflect.DeepEqual(actual, check.expected) {
+				t.Errorf("got %v, expected %v", actual, check.expected)
+			}
+		})
+	}
+	if _, _, err := ParseSortSpec("-color"); err == nil {
+		t.Error("unexpected success parsing unknown sort key")
+	}
+}
@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+Client is the set of Redis operations used by scans.
+
+It allows embedding the scanner with any Redis client library: this package
+provides an adapter for redigo, the stats/goredis package one for go-redis,
+and the stats/statstest package an in-memory fake for unit tests.
+*/
+type Client interface {
+	// DBSize returns the number of keys in the current database.
+	DBSize(ctx context.Context) (uint64, error)
+	// Info returns the given section of the INFO command.
+	Info(ctx context.Context, section string) (string, error)
+	// Select makes db the database of the following commands.
+	Select(ctx context.Context, db int) error
+	// Scan runs a single SCAN step from cursor, returning the next cursor, which
+	// is 0 at the end of the iteration, and the keys matching the pattern.
+	// A count of 0 uses the server default.
+	Scan(ctx context.Context, cursor uint64, match string, count int64) (next uint64, keys []string, err error)
+	// MemoryUsage returns the MEMORY USAGE of each key, in a single pipelined
+	// batch. Keys which disappeared since they were scanned use 0 bytes.
+	MemoryUsage(ctx context.Context, keys []string) ([]int64, error)
+}
+
+/*
+IsServerError reports whether err is an error reply from the server, like an
+unknown command, as opposed to a connection error.
+
+It supports the errors of redigo, and those of go-redis, which implement a
+RedisError method.
+*/
+func IsServerError(err error) bool {
+	var redigoErr redis.Error
+	var goRedisErr interface{ RedisError() }
+	return errors.As(err, &redigoErr) || errors.As(err, &goRedisErr)
+}
+
+/*
+RedigoClient is the Client adapter for redigo connections.
+*/
+type RedigoClient struct {
+	conn redis.Conn
+}
+
+/*
+NewRedigoClient wraps a redigo connection as a Client.
+
+If the connection supports contexts, like those returned by redis.Dial, the
+contexts passed to the Client methods bound the commands.
+*/
+func NewRedigoClient(c redis.Conn) *RedigoClient {
+	return &RedigoClient{conn: c}
+}
+
+// do runs a command, with ctx if the connection supports it.
+func (rc *RedigoClient) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	if cc, ok := rc.conn.(redis.ConnWithContext); ok {
+		return cc.DoContext(ctx, cmd, args...)
+	}
+	return rc.conn.Do(cmd, args...)
+}
+
+// receive reads a pipelined reply, with ctx if the connection supports it.
+func (rc *RedigoClient) receive(ctx context.Context) (interface{}, error) {
+	if cc, ok := rc.conn.(redis.ConnWithContext); ok {
+		return cc.ReceiveContext(ctx)
+	}
+	return rc.conn.Receive()
+}
+
+/*
+DBSize implements Client.
+*/
+func (rc *RedigoClient) DBSize(ctx context.Context) (uint64, error) {
+	return redis.Uint64(rc.do(ctx, "DBSIZE"))
+}
+
+/*
+Info implements Client.
+*/
+func (rc *RedigoClient) Info(ctx context.Context, section string) (string, error) {
+	return redis.String(rc.do(ctx, "INFO", section))
+}
+
+/*
+Select implements Client.
+*/
+func (rc *RedigoClient) Select(ctx context.Context, db int) error {
+	_, err := rc.do(ctx, "SELECT", db)
+	return err
+}
+
+/*
+Close closes the wrapped connection.
+*/
+func (rc *RedigoClient) Close() error {
+	return rc.conn.Close()
+}
+
+/*
+Scan implements Client.
+*/
+func (rc *RedigoClient) Scan(ctx context.Context, cursor uint64, match string, count int64) (uint64, []string, error) {
+	args := []interface{}{cursor, "MATCH", match}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	arr, err := redis.Values(rc.do(ctx, "SCAN", args...))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(arr) != 2 {
+		return 0, nil, fmt.Errorf("unexpected SCAN reply with %d elements", len(arr))
+	}
+	next, err := redis.Uint64(arr[0], nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unexpected SCAN cursor: %w", err)
+	}
+	keys, err := redis.Strings(arr[1], nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unexpected SCAN keys: %w", err)
+	}
+	return next, keys, nil
+}
+
+/*
+MemoryUsage implements Client.
+
+All replies are read even if some fail, to keep the connection usable, and the
+first error is returned.
+*/
+func (rc *RedigoClient) MemoryUsage(ctx context.Context, keys []string) ([]int64, error) {
+	for _, key := range keys {
+		if err := rc.conn.Send("MEMORY", "USAGE", key); err != nil {
+			return nil, err
+		}
+	}
+	if err := rc.conn.Flush(); err != nil {
+		return nil, err
+	}
+	sizes := make([]int64, len(keys))
+	var firstErr error
+	for i := range keys {
+		size, err := redis.Int64(rc.receive(ctx))
+		switch {
+		case err == nil:
+			sizes[i] = size
+		case errors.Is(err, redis.ErrNil):
+			// The key expired or was deleted since it was scanned.
+		case firstErr == nil:
+			firstErr = err
+		}
+	}
+	return sizes, firstErr
+}
@@ -0,0 +1,27 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+)
+
+// newClient returns a client connected to a server holding, in each logical
+// database, keys using the given MEMORY USAGE, and the server to update them.
+func newClient(t *testing.T, dbs map[int]map[string]int64) (*RedigoClient, *redistest.Server) {
+	s := redistest.NewServer()
+	t.Cleanup(s.Close)
+	for db, sizes := range dbs {
+		for key, size := range sizes {
+			s.SetSized(db, key, size)
+		}
+	}
+	c, err := redis.DialURL(s.DSN(0))
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return NewRedigoClient(c), s
+}
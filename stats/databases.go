@@ -0,0 +1,126 @@
+package stats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+DatabaseStats holds the scan results for a single logical database.
+*/
+type DatabaseStats struct {
+	Index int
+	Stats CacheStats
+}
+
+/*
+Keyspace returns the indexes of the non-empty logical databases, in order, per
+the keyspace section of the INFO command, which contains lines like:
+
+	db0:keys=1,expires=0,avg_ttl=0
+*/
+func Keyspace(ctx context.Context, c Client) ([]int, error) {
+	dbs, _, err := keyspace(ctx, c)
+	return dbs, err
+}
+
+// keyspace is Keyspace, also returning the number of keys of each database.
+func keyspace(ctx context.Context, c Client) ([]int, []uint32, error) {
+	info, err := c.Info(ctx, "keyspace")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed INFO keyspace: %w", err)
+	}
+	var dbs []int
+	var sizes []uint32
+	sc := bufio.NewScanner(strings.NewReader(info))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "db") {
+			continue
+		}
+		name, fields, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("unexpected keyspace line: %q", line)
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(name, "db"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("unexpected keyspace database: %q", name)
+		}
+		for _, field := range strings.Split(fields, ",") {
+			if k, v, _ := strings.Cut(field, "="); k == "keys" && v != "0" {
+				n, err := strconv.ParseUint(v, 10, 32)
+				if err != nil {
+					return nil, nil, fmt.Errorf("unexpected keyspace keys: %q", line)
+				}
+				dbs, sizes = append(dbs, index), append(sizes, uint32(n))
+				break
+			}
+		}
+	}
+	return dbs, sizes, nil
+}
+
+/*
+ScanDatabases scans all non-empty logical databases in turn, on the same
+client, using Client.Select. It returns the results for each database holding
+Drupal cache keys, and their total, whose TotalKeys also counts the keys of the
+other databases.
+
+  - prefix is the Drupal cache prefix, as in CacheStats.Prefix.
+  - w is a logging output (think os.Stderr), not the main output.
+*/
+func ScanDatabases(c Client, prefix string, w io.Writer) (CacheStats, []DatabaseStats, error) {
+	return ScanDatabasesContext(context.Background(), c, prefix, "", nil, nil, w)
+}
+
+/*
+ScanDatabasesContext is ScanDatabases, stopping like CacheStats.ScanContext when
+ctx is done. The total and the database being scanned are then marked
+incomplete, and the databases not scanned yet are omitted, except from the
+TotalKeys of the total, per INFO keyspace, to measure its coverage.
+
+The scheme is that of each database, as in CacheStats.Scheme: with SchemeAuto,
+it is detected for each database, unless schemes, if not nil, holds the one
+detected on a previous scan, where the new ones are stored. The throttle, if not
+nil, paces the scans of all databases.
+*/
+func ScanDatabasesContext(ctx context.Context, c Client, prefix string, scheme Scheme, schemes SchemeCache, throttle *Throttle, w io.Writer) (CacheStats, []DatabaseStats, error) {
+	total := CacheStats{Prefix: prefix, Stats: map[string]BinStats{}}
+	// Sizing is not interrupted, to measure the coverage of interrupted scans.
+	dbs, sizes, err := keyspace(context.WithoutCancel(ctx), c)
+	if err != nil {
+		return total, nil, err
+	}
+	var res []DatabaseStats
+	for i, index := range dbs {
+		if err = ctx.Err(); err != nil {
+			total.AddUnscanned(sizes[i:])
+			return total, res, err
+		}
+		if err = c.Select(ctx, index); err != nil {
+			return total, res, fmt.Errorf("failed SELECT %d: %w", index, err)
+		}
+		target := strconv.Itoa(index)
+		ds := DatabaseStats{Index: index, Stats: CacheStats{Prefix: prefix, Scheme: schemes.Scheme(target, scheme), Throttle: throttle}}
+		err = ds.Stats.ScanContext(ctx, c, 0, w)
+		schemes.Store(target, ds.Stats.Scheme)
+		if err != nil && !ds.Stats.Incomplete {
+			return total, res, fmt.Errorf("failed scanning database %d: %w", index, err)
+		}
+		// Databases without Drupal cache keys are not listed, but their keys
+		// count in the TotalKeys of the total, like those of unscanned ones.
+		total.Merge(ds.Stats)
+		if len(ds.Stats.Stats) > 0 {
+			res = append(res, ds)
+		}
+		if err != nil {
+			total.AddUnscanned(sizes[i+1:])
+			return total, res, err
+		}
+	}
+	return total, res, nil
+}
@@ -0,0 +1,156 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+	"github.com/fgm/drupal_redis_stats/stats/progress"
+	"github.com/fgm/drupal_redis_stats/stats/statstest"
+)
+
+func TestKeyspace(t *testing.T) {
+	c, _ := newClient(t, map[int]map[string]int64{
+		0:  {"a": 100},
+		3:  {},
+		12: {"b": 100},
+	})
+	actual, err := Keyspace(context.Background(), c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []int{0, 12}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got %v, expected %v", actual, expected)
+	}
+	if _, sizes, err := keyspace(context.Background(), c); err != nil || !reflect.DeepEqual(sizes, []uint32{1, 1}) {
+		t.Errorf("got sizes %v, %v, expected [1 1]", sizes, err)
+	}
+}
+
+func TestScanDatabases(t *testing.T) {
+	c, _ := newClient(t, map[int]map[string]int64{
+		0: {"drupal.redis.10.1.0:render:a": 100, "drupal.redis.10.1.0:page:b": 200},
+		1: {"other:key": 1000},
+		2: {"drupal.redis.10.1.0:render:c": 300},
+	})
+	total, dbs, err := ScanDatabases(c, "", io.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server := &Server{Product: ProductRedis, Version: redistest.DefaultVersion, Mode: "standalone", MemoryUsage: true}
+	expectedDBs := []DatabaseStats{
+		{Index: 0, Stats: CacheStats{TotalKeys: 2, Stats: map[string]BinStats{"render": {1, 100}, "page": {1, 200}}, Server: server}},
+		{Index: 2, Stats: CacheStats{TotalKeys: 1, Stats: map[string]BinStats{"render": {1, 300}}, Server: server}},
+	}
+	if !reflect.DeepEqual(dbs, expectedDBs) {
+		t.Errorf("got %v, expected %v", dbs, expectedDBs)
+	}
+	// The key of database 1, which holds no Drupal cache keys, counts too.
+	expectedTotal := CacheStats{TotalKeys: 4, Stats: map[string]BinStats{"render": {2, 400}, "page": {1, 200}}, Server: server}
+	if !reflect.DeepEqual(total, expectedTotal) {
+		t.Errorf("got %v, expected %v", total, expectedTotal)
+	}
+}
+
+func TestScanDatabasesClient(t *testing.T) {
+	c := statstest.NewClient(map[string]int64{"drupal.redis.10.1.0:render:a": 100})
+	c.DBs = map[int]map[string]int64{4: {"drupal.redis.10.1.0:page:b": 200}}
+	c.Sections = map[string]string{"server": "redis_version:7.2.4\r\n"}
+	total, dbs, err := ScanDatabases(c, "", io.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dbs) != 2 || dbs[1].Index != 4 || dbs[1].Stats.Stats["page"] != (BinStats{1, 200}) {
+		t.Errorf("got databases %v, expected databases 0 and 4", dbs)
+	}
+	if total.TotalKeys != 2 || total.Stats["render"] != (BinStats{1, 100}) {
+		t.Errorf("unexpected total %v", total)
+	}
+}
+
+func TestScanDatabasesSchemes(t *testing.T) {
+	c, _ := newClient(t, map[int]map[string]int64{
+		0: {"drupal.redis.10.1.0:render:a": 100},
+		2: {"site:cache_page:b": 100},
+	})
+	schemes := SchemeCache{}
+	if _, _, err := ScanDatabasesContext(context.Background(), c, "", SchemeAuto, schemes, nil, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := (SchemeCache{"0": SchemeD8, "2": SchemeD7}); !reflect.DeepEqual(schemes, expected) {
+		t.Errorf("got schemes %v, expected %v", schemes, expected)
+	}
+
+	// Rescans use the stored schemes, instead of detecting them again.
+	schemes["0"] = SchemeD7
+	_, dbs, err := ScanDatabasesContext(context.Background(), c, "", SchemeAuto, schemes, nil, io.Discard)
+	if err != nil || len(dbs) != 1 || dbs[0].Index != 2 {
+		t.Errorf("got %v, %v, expected only database 2 to hold D7 keys", dbs, err)
+	}
+}
+
+func TestScanDatabasesCanceled(t *testing.T) {
+	c, s := newClient(t, map[int]map[string]int64{0: {"drupal.redis.10.1.0:render:a": 100}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	total, dbs, err := ScanDatabasesContext(ctx, c, "", "", nil, nil, io.Discard)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+	if !total.Incomplete || len(dbs) != 0 || total.TotalKeys != 1 {
+		t.Errorf("got total %v and databases %v, expected incomplete empty results", total, dbs)
+	}
+
+	// Cancel once the first database is sized, before its first SCAN batch.
+	s.SetSized(2, "drupal.redis.10.1.0:render:b", 100)
+	s.SetSized(2, "other:key", 1000)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	total, dbs, err = ScanDatabasesContext(ctx, c, "", "", nil, nil, cancelingReporter(cancel))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+	// The keys of the database not scanned count in the coverage.
+	if !total.Incomplete || total.TotalKeys != 3 || total.Coverage() != 0 {
+		t.Errorf("got total %v and databases %v, expected incomplete results for 3 keys", total, dbs)
+	}
+}
+
+// cancelingReporter is a progress output canceling the scan when it starts.
+type cancelingReporter context.CancelFunc
+
+func (cr cancelingReporter) Start(progress.Status)           { cr() }
+func (cr cancelingReporter) Update(progress.Status)          {}
+func (cr cancelingReporter) Done(progress.Status, error)     {}
+func (cr cancelingReporter) Write(p []byte) (n int, _ error) { return len(p), nil }
+
+func TestScanPrefix(t *testing.T) {
+	c := statstest.NewClient(map[string]int64{
+		"drupal.redis.10.1.0:render:a": 10,
+		"site[1]:render:b":             20,
+		"site[1]:page:c":               30,
+		"site2:page:d":                 40,
+	})
+	checks := [...]struct {
+		prefix   string
+		expected map[string]BinStats
+	}{
+		{"", map[string]BinStats{"render": {1, 10}}},
+		{"site[1]", map[string]BinStats{"render": {1, 20}, "page": {1, 30}}},
+		{"site2", map[string]BinStats{"page": {1, 40}}},
+	}
+	for _, check := range checks {
+		t.Run(check.prefix, func(t *testing.T) {
+			cs := CacheStats{Prefix: check.prefix}
+			if err := cs.Scan(c, 0, io.Discard); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cs.Stats, check.expected) {
+				t.Errorf("got %v, expected %v", cs.Stats, check.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Package goredis provides the stats.Client adapter for go-redis, allowing code
+already using go-redis to embed the scanner.
+*/
+package goredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+Client is the stats.Client adapter for go-redis clients.
+
+Since a scan examines a single database, it is meant for single node clients,
+like *redis.Client. With a *redis.ClusterClient, wrap each node client obtained
+from ForEachShard instead.
+*/
+type Client struct {
+	c redis.Cmdable
+}
+
+/*
+NewClient wraps a go-redis client, pipeline excepted, as a stats.Client.
+*/
+func NewClient(c redis.Cmdable) *Client {
+	return &Client{c: c}
+}
+
+/*
+DBSize implements stats.Client.
+*/
+func (gc *Client) DBSize(ctx context.Context) (uint64, error) {
+	n, err := gc.c.DBSize(ctx).Result()
+	return uint64(n), err
+}
+
+/*
+Info implements stats.Client.
+*/
+func (gc *Client) Info(ctx context.Context, section string) (string, error) {
+	return gc.c.Info(ctx, section).Result()
+}
+
+/*
+Select implements stats.Client.
+
+It needs a client bound to a single connection, like the *redis.Conn returned by
+Client.Conn: the pooled connections of a *redis.Client would not share the
+selected database.
+*/
+func (gc *Client) Select(ctx context.Context, db int) error {
+	sc, ok := gc.c.(redis.StatefulCmdable)
+	if !ok {
+		return fmt.Errorf("SELECT needs a single connection client, like *redis.Conn, not %T", gc.c)
+	}
+	return sc.Select(ctx, db).Err()
+}
+
+/*
+Scan implements stats.Client.
+*/
+func (gc *Client) Scan(ctx context.Context, cursor uint64, match string, count int64) (uint64, []string, error) {
+	keys, next, err := gc.c.Scan(ctx, cursor, match, count).Result()
+	return next, keys, err
+}
+
+/*
+MemoryUsage implements stats.Client.
+*/
+func (gc *Client) MemoryUsage(ctx context.Context, keys []string) ([]int64, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	// Errors are reported by each command, so the Exec error is redundant.
+	_, _ = gc.c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.MemoryUsage(ctx, key)
+		}
+		return nil
+	})
+	sizes := make([]int64, len(keys))
+	for i, cmd := range cmds {
+		size, err := cmd.Result()
+		switch {
+		case err == nil:
+			sizes[i] = size
+		case errors.Is(err, redis.Nil):
+			// The key expired or was deleted since it was scanned.
+		default:
+			return nil, err
+		}
+	}
+	return sizes, nil
+}
@@ -0,0 +1,88 @@
+package goredis_test
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+	"github.com/fgm/drupal_redis_stats/stats"
+	"github.com/fgm/drupal_redis_stats/stats/goredis"
+)
+
+func newClient(t *testing.T, s *redistest.Server) *redis.Client {
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr, Protocol: 2, DisableIndentity: true})
+	t.Cleanup(func() { _ = rc.Close() })
+	return rc
+}
+
+func TestClient(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	// More than a default SCAN COUNT, to exercise iteration.
+	s.Seed(0, redistest.DefaultPrefix, map[string]int{"render": 12, "page": 3})
+	s.Set(0, "other", "value")
+
+	var cs stats.CacheStats
+	if err := cs.Scan(goredis.NewClient(newClient(t, s)), 0, io.Discard); err != nil {
+		t.Fatalf("failed scanning: %v", err)
+	}
+	expected := &stats.Server{Product: stats.ProductRedis, Version: redistest.DefaultVersion, Mode: "standalone", MemoryUsage: true}
+	if !reflect.DeepEqual(cs.Server, expected) {
+		t.Errorf("got server %+v, expected %+v", cs.Server, expected)
+	}
+	if cs.TotalKeys != 16 || cs.Stats["render"].Keys != 12 || cs.Stats["page"].Keys != 3 {
+		t.Errorf("unexpected results %+v", cs)
+	}
+	// Seeded items only differ by their data size, growing by 1 byte per item.
+	render, page := cs.Stats["render"].Size, cs.Stats["page"].Size
+	if render <= 0 || page <= 0 || render/12 <= page/3 {
+		t.Errorf("unexpected sizes: render %d, page %d", render, page)
+	}
+}
+
+func TestClientMemoryUsage(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.Set(0, "a", "value")
+	c := goredis.NewClient(newClient(t, s))
+
+	sizes, err := c.MemoryUsage(context.Background(), []string{"a", "missing"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sizes) != 2 || sizes[0] <= 0 || sizes[1] != 0 {
+		t.Errorf("unexpected sizes %v", sizes)
+	}
+
+	s.Disable("MEMORY")
+	if _, err = c.MemoryUsage(context.Background(), []string{"a"}); !stats.IsServerError(err) {
+		t.Errorf("expected a server error, got %v", err)
+	}
+}
+
+func TestClientSelect(t *testing.T) {
+	s := redistest.NewServer()
+	defer s.Close()
+	s.Set(0, "a", "value")
+	s.Set(2, "b", "value")
+	s.Set(2, "c", "value")
+	rc := newClient(t, s)
+	ctx := context.Background()
+	if err := goredis.NewClient(rc).Select(ctx, 2); err == nil {
+		t.Error("unexpected success selecting a database on a pooled client")
+	}
+
+	conn := rc.Conn()
+	defer conn.Close()
+	c := goredis.NewClient(conn)
+	if err := c.Select(ctx, 2); err != nil {
+		t.Fatalf("failed selecting database 2: %v", err)
+	}
+	if n, err := c.DBSize(ctx); err != nil || n != 2 {
+		t.Errorf("got %d keys, %v, expected 2 in database 2", n, err)
+	}
+}
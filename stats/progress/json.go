@@ -0,0 +1,132 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event names of JSON progress events.
+const (
+	EventStarted  = "started"
+	EventBatch    = "batch"
+	EventFinished = "finished"
+	EventLog      = "log"
+)
+
+/*
+Event is a JSON progress event, written as a single line. Fields which do not
+apply to an event are omitted.
+*/
+type Event struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	DBSize  *uint64   `json:"dbsize,omitempty"`
+	Cursor  *uint64   `json:"cursor,omitempty"`
+	Seen    *uint64   `json:"seen,omitempty"`
+	Matched *uint64   `json:"matched,omitempty"`
+	Elapsed *float64  `json:"elapsed,omitempty"` // In seconds.
+	Bins    *int      `json:"bins,omitempty"`
+	Size    *int64    `json:"size,omitempty"`
+	// Complete is only set on finished events.
+	Complete *bool  `json:"complete,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+/*
+JSON is a Reporter writing progress events as newline-delimited JSON:
+
+  - started, with the dbsize;
+  - batch, after each SCAN batch, with the cursor, seen and matched keys, and
+    elapsed seconds;
+  - finished, with the same fields, the bins and size found, whether the scan
+    is complete, and the error which stopped it, if any.
+
+It is also an io.Writer, so it can be passed as the logging output of scans,
+which then report to it, other text written to it being wrapped in log events.
+It is safe for concurrent use.
+*/
+type JSON struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	now   func() time.Time
+	buf   bytes.Buffer // Incomplete log line.
+}
+
+/*
+NewJSON returns a JSON reporter writing to w.
+*/
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(w), now: time.Now}
+}
+
+func (j *JSON) emit(e Event) {
+	_ = j.enc.Encode(e)
+}
+
+// event builds an event of the given type for the status.
+func (j *JSON) event(name string, s Status) Event {
+	now := j.now()
+	elapsed := now.Sub(j.start).Seconds()
+	return Event{Event: name, Time: now, DBSize: &s.Total, Cursor: &s.Cursor, Seen: &s.Seen, Matched: &s.Matched, Elapsed: &elapsed}
+}
+
+/*
+Start writes a started event.
+*/
+func (j *JSON) Start(s Status) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.start = j.now()
+	j.emit(Event{Event: EventStarted, Time: j.start, DBSize: &s.Total})
+}
+
+/*
+Update writes a batch event.
+*/
+func (j *JSON) Update(s Status) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.event(EventBatch, s)
+	e.DBSize = nil
+	j.emit(e)
+}
+
+/*
+Done writes a finished event.
+*/
+func (j *JSON) Done(s Status, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.event(EventFinished, s)
+	complete := err == nil && s.Cursor == 0
+	e.Bins, e.Size, e.Complete = &s.Bins, &s.Size, &complete
+	if err != nil {
+		e.Error = err.Error()
+	}
+	j.emit(e)
+}
+
+/*
+Write wraps each line of text in a log event, keeping incomplete lines until
+they are completed.
+*/
+func (j *JSON) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.buf.Write(p)
+	for {
+		line, err := j.buf.ReadBytes('\n')
+		if err != nil { // No complete line left: keep the rest for later.
+			rest := append([]byte(nil), line...)
+			j.buf.Reset()
+			j.buf.Write(rest)
+			return len(p), nil
+		}
+		j.emit(Event{Event: EventLog, Time: j.now(), Message: string(bytes.TrimSuffix(line, []byte{'\n'}))})
+	}
+}
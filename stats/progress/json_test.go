@@ -0,0 +1,76 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	now, advance := fakeNow()
+	var buf bytes.Buffer
+	j := NewJSON(&buf)
+	j.now = now
+
+	j.Start(Status{Total: 100})
+	advance(time.Second)
+	j.Update(Status{Total: 100, Cursor: 12, Seen: 40, Matched: 40, Bins: 2, Size: 400})
+	fmt.Fprint(j, "node1: 2 ")
+	fmt.Fprint(j, "bins\nnode2")
+	advance(time.Second)
+	j.Done(Status{Total: 100, Cursor: 24, Seen: 50, Matched: 50, Bins: 3, Size: 500}, errors.New("canceled"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	checks := [...]struct {
+		name     string
+		expected map[string]any
+	}{
+		{EventStarted, map[string]any{"event": "started", "time": "2024-01-01T00:00:00Z", "dbsize": 100.0}},
+		{EventBatch, map[string]any{"event": "batch", "time": "2024-01-01T00:00:01Z",
+			"cursor": 12.0, "seen": 40.0, "matched": 40.0, "elapsed": 1.0}},
+		{EventLog, map[string]any{"event": "log", "time": "2024-01-01T00:00:01Z", "message": "node1: 2 bins"}},
+		{EventFinished, map[string]any{"event": "finished", "time": "2024-01-01T00:00:02Z", "dbsize": 100.0,
+			"cursor": 24.0, "seen": 50.0, "matched": 50.0, "elapsed": 2.0, "bins": 3.0, "size": 500.0,
+			"complete": false, "error": "canceled"}},
+	}
+	if len(lines) != len(checks) {
+		t.Fatalf("got %d events, expected %d: %s", len(lines), len(checks), buf.String())
+	}
+	for i, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			var actual map[string]any
+			if err := json.Unmarshal([]byte(lines[i]), &actual); err != nil {
+				t.Fatalf("failed decoding %s: %v", lines[i], err)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(check.expected) {
+				t.Errorf("got %v, expected %v", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestJSONComplete(t *testing.T) {
+	var buf bytes.Buffer
+	j := NewJSON(&buf)
+	j.Start(Status{Total: 0})
+	j.Done(Status{}, nil)
+	var e Event
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &e); err != nil {
+		t.Fatalf("failed decoding %s: %v", lines[len(lines)-1], err)
+	}
+	if e.Event != EventFinished || e.Complete == nil || !*e.Complete || e.Error != "" {
+		t.Errorf("unexpected finished event: %s", lines[len(lines)-1])
+	}
+}
+
+func TestNewReporter(t *testing.T) {
+	j := NewJSON(&bytes.Buffer{})
+	if New(j) != Reporter(j) {
+		t.Error("expected a Reporter writer to be used as is")
+	}
+}
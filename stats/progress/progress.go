@@ -1,63 +1,240 @@
 /*
-Package progress implements a simple progress bar.
+Package progress reports the progress of scans: as a bar redrawn in place on
+terminals, as periodic log lines otherwise, like in cron jobs or CI logs, or as
+JSON events for programs wrapping the command.
+
+The bar and log lines show the number of keys scanned, their rate, and the
+estimated time to completion.
 */
 package progress
 
 import (
 	"fmt"
-	"math"
+	"io"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/morikuni/aec"
+	"golang.org/x/term"
+)
+
+// Output pacing, to limit the overhead and volume of reporting.
+const (
+	// RedrawInterval is the minimum interval between two redraws of a Bar.
+	RedrawInterval = 100 * time.Millisecond
+	// LogInterval is the interval between two lines of a Log.
+	LogInterval = 10 * time.Second
 )
 
+// defaultWidth is the width of a Bar when the terminal width is unknown.
+const defaultWidth = 80
+
 /*
-Bar holds the configuration needed to generate a progress bar.
+Status is the progress of a scan.
 */
-type Bar struct {
-	bar   aec.ANSI
-	max   uint
-	right aec.ANSI
-	width uint
+type Status struct {
+	Total   uint64 // The number of keys in the database, per DBSIZE.
+	Cursor  uint64 // The SCAN cursor, 0 when starting or done.
+	Seen    uint64 // The number of keys returned by SCAN.
+	Matched uint64 // The number of Drupal cache keys among them.
+	Bins    int    // The number of cache bins found.
+	Size    int64  // The total size of the cache keys found.
 }
 
 /*
-Remove erases the progress bar from the line where it was displayed.
+Reporter receives the progress of a scan.
 */
-func (pb Bar) Remove() aec.ANSI {
-	return aec.EraseLine(aec.EraseModes.All)
+type Reporter interface {
+	// Start reports the start of the scan, once the number of keys is known.
+	Start(s Status)
+	// Update reports the progress after each SCAN batch.
+	Update(s Status)
+	// Done reports the end of the scan, complete or not, with the error which
+	// stopped it, if any.
+	Done(s Status, err error)
 }
 
 /*
-Render return the progress bar at the current progression status.
+New returns the Reporter suited to w: w itself if it is a Reporter, like JSON,
+a Bar if it is a terminal, a Log otherwise, and one doing nothing for
+io.Discard.
 */
-func (pb *Bar) Render(current float64) string {
-	if pb.max == 0 {
-		return aec.Up(1).String()
+func New(w io.Writer) Reporter {
+	if r, ok := w.(Reporter); ok {
+		return r
+	}
+	if w == io.Discard {
+		return nop{}
+	}
+	if f, ok := w.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return NewBar(w, func() int {
+			if width, _, err := term.GetSize(int(f.Fd())); err == nil && width > 0 {
+				return width
+			}
+			return defaultWidth
+		})
+	}
+	return NewLog(w, LogInterval)
+}
+
+type nop struct{}
+
+func (nop) Start(Status)       {}
+func (nop) Update(Status)      {}
+func (nop) Done(Status, error) {}
+
+// meter measures the rate of a scan, from its first update, so that the rate
+// of a resumed scan does not include the keys scanned before.
+type meter struct {
+	now   func() time.Time
+	start time.Time
+	first uint64
+}
+
+// status formats the progress of a scan, like "12/50 24.0% 6 keys/s ETA 7s".
+func (m *meter) status(current, total uint64) string {
+	now := m.now()
+	if m.start.IsZero() {
+		m.start, m.first = now, current
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d/%d %.1f%%", current, total, percent(current, total))
+	elapsed := now.Sub(m.start).Seconds()
+	if elapsed <= 0 || current < m.first {
+		return b.String()
+	}
+	rate := float64(current-m.first) / elapsed
+	fmt.Fprintf(&b, " %.0f keys/s", rate)
+	if rate > 0 && current < total {
+		eta := time.Duration(float64(total-current) / rate * float64(time.Second))
+		fmt.Fprintf(&b, " ETA %v", eta.Round(time.Second))
 	}
-	ratio := int(math.Round(float64((pb.width-2)*pb.max) / float64(pb.max)))
-	res := fmt.Sprintf("[%s%s %d/%d%s\n",
-		pb.bar.Apply(strings.Repeat("=", ratio)),
-		pb.right.Apply("]"),
-		int(math.Round(current)),
-		pb.max,
-		aec.Up(1),
-	)
-	return res
+	return b.String()
+}
+
+// percent returns the percentage of current in total, at most 100 since keys
+// can be added during the scan.
+func percent(current, total uint64) float64 {
+	if total == 0 || current >= total {
+		return 100
+	}
+	return 100 * float64(current) / float64(total)
 }
 
 /*
-MakeProgressBar creates a progress bar.
+Bar is a Reporter drawing a progress bar on a terminal line, redrawn in place,
+and fitted to the terminal width.
+*/
+type Bar struct {
+	w     io.Writer
+	width func() int
+	color aec.ANSI
+	drawn time.Time
+	meter
+}
 
-  - width is the maximum number of progress steps;
-  - max is the value for which the bar displays as full.
+/*
+NewBar returns a Bar drawn on w, with the width returned by the width function,
+which is called on each redraw to follow terminal resizes.
 */
-func MakeProgressBar(width uint, max uint32) Bar {
-	pb := Bar{
-		bar:   aec.Color8BitF(aec.NewRGB8Bit(255, 96, 51)),
-		max:   uint(max),
-		right: aec.Column(width),
+func NewBar(w io.Writer, width func() int) *Bar {
+	return &Bar{
+		w:     w,
 		width: width,
+		color: aec.Color8BitF(aec.NewRGB8Bit(255, 96, 51)),
+		meter: meter{now: time.Now},
+	}
+}
+
+/*
+Render returns the bar line for the progress, fitted to width.
+
+The filled part of the bar is proportional to current/total. The bar is
+omitted if the width cannot hold at least 10 steps.
+*/
+func (pb *Bar) Render(current, total uint64, width int) string {
+	status := pb.status(current, total)
+	// Keep the last column free, to avoid wrapping on some terminals.
+	steps := width - 1 - len(status) - 3
+	if steps < 10 {
+		return status
+	}
+	filled := int(float64(steps) * percent(current, total) / 100)
+	return "[" + pb.color.Apply(strings.Repeat("=", filled)) + strings.Repeat(" ", steps-filled) + "] " + status
+}
+
+/*
+Start does nothing: the bar is drawn on updates.
+*/
+func (pb *Bar) Start(Status) {}
+
+/*
+Update redraws the bar, at most once per RedrawInterval, and always once
+complete.
+*/
+func (pb *Bar) Update(s Status) {
+	now := pb.now()
+	if s.Seen < s.Total && now.Sub(pb.drawn) < RedrawInterval {
+		return
+	}
+	pb.drawn = now
+	_, _ = fmt.Fprint(pb.w, "\r"+pb.Render(s.Seen, s.Total, pb.width())+aec.EraseLine(aec.EraseModes.Tail).String())
+}
+
+/*
+Done erases the bar.
+*/
+func (pb *Bar) Done(Status, error) {
+	if pb.drawn.IsZero() {
+		return
 	}
-	return pb
+	pb.drawn = time.Time{}
+	_, _ = fmt.Fprint(pb.w, "\r"+aec.EraseLine(aec.EraseModes.All).String())
+}
+
+/*
+Log is a Reporter writing a progress line at intervals, for outputs which are
+not terminals.
+*/
+type Log struct {
+	w        io.Writer
+	interval time.Duration
+	logged   time.Time
+	meter
 }
+
+/*
+NewLog returns a Log writing to w at the given interval.
+*/
+func NewLog(w io.Writer, interval time.Duration) *Log {
+	return &Log{w: w, interval: interval, meter: meter{now: time.Now}}
+}
+
+/*
+Start does nothing: the first line is written after an interval.
+*/
+func (l *Log) Start(Status) {}
+
+/*
+Update writes a progress line if the interval elapsed since the previous one,
+or since the first update.
+*/
+func (l *Log) Update(s Status) {
+	status := l.status(s.Seen, s.Total)
+	now := l.now()
+	if l.logged.IsZero() {
+		l.logged = now
+		return
+	}
+	if now.Sub(l.logged) < l.interval {
+		return
+	}
+	l.logged = now
+	_, _ = fmt.Fprintf(l.w, "Scanned %s\n", status)
+}
+
+/*
+Done does nothing: the log lines need no cleanup.
+*/
+func (l *Log) Done(Status, error) {}
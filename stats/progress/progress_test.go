@@ -0,0 +1,133 @@
+package progress
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNow returns a clock function, and a function to advance it.
+func fakeNow() (func() time.Time, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestMeterStatus(t *testing.T) {
+	now, advance := fakeNow()
+	m := meter{now: now}
+	checks := [...]struct {
+		name           string
+		elapsed        time.Duration
+		current, total uint64
+		expected       string
+	}{
+		{"first", 0, 10, 50, "10/50 20.0%"},
+		{"rate from first", 2 * time.Second, 30, 50, "30/50 60.0% 10 keys/s ETA 2s"},
+		{"stalled", 2 * time.Second, 30, 50, "30/50 60.0% 5 keys/s ETA 4s"},
+		{"done", time.Second, 50, 50, "50/50 100.0% 8 keys/s"},
+		{"keys added", 0, 60, 50, "60/50 100.0% 10 keys/s"},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			advance(check.elapsed)
+			if actual := m.status(check.current, check.total); actual != check.expected {
+				t.Errorf("got %q, expected %q", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestBarRender(t *testing.T) {
+	checks := [...]struct {
+		name           string
+		current, total uint64
+		width          int
+		expFilled      int
+		expBar         bool
+	}{
+		// "5/20 25.0%" is 10 runes, leaving 40-1-10-3 = 26 steps.
+		{"quarter", 5, 20, 40, 6, true},
+		{"empty", 0, 20, 40, 0, true},
+		// "20/20 100.0%" is 2 runes longer.
+		{"full", 20, 20, 40, 24, true},
+		{"empty database", 0, 0, 40, 26, true},
+		{"narrow", 5, 20, 20, 0, false},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			now, _ := fakeNow()
+			pb := NewBar(io.Discard, nil)
+			pb.now = now
+			actual := pb.Render(check.current, check.total, check.width)
+			if strings.HasPrefix(actual, "[") != check.expBar {
+				t.Fatalf("got %q, expected bar %t", actual, check.expBar)
+			}
+			if filled := strings.Count(actual, "="); filled != check.expFilled {
+				t.Errorf("got %d filled steps, expected %d in %q", filled, check.expFilled, actual)
+			}
+			if check.expBar && len(pb.color.Apply(""))+check.width-1 != len(actual) {
+				t.Errorf("got %d bytes, expected the width, %d, plus colors: %q", len(actual), check.width-1, actual)
+			}
+		})
+	}
+}
+
+func TestBarUpdate(t *testing.T) {
+	now, advance := fakeNow()
+	w := strings.Builder{}
+	width := 40
+	pb := NewBar(&w, func() int { return width })
+	pb.now = now
+
+	pb.Done(Status{}, nil) // Nothing drawn yet, so nothing to erase.
+	pb.Update(Status{Seen: 1, Total: 10})
+	advance(RedrawInterval / 2)
+	pb.Update(Status{Seen: 2, Total: 10}) // Too soon.
+	advance(RedrawInterval)
+	width = 100 // Resized terminal.
+	pb.Update(Status{Seen: 3, Total: 10})
+	pb.Update(Status{Seen: 10, Total: 10}) // Complete: always drawn.
+	pb.Done(Status{}, nil)
+
+	lines := strings.Split(w.String(), "\r")[1:]
+	if len(lines) != 4 {
+		t.Fatalf("got %d draws, expected 4: %q", len(lines), w.String())
+	}
+	for i, expected := range []string{" 1/10 ", " 3/10 ", " 10/10 ", ""} {
+		if !strings.Contains(lines[i], expected) {
+			t.Errorf("draw %d: did not find %q in %q", i, expected, lines[i])
+		}
+	}
+	if len(lines[1]) <= len(lines[0])+50 {
+		t.Errorf("bar not widened on resize: %q then %q", lines[0], lines[1])
+	}
+}
+
+func TestLog(t *testing.T) {
+	now, advance := fakeNow()
+	w := strings.Builder{}
+	l := NewLog(&w, 10*time.Second)
+	l.now = now
+
+	l.Update(Status{Seen: 0, Total: 100})
+	advance(5 * time.Second)
+	l.Update(Status{Seen: 10, Total: 100})
+	advance(5 * time.Second)
+	l.Update(Status{Seen: 20, Total: 100})
+	advance(time.Second)
+	l.Update(Status{Seen: 30, Total: 100})
+	l.Done(Status{}, nil)
+	if expected := "Scanned 20/100 20.0% 2 keys/s ETA 40s\n"; w.String() != expected {
+		t.Errorf("got %q, expected %q", w.String(), expected)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, ok := New(io.Discard).(nop); !ok {
+		t.Error("expected a no-op reporter for io.Discard")
+	}
+	if _, ok := New(&strings.Builder{}).(*Log); !ok {
+		t.Error("expected a log reporter for a non-terminal")
+	}
+}
@@ -0,0 +1,204 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+/*
+Scheme is a format of the Drupal cache keys, which depends on the Drupal
+version and its redis module.
+*/
+type Scheme string
+
+// Key schemes.
+const (
+	// SchemeD8 is the format of Drupal 8 and later: <prefix>:<bin>:<cid>, the
+	// default prefix being drupal.redis.<version>. It is used when the scheme is
+	// empty.
+	SchemeD8 Scheme = "d8"
+	// SchemeD7 is the format of the Drupal 7 redis module: <prefix>:<table>:<cid>,
+	// where table is a cache table like cache_page, reported as the page bin, and
+	// the cache table as the default bin. Keys without a prefix are supported
+	// when no prefix is set, and the other keys of the module, like locks and
+	// path aliases, are ignored.
+	SchemeD7 Scheme = "d7"
+	// SchemeAuto detects the scheme from a sample of the keys, before scanning.
+	SchemeAuto Scheme = "auto"
+)
+
+// Auto-detection sampling: the number of SCAN batches, and their COUNT.
+const (
+	detectBatches = 10
+	detectCount   = 1000
+)
+
+/*
+ParseScheme parses a scheme name: d7, d8, or auto. The d8+ alias of d8 is also
+accepted.
+*/
+func ParseScheme(name string) (Scheme, error) {
+	switch s := Scheme(strings.ToLower(name)); s {
+	case SchemeD7, SchemeD8, SchemeAuto:
+		return s, nil
+	case "d8+":
+		return SchemeD8, nil
+	default:
+		return "", fmt.Errorf("invalid key scheme %q, expected d7, d8, or auto", name)
+	}
+}
+
+/*
+Schemes returns the schemes matching scheme: all of them for SchemeAuto, or
+scheme itself. Keys obtained without a server, which cannot be sampled before
+the scan, can be added to results for each of these schemes, to keep the one
+with the most keys, per MostKeys.
+*/
+func Schemes(scheme Scheme) []Scheme {
+	if scheme == SchemeAuto {
+		return []Scheme{SchemeD8, SchemeD7}
+	}
+	return []Scheme{scheme}
+}
+
+/*
+MostKeys returns the results holding the most Drupal cache keys, the first
+ones on ties.
+*/
+func MostKeys(results []CacheStats) CacheStats {
+	best := results[0]
+	for _, cs := range results[1:] {
+		if cs.ScannedKeys() > best.ScannedKeys() {
+			best = cs
+		}
+	}
+	return best
+}
+
+// Key regexps by scheme, for the default prefix.
+var (
+	defaultD8Regexp = regexp.MustCompile(`^drupal\.redis\.[-.\d\w]+:([\w]+):(.*)`)
+	defaultD7Regexp = regexp.MustCompile(`^(?:[^:]*:)?(cache(?:_[\w]+)?):(.*)`)
+)
+
+// keyRegexps caches the regexps built by keyRegexp, by scheme and prefix, since
+// keys obtained without a server are classified one at a time.
+var keyRegexps sync.Map
+
+// keyRegexp returns the regexp matching cache keys for the scheme and prefix in
+// use, capturing the bin, or table, name and the cache id.
+func (cs CacheStats) keyRegexp() *regexp.Regexp {
+	d7 := cs.Scheme == SchemeD7
+	switch {
+	case cs.Prefix == "" && d7:
+		return defaultD7Regexp
+	case cs.Prefix == "":
+		return defaultD8Regexp
+	}
+	id := string(cs.Scheme) + ":" + cs.Prefix
+	if re, ok := keyRegexps.Load(id); ok {
+		return re.(*regexp.Regexp)
+	}
+	bin := `([\w]+)`
+	if d7 {
+		bin = `(cache(?:_[\w]+)?)`
+	}
+	re, _ := keyRegexps.LoadOrStore(id, regexp.MustCompile(`^`+regexp.QuoteMeta(cs.Prefix)+`:`+bin+`:(.*)`))
+	return re.(*regexp.Regexp)
+}
+
+// classify returns the bin of a Drupal cache key, or false for other keys.
+func (cs CacheStats) classify(key string) (string, bool) {
+	sl := cs.keyRegexp().FindStringSubmatch(key)
+	if sl == nil {
+		return "", false
+	}
+	if cs.Scheme != SchemeD7 {
+		return sl[1], true
+	}
+	if sl[1] == "cache" {
+		return "default", true
+	}
+	return strings.TrimPrefix(sl[1], "cache_"), true
+}
+
+/*
+DetectScheme returns the scheme of the Drupal cache keys for the prefix, from a
+sample of the keys returned by the first SCAN batches: SchemeD7 if most Drupal
+cache keys in the sample use it, SchemeD8 otherwise, including when the sample
+has none.
+*/
+func DetectScheme(ctx context.Context, c Client, prefix string) (Scheme, error) {
+	return detectScheme(ctx, c, prefix, nil)
+}
+
+// detectScheme is DetectScheme, paced by the throttle if it is not nil, like
+// the scan it precedes. Only waiting for the throttle is interrupted by ctx.
+func detectScheme(ctx context.Context, c Client, prefix string, t *Throttle) (Scheme, error) {
+	d7 := CacheStats{Prefix: prefix, Scheme: SchemeD7}
+	d8 := CacheStats{Prefix: prefix, Scheme: SchemeD8}
+	cmdCtx := context.WithoutCancel(ctx)
+	var cursor uint64
+	var d7Keys, d8Keys int
+	for i := 0; i < detectBatches; i++ {
+		if err := t.beforeScan(ctx, c); err != nil {
+			return "", err
+		}
+		next, keys, err := c.Scan(cmdCtx, cursor, "*", detectCount)
+		if err != nil {
+			return "", fmt.Errorf("failed detecting key scheme: %w", err)
+		}
+		// The sampled keys are not measured.
+		if err = t.afterScan(ctx, 0); err != nil {
+			return "", err
+		}
+		for _, key := range keys {
+			// With a prefix, D8 keys include D7 ones: only count the others.
+			if _, ok := d7.classify(key); ok {
+				d7Keys++
+			} else if _, ok = d8.classify(key); ok {
+				d8Keys++
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if d7Keys > d8Keys {
+		return SchemeD7, nil
+	}
+	return SchemeD8, nil
+}
+
+/*
+SchemeCache holds the schemes detected on each target, like a database or a
+cluster node, so that rescans, like those of -watch, reuse them instead of
+sampling the keys again. A nil SchemeCache holds none.
+
+A SchemeCache is not safe for concurrent use.
+*/
+type SchemeCache map[string]Scheme
+
+/*
+Scheme returns the scheme with which to scan the target: the one detected on it
+before if scheme is SchemeAuto, or scheme itself.
+*/
+func (sc SchemeCache) Scheme(target string, scheme Scheme) Scheme {
+	if detected, ok := sc[target]; ok && scheme == SchemeAuto {
+		return detected
+	}
+	return scheme
+}
+
+/*
+Store records the scheme detected on the target, unless it is SchemeAuto, as
+left by scans stopped before detecting it.
+*/
+func (sc SchemeCache) Store(target string, scheme Scheme) {
+	if sc != nil && scheme != SchemeAuto {
+		sc[target] = scheme
+	}
+}
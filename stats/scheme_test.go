@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/stats/statstest"
+)
+
+func TestParseScheme(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		expected Scheme
+		expErr   bool
+	}{
+		{"d7", SchemeD7, false},
+		{"D8", SchemeD8, false},
+		{"d8+", SchemeD8, false},
+		{"auto", SchemeAuto, false},
+		{"", "", true},
+		{"d6", "", true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := ParseScheme(check.name)
+			if (err != nil) != check.expErr || actual != check.expected {
+				t.Errorf("got %q, %v, expected %q, error %t", actual, err, check.expected, check.expErr)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		scheme   Scheme
+		prefix   string
+		key      string
+		expected string // Empty for keys which are not cache keys.
+	}{
+		{"d8", SchemeD8, "", "drupal.redis.10.1.0:cache_page:x", "cache_page"},
+		{"d8 empty scheme", "", "site", "site:page:x", "page"},
+		{"d7 table", SchemeD7, "", "site:cache_page:http://example.com/", "page"},
+		{"d7 default table", SchemeD7, "", "site:cache:variables", "default"},
+		{"d7 without prefix", SchemeD7, "", "cache_bootstrap:system_list", "bootstrap"},
+		{"d7 lock", SchemeD7, "", "site:lock:cron", ""},
+		{"d7 path", SchemeD7, "", "site:path:dst:node/1", ""},
+		{"d7 cache prefix", SchemeD7, "", "site:caches:x", ""},
+		{"d7 with prefix", SchemeD7, "site", "site:cache_menu:x", "menu"},
+		{"d7 other prefix", SchemeD7, "site", "other:cache_menu:x", ""},
+		{"d7 d8 key", SchemeD7, "", "drupal.redis.10.1.0:render:x", ""},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			cs := CacheStats{Prefix: check.prefix, Scheme: check.scheme}
+			actual, ok := cs.classify(check.key)
+			if ok != (check.expected != "") || actual != check.expected {
+				t.Errorf("got %q, %t, expected %q", actual, ok, check.expected)
+			}
+		})
+	}
+}
+
+func TestDetectScheme(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		prefix   string
+		keys     []string
+		expected Scheme
+	}{
+		{"d8", "", []string{"drupal.redis.10.1.0:render:a", "drupal.redis.10.1.0:page:b", "site:cache:c"}, SchemeD8},
+		{"d7", "", []string{"drupal.redis.10.1.0:render:a", "site:cache_page:b", "site:cache:c"}, SchemeD7},
+		{"d7 with prefix", "site", []string{"site:cache_page:a", "site:cache:b", "site:lock:c"}, SchemeD7},
+		{"d8 with prefix", "site", []string{"site:page:a", "site:render:b", "site:cache_page:c"}, SchemeD8},
+		{"no cache keys", "", []string{"sessions:a"}, SchemeD8},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			actual, err := DetectScheme(context.Background(), &batchClient{keys: check.keys}, check.prefix)
+			if err != nil || actual != check.expected {
+				t.Errorf("got %q, %v, expected %q", actual, err, check.expected)
+			}
+		})
+	}
+}
+
+func TestDetectSchemeThrottle(t *testing.T) {
+	var clock fakeClock
+	throttle := clock.install(NewThrottle(ThrottleOptions{Rate: 4}))
+	// 4 detection SCANs, then 4 batches of 1 SCAN and 1 MEMORY USAGE: 12
+	// commands, the first 4 fitting in the bucket.
+	cs := CacheStats{Scheme: SchemeAuto, Throttle: throttle}
+	if err := cs.ScanContext(context.Background(), &batchClient{keys: renderKeys(4)}, 0, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs.Scheme != SchemeD8 || throttle.Waited() != 2*time.Second {
+		t.Errorf("got scheme %q and %v waited, expected d8 and 2s", cs.Scheme, throttle.Waited())
+	}
+
+	// Interruptions while waiting during the detection stop the scan.
+	ctx, cancel := context.WithCancel(context.Background())
+	throttle = clock.install(NewThrottle(ThrottleOptions{Rate: 1}))
+	throttle.sleep = func(context.Context, time.Duration) error {
+		cancel()
+		return context.Canceled
+	}
+	cs = CacheStats{Scheme: SchemeAuto, Throttle: throttle}
+	if err := cs.ScanContext(ctx, &batchClient{keys: renderKeys(4)}, 0, io.Discard); err != context.Canceled || !cs.Incomplete || cs.Scheme != SchemeAuto {
+		t.Errorf("got %v, %+v, expected an incomplete scan before detection", err, cs)
+	}
+}
+
+func TestSchemeCache(t *testing.T) {
+	var none SchemeCache
+	none.Store("0", SchemeD7)
+	schemes := SchemeCache{}
+	schemes.Store("0", SchemeD7)
+	schemes.Store("1", SchemeAuto)
+	checks := [...]struct {
+		name     string
+		cache    SchemeCache
+		target   string
+		scheme   Scheme
+		expected Scheme
+	}{
+		{"nil", none, "0", SchemeAuto, SchemeAuto},
+		{"stored", schemes, "0", SchemeAuto, SchemeD7},
+		{"explicit", schemes, "0", SchemeD8, SchemeD8},
+		{"auto not stored", schemes, "1", SchemeAuto, SchemeAuto},
+		{"other target", schemes, "2", SchemeAuto, SchemeAuto},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if actual := check.cache.Scheme(check.target, check.scheme); actual != check.expected {
+				t.Errorf("got %q, expected %q", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestScanScheme(t *testing.T) {
+	c := statstest.NewClient(map[string]int64{
+		"site:cache_page:a": 10,
+		"site:cache:b":      20,
+		"site:lock:c":       30,
+	})
+	checks := [...]struct {
+		scheme   Scheme
+		expected map[string]BinStats
+	}{
+		{SchemeAuto, map[string]BinStats{"page": {1, 10}, "default": {1, 20}}},
+		{SchemeD7, map[string]BinStats{"page": {1, 10}, "default": {1, 20}}},
+		{SchemeD8, map[string]BinStats{}},
+	}
+	for _, check := range checks {
+		t.Run(string(check.scheme), func(t *testing.T) {
+			cs := CacheStats{Scheme: check.scheme}
+			if err := cs.Scan(c, 0, io.Discard); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cs.Stats, check.expected) || cs.Scheme == SchemeAuto || cs.TotalKeys != 3 {
+				t.Errorf("got %+v, expected stats %v", cs, check.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package stats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Server products, which all speak the Redis protocol.
+const (
+	ProductRedis     = "Redis"
+	ProductValkey    = "Valkey"
+	ProductKeyDB     = "KeyDB"
+	ProductDragonfly = "Dragonfly"
+)
+
+// memoryProbeKey is the key used to check MEMORY USAGE is available. It does
+// not need to exist, MEMORY USAGE returning a nil reply for missing keys.
+const memoryProbeKey = "drupal_redis_stats:probe"
+
+/*
+Server describes the capabilities of the scanned server.
+*/
+type Server struct {
+	Product     string // One of the Product* constants.
+	Version     string
+	Mode        string // standalone, cluster or sentinel.
+	MemoryUsage bool   // Whether MEMORY USAGE can measure sizes.
+}
+
+/*
+String returns a human-readable description of the server, like:
+
+	Redis 7.2.4 (standalone)
+*/
+func (s Server) String() string {
+	var b strings.Builder
+	b.WriteString(s.Product)
+	if s.Version != "" {
+		b.WriteString(" " + s.Version)
+	}
+	if s.Mode != "" {
+		b.WriteString(" (" + s.Mode + ")")
+	}
+	if !s.MemoryUsage {
+		b.WriteString(", MEMORY USAGE unavailable: sizes not measured")
+	}
+	return b.String()
+}
+
+/*
+DetectServer identifies the server behind c and its capabilities.
+
+  - hello holds the fields of a HELLO reply, like "server" and "version", if
+    one was obtained when opening the connection. It may be nil.
+
+Detection is best-effort: servers with INFO disabled are reported from the
+HELLO fields only, and default to Redis.
+*/
+func DetectServer(ctx context.Context, c Client, hello map[string]string) Server {
+	s := Server{Product: ProductRedis, Version: hello["version"], Mode: hello["mode"]}
+	if strings.EqualFold(hello["server"], ProductValkey) {
+		s.Product = ProductValkey
+	}
+
+	if info, err := c.Info(ctx, "server"); err == nil {
+		s.applyInfo(parseInfo(info))
+	}
+
+	// Only a server error, like an unknown or renamed command, means MEMORY
+	// USAGE is unavailable: connection errors will fail the scan anyway.
+	_, err := c.MemoryUsage(ctx, []string{memoryProbeKey})
+	s.MemoryUsage = err == nil || !IsServerError(err)
+	return s
+}
+
+// applyInfo refines s from the fields of the INFO server section. Forks keep
+// the redis_version field for compatibility, so their own fields take precedence.
+func (s *Server) applyInfo(fields map[string]string) {
+	if v := fields["redis_version"]; v != "" {
+		s.Version = v
+	}
+	if m := fields["redis_mode"]; m != "" {
+		s.Mode = m
+	}
+	switch {
+	case fields["dragonfly_version"] != "":
+		s.Product = ProductDragonfly
+		s.Version = strings.TrimPrefix(strings.TrimPrefix(fields["dragonfly_version"], "df-"), "v")
+	case fields["valkey_version"] != "" || fields["server_name"] == "valkey":
+		s.Product = ProductValkey
+		if v := fields["valkey_version"]; v != "" {
+			s.Version = v
+		}
+	case strings.Contains(strings.ToLower(filepath.Base(fields["executable"])), "keydb"):
+		s.Product = ProductKeyDB
+	}
+}
+
+// parseInfo parses the "field:value" lines of an INFO reply, skipping the
+// "# Section" headers.
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(info))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+/*
+ParseHello converts a RESP2 HELLO reply, a flat list of field names and values,
+to a map. Non-scalar values, like the modules list, are skipped.
+*/
+func ParseHello(reply interface{}) (map[string]string, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected HELLO reply: %w", err)
+	}
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("unexpected HELLO reply with %d elements", len(values))
+	}
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		k, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected HELLO field name: %w", err)
+		}
+		switch v := values[i+1].(type) {
+		case []byte:
+			fields[k] = string(v)
+		case string:
+			fields[k] = v
+		case int64:
+			fields[k] = fmt.Sprint(v)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/stats/statstest"
+)
+
+func TestDetectServer(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		hello    map[string]string
+		info     string
+		memErr   error
+		expected Server
+	}{
+		{"redis", nil, "# Server\r\nredis_version:7.2.4\r\nredis_mode:standalone\r\n", nil,
+			Server{ProductRedis, "7.2.4", "standalone", true}},
+		{"hello only", map[string]string{"server": "valkey", "version": "8.0.1", "mode": "cluster"}, "", nil,
+			Server{ProductValkey, "8.0.1", "cluster", true}},
+		{"valkey", nil, "redis_version:7.2.4\r\nserver_name:valkey\r\nvalkey_version:8.0.1\r\nredis_mode:standalone\r\n", nil,
+			Server{ProductValkey, "8.0.1", "standalone", true}},
+		{"dragonfly", map[string]string{"server": "redis", "version": "7.2.0"}, "redis_version:7.2.0\r\ndragonfly_version:df-v1.21.2\r\nredis_mode:standalone\r\n", nil,
+			Server{ProductDragonfly, "1.21.2", "standalone", true}},
+		{"keydb", nil, "redis_version:6.3.4\r\nredis_mode:standalone\r\nexecutable:/usr/bin/keydb-server\r\n", nil,
+			Server{ProductKeyDB, "6.3.4", "standalone", true}},
+		{"no memory usage", nil, "redis_version:3.2.12\r\nredis_mode:sentinel\r\n", statstest.ErrUnsupported,
+			Server{ProductRedis, "3.2.12", "sentinel", false}},
+		{"memory usage, connection error", nil, "redis_version:7.2.4\r\n", errors.New("i/o timeout"),
+			Server{ProductRedis, "7.2.4", "", true}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			c := &statstest.Client{MemoryUsageErr: check.memErr}
+			if check.info != "" {
+				c.Sections = map[string]string{"server": check.info}
+			}
+			if actual := DetectServer(context.Background(), c, check.hello); actual != check.expected {
+				t.Errorf("got %+v, expected %+v", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestScanWithoutMemoryUsage(t *testing.T) {
+	c := &statstest.Client{
+		Keys:           map[string]int64{"drupal.redis.10.1.0:render:a": 10},
+		Sections:       map[string]string{"server": "redis_version:3.2.12\r\n"},
+		MemoryUsageErr: statstest.ErrUnsupported,
+	}
+	var cs CacheStats
+	if err := cs.Scan(c, 0, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cs.Server == nil || cs.Server.MemoryUsage {
+		t.Fatalf("MEMORY USAGE not detected as unavailable: %v", cs.Server)
+	}
+	expected := map[string]BinStats{"render": {Keys: 1}}
+	if !reflect.DeepEqual(cs.Stats, expected) {
+		t.Errorf("got %v, expected %v", cs.Stats, expected)
+	}
+}
+
+func TestParseHello(t *testing.T) {
+	reply := []interface{}{
+		[]byte("server"), []byte("redis"),
+		[]byte("version"), []byte("7.2.4"),
+		[]byte("proto"), int64(2),
+		[]byte("modules"), []interface{}{},
+	}
+	actual, err := ParseHello(reply)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{"server": "redis", "version": "7.2.4", "proto": "2"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got %v, expected %v", actual, expected)
+	}
+
+	for _, bad := range []interface{}{[]byte("OK"), []interface{}{[]byte("server")}} {
+		if _, err = ParseHello(bad); err == nil {
+			t.Errorf("unexpected success parsing %v", bad)
+		}
+	}
+}
@@ -4,12 +4,11 @@ Package stats extracts Drupal 8 cache information from a Redis database.
 package stats
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"regexp"
 	"strconv"
-
-	"github.com/gomodule/redigo/redis"
+	"unicode/utf8"
 
 	"github.com/fgm/drupal_redis_stats/stats/progress"
 )
@@ -23,15 +22,11 @@ type BinStats struct {
 }
 
 /*
-addEntry stores the key and data size for a Redis key.
+addEntry stores the data size for a Redis key.
 */
-func (bs *BinStats) addEntry(c redis.Conn, key string) {
+func (bs *BinStats) addEntry(size int64) {
 	bs.Keys++
-	val, err := redis.Int64(c.Do("MEMORY", "USAGE", key))
-	if err != nil {
-		panic(fmt.Errorf("failed MEMORY USAGE: %v", err))
-	}
-	bs.Size += val
+	bs.Size += size
 }
 
 /*
@@ -43,42 +38,87 @@ type CacheStats struct {
 	//memoryUsed    uint64
 	//memoryPeak    uint64
 	//drupalVersion string
-	//TODO #2
-	//drupalPrefix  string
+	Prefix string `json:",omitempty"` // Drupal cache prefix. Empty for the default drupal.redis.<version> ones.
+	// Scheme is the format of the Drupal cache keys, SchemeD8 if empty. When it
+	// is SchemeAuto, Scan replaces it with the detected scheme.
+	Scheme    Scheme `json:",omitempty"`
 	TotalKeys uint32 // Redis hardcoded limit.
 	Stats     map[string]BinStats
+	Server    *Server `json:",omitempty"` // Detected by Scan if not set beforehand.
+	// Incomplete is set when the scan was interrupted, the results then only
+	// covering the keys scanned until then.
+	Incomplete bool `json:",omitempty"`
+	// Cursor is the SCAN cursor from which the scan continues, 0 when it is done.
+	Cursor uint64 `json:",omitempty"`
+	// Throttle, if set, paces the scan to protect the server.
+	Throttle *Throttle `json:"-"`
+	// Progress, if set, receives the scan progress instead of the writer passed
+	// to Scan, allowing it to span several calls, like those of a resumed scan.
+	Progress progress.Reporter `json:"-"`
 }
 
-// indexKeys assumes cs.Stats is already initialized to a non-nil value.
-func (cs *CacheStats) indexKeys(c redis.Conn, keys []string) error {
-	const reString = `drupal\.redis\.[-.\d\w]+:([\w]+):(.*)`
-
-	var re = regexp.MustCompile(reString)
-
+// matchKeys returns the Drupal cache keys among those returned by SCAN, which
+// also returns the other keys, and their bins.
+func (cs CacheStats) matchKeys(keys []string) (matched, bins []string) {
 	for _, key := range keys {
-		sl := re.FindStringSubmatch(key)
-		if sl == nil {
-			return fmt.Errorf("unexpected non-matching key: %s", key)
+		if bin, ok := cs.classify(key); ok {
+			matched = append(matched, key)
+			bins = append(bins, bin)
 		}
-		bin := sl[1]
-		if _, ok := cs.Stats[bin]; !ok {
-			cs.Stats[bin] = BinStats{}
+	}
+	return matched, bins
+}
+
+// indexKeys adds the matched keys to the statistics of their bins.
+//
+// It assumes cs.Stats is already initialized to a non-nil value.
+func (cs *CacheStats) indexKeys(ctx context.Context, c Client, matched, bins []string) error {
+	sizes := make([]int64, len(matched))
+	if len(matched) > 0 && (cs.Server == nil || cs.Server.MemoryUsage) {
+		var err error
+		if sizes, err = c.MemoryUsage(ctx, matched); err != nil {
+			return fmt.Errorf("failed MEMORY USAGE: %w", err)
 		}
+	}
+	for i, bin := range bins {
 		binStats := cs.Stats[bin]
-		binStats.addEntry(c, key)
+		binStats.addEntry(sizes[i])
 		cs.Stats[bin] = binStats
 	}
 	return nil
 }
 
+/*
+AddKey adds a key obtained without a server, like from a dump file, to the
+statistics of its bin, with its size in bytes.
+
+Unlike the keys returned by SCAN, these are not filtered by prefix: it ignores
+the keys which are not Drupal cache keys for cs.Prefix and cs.Scheme, which
+cannot be SchemeAuto, and returns whether it added the key. It does not update
+TotalKeys, which counts all the keys in the database, including those ignored.
+*/
+func (cs *CacheStats) AddKey(key string, size int64) bool {
+	bin, ok := cs.classify(key)
+	if !ok {
+		return false
+	}
+	if cs.Stats == nil {
+		cs.Stats = map[string]BinStats{}
+	}
+	binStats := cs.Stats[bin]
+	binStats.addEntry(size)
+	cs.Stats[bin] = binStats
+	return true
+}
+
 /*
 MaxBinNameLength returns the length in runes of the longest bin name.
 */
 func (cs CacheStats) MaxBinNameLength() int {
 	var max int
 	for k := range cs.Stats {
-		if len(k) > max {
-			max = len(k)
+		if l := utf8.RuneCountInString(k); l > max {
+			max = l
 		}
 	}
 
@@ -86,52 +126,153 @@ func (cs CacheStats) MaxBinNameLength() int {
 }
 
 /*
-Scan examines the active database for keys matching the Drupal cache bin format.
+Scan examines the active database for keys matching the Drupal cache bin format,
+using cs.Prefix if it is set.
 
-  - c is the established connection to Redis on which to perform the Scan.
+It is ScanContext with a background context, so it cannot be interrupted.
+*/
+func (cs *CacheStats) Scan(c Client, maxPasses uint32, w io.Writer) error {
+	return cs.ScanContext(context.Background(), c, maxPasses, w)
+}
+
+/*
+ScanContext examines the active database for keys matching the Drupal cache bin
+format of cs.Scheme, using cs.Prefix if it is set.
+
+Unless cs.Server is already set, it detects the server first, to only use the
+commands it supports. If cs.Scheme is SchemeAuto, it then detects the scheme,
+paced by cs.Throttle like the scan.
+
+When ctx is canceled or its deadline is exceeded, it stops between two SCAN
+batches, or while waiting for cs.Throttle, sets cs.Incomplete, and returns the
+context error, cs then holding the results for the keys scanned until then.
+The batch in progress is completed, so these results are consistent.
+
+It starts from cs.Cursor, and keeps it updated after each batch, so a scan
+stopped by ctx, maxPasses, or an error, can be resumed by calling it again, even
+on results saved and loaded since. Since SCAN returns all the keys present for
+the whole iteration at least once, the results are then those of a single scan.
+
+  - c is the client for the established connection on which to perform the Scan.
   - maxPasses allows limiting the number of Redis SCAN steps. Use 0 for no limit.
-  - writer is a logging output (think os.Stderr), not the main output.
+  - writer is a logging output (think os.Stderr), not the main output, on which
+    progress is reported with a progress.New reporter, unless cs.Progress is
+    set. This is the writer itself if it is a progress.Reporter, like
+    progress.JSON.
 */
-func (cs *CacheStats) Scan(c redis.Conn, maxPasses uint32, w io.Writer) error {
+func (cs *CacheStats) ScanContext(ctx context.Context, c Client, maxPasses uint32, w io.Writer) (err error) {
 	if cs.Stats == nil {
 		cs.Stats = map[string]BinStats{}
 	}
+	// Commands are not interrupted, only the loop over batches. DBSIZE is always
+	// queried, to measure the coverage of even an empty partial result.
+	cmdCtx := context.WithoutCancel(ctx)
+	if cs.Server == nil {
+		server := DetectServer(cmdCtx, c, nil)
+		cs.Server = &server
+	}
 
-	dbSize, err := redis.Uint64(c.Do("DBSIZE"))
-	if err != nil {
+	var dbSize uint64
+	if dbSize, err = c.DBSize(cmdCtx); err != nil {
 		return err
 	}
 	cs.TotalKeys = uint32(dbSize) // Cannot be >= 2^32 in Redis anyway.
-	pb := progress.MakeProgressBar(80, cs.TotalKeys)
+	if cs.Scheme == SchemeAuto {
+		var scheme Scheme
+		if scheme, err = detectScheme(ctx, c, cs.Prefix, cs.Throttle); err != nil {
+			cs.Incomplete = ctx.Err() != nil
+			return err
+		}
+		cs.Scheme = scheme
+	}
+	pr := cs.Progress
+	if pr == nil {
+		pr = progress.New(w)
+	}
 	var passes uint32 // The number of performed SCAN passes.
-	var seen float64
-	var iterator int  // Type chosen by Redigo
-	var keys []string // The keys returned by a single SCAN pass.
+	seen := uint64(cs.ScannedKeys())
+	pr.Start(cs.progress(seen))
+	defer func() { pr.Done(cs.progress(seen), err) }()
 	for {
+		if err = ctx.Err(); err != nil {
+			cs.Incomplete = true
+			return err
+		}
 		passes++
-		// Run one Scan pass with the current iterator position.
-		arr, err := redis.Values(c.Do("SCAN", iterator, "MATCH", "drupal.redis.*"))
+		if err = cs.Throttle.beforeScan(ctx, c); err != nil {
+			cs.Incomplete = ctx.Err() != nil
+			return err
+		}
+		// Run one Scan pass with the current iterator position. All keys are
+		// returned, and filtered here, so the progress covers the whole database.
+		next, keys, err := c.Scan(cmdCtx, cs.Cursor, "*", 0)
 		if err != nil {
 			return err
 		}
-		// Parse Scan pass results.
-		iterator, _ = redis.Int(arr[0], nil)
-		keys, _ = redis.Strings(arr[1], nil)
-		seen += float64(len(keys))
-		_, _ = fmt.Fprint(w, pb.Render(seen))
-		err = cs.indexKeys(c, keys)
+		matched, bins := cs.matchKeys(keys)
+		// Waiting for the throttle here leaves the batch to be scanned again.
+		measured := len(matched)
+		if cs.Server != nil && !cs.Server.MemoryUsage {
+			measured = 0
+		}
+		if err = cs.Throttle.afterScan(ctx, measured); err != nil {
+			cs.Incomplete = ctx.Err() != nil
+			return err
+		}
+		seen += uint64(len(keys))
+		err = cs.indexKeys(cmdCtx, c, matched, bins)
 		if err != nil {
 			return err
 		}
+		// Only move on once the batch is indexed, so it is scanned again on resume.
+		cs.Cursor = next
+		pr.Update(cs.progress(seen))
 		// When iteration is done, the returned iterator will be 0.
-		if iterator == 0 || (maxPasses != 0 && passes >= maxPasses) {
+		if cs.Cursor == 0 || (maxPasses != 0 && passes >= maxPasses) {
 			break
 		}
 	}
-	_, _ = fmt.Fprint(w, pb.Remove())
 	return nil
 }
 
+// progress returns the progress status of the scan, seen keys having been
+// returned by SCAN.
+func (cs CacheStats) progress(seen uint64) progress.Status {
+	return progress.Status{
+		Total:   uint64(cs.TotalKeys),
+		Cursor:  cs.Cursor,
+		Seen:    seen,
+		Matched: uint64(cs.ScannedKeys()),
+		Bins:    len(cs.Stats),
+		Size:    cs.TotalSize(),
+	}
+}
+
+/*
+ScannedKeys returns the number of Drupal cache keys found by the scan.
+*/
+func (cs CacheStats) ScannedKeys() uint32 {
+	var keys uint32
+	for _, v := range cs.Stats {
+		keys += v.Keys
+	}
+	return keys
+}
+
+/*
+Coverage returns the percentage of the database keys, per DBSIZE, found by the
+scan.
+
+Since the database may also hold other keys, this is a lower bound of the
+actual coverage of an incomplete scan.
+*/
+func (cs CacheStats) Coverage() float64 {
+	if cs.TotalKeys == 0 {
+		return 100
+	}
+	return 100 * float64(cs.ScannedKeys()) / float64(cs.TotalKeys)
+}
+
 /*
 ItemCountLength returns the length in runes of the total number of keys.
 
@@ -162,3 +303,66 @@ Since this is the sum of all bins, it is at least as large as any per-bin info.
 func (cs CacheStats) TotalSizeLength() int {
 	return len(strconv.FormatInt(cs.TotalSize(), 10))
 }
+
+/*
+SizeLength returns the length in runes of the longest size, bins and total
+included, once formatted by the format function.
+
+Unlike TotalSizeLength, it supports formats like humanized sizes, for which the
+total is not necessarily the longest value.
+*/
+func (cs CacheStats) SizeLength(format func(int64) string) int {
+	max := utf8.RuneCountInString(format(cs.TotalSize()))
+	for _, v := range cs.Stats {
+		if l := utf8.RuneCountInString(format(v.Size)); l > max {
+			max = l
+		}
+	}
+	return max
+}
+
+/*
+MaxBinSize returns the size of the largest bin, expressed in bytes.
+*/
+func (cs CacheStats) MaxBinSize() int64 {
+	var max int64
+	for _, v := range cs.Stats {
+		if v.Size > max {
+			max = v.Size
+		}
+	}
+	return max
+}
+
+/*
+Merge adds the results of another scan, like the one of another cluster node,
+to cs.
+*/
+func (cs *CacheStats) Merge(other CacheStats) {
+	if cs.Stats == nil {
+		cs.Stats = make(map[string]BinStats, len(other.Stats))
+	}
+	if cs.Server == nil {
+		cs.Server = other.Server
+	}
+	cs.Incomplete = cs.Incomplete || other.Incomplete
+	cs.TotalKeys += other.TotalKeys
+	for name, bs := range other.Stats {
+		merged := cs.Stats[name]
+		merged.Keys += bs.Keys
+		merged.Size += bs.Size
+		cs.Stats[name] = merged
+	}
+}
+
+/*
+AddUnscanned marks merged results incomplete, like those of a scan interrupted
+before some databases or cluster nodes, and counts the keys of these, per their
+sizes, in TotalKeys, to measure the coverage of the results.
+*/
+func (cs *CacheStats) AddUnscanned(sizes []uint32) {
+	cs.Incomplete = true
+	for _, n := range sizes {
+		cs.TotalKeys += n
+	}
+}
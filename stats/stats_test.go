@@ -0,0 +1,207 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/stats/progress"
+	"github.com/fgm/drupal_redis_stats/stats/statstest"
+)
+
+// batchClient is a Client returning one key per SCAN batch, calling onScan
+// before each batch, and reporting the successive ops values in INFO stats.
+type batchClient struct {
+	keys   []string
+	onScan func(batch int)
+	memErr error
+	ops    []int64
+}
+
+func (bc *batchClient) DBSize(context.Context) (uint64, error) {
+	return uint64(len(bc.keys)), nil
+}
+
+func (bc *batchClient) Info(_ context.Context, section string) (string, error) {
+	if section != "stats" {
+		return "redis_version:7.2.4\r\n", nil
+	}
+	if len(bc.ops) == 0 {
+		return "", errors.New("no more INFO stats")
+	}
+	ops := bc.ops[0]
+	bc.ops = bc.ops[1:]
+	return "# Stats\r\ninstantaneous_ops_per_sec:" + strconv.FormatInt(ops, 10) + "\r\n", nil
+}
+
+func (bc *batchClient) Select(context.Context, int) error {
+	return nil
+}
+
+func (bc *batchClient) Scan(_ context.Context, cursor uint64, _ string, _ int64) (uint64, []string, error) {
+	if bc.onScan != nil {
+		bc.onScan(int(cursor))
+	}
+	next := cursor + 1
+	if next == uint64(len(bc.keys)) {
+		next = 0
+	}
+	return next, bc.keys[cursor : cursor+1], nil
+}
+
+func (bc *batchClient) MemoryUsage(_ context.Context, keys []string) ([]int64, error) {
+	if bc.memErr != nil {
+		return nil, bc.memErr
+	}
+	return make([]int64, len(keys)), nil
+}
+
+func TestScanContext(t *testing.T) {
+	keys := make([]string, 5)
+	for i := range keys {
+		keys[i] = "drupal.redis.10.1.0:render:" + strconv.Itoa(i)
+	}
+	checks := [...]struct {
+		name     string
+		cancelAt int // The batch before which to cancel, -1 to cancel before the scan.
+		expKeys  uint32
+		expError error
+	}{
+		{"not canceled", len(keys), 5, nil},
+		{"canceled before", -1, 0, context.Canceled},
+		{"canceled at first batch", 0, 1, context.Canceled},
+		{"canceled at third batch", 2, 3, context.Canceled},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if check.cancelAt < 0 {
+				cancel()
+			}
+			c := &batchClient{keys: keys, onScan: func(batch int) {
+				// The batch in progress completes, so its key is counted.
+				if batch == check.cancelAt {
+					cancel()
+				}
+			}}
+			var cs CacheStats
+			err := cs.ScanContext(ctx, c, 0, io.Discard)
+			if !errors.Is(err, check.expError) {
+				t.Fatalf("got error %v, expected %v", err, check.expError)
+			}
+			if cs.Incomplete != (check.expError != nil) {
+				t.Errorf("got incomplete %t with error %v", cs.Incomplete, err)
+			}
+			if actual := cs.ScannedKeys(); actual != check.expKeys {
+				t.Errorf("got %d keys, expected %d", actual, check.expKeys)
+			}
+		})
+	}
+}
+
+func TestScanResume(t *testing.T) {
+	keys := make([]string, 5)
+	for i := range keys {
+		keys[i] = "drupal.redis.10.1.0:render:" + strconv.Itoa(i)
+	}
+	c := &batchClient{keys: keys}
+	var cs CacheStats
+	if err := cs.ScanContext(context.Background(), c, 2, io.Discard); err != nil {
+		t.Fatalf("failed first passes: %v", err)
+	}
+	if cs.Cursor != 2 || cs.ScannedKeys() != 2 {
+		t.Fatalf("got cursor %d and %d keys, expected 2 and 2", cs.Cursor, cs.ScannedKeys())
+	}
+
+	// A failed batch is not indexed, and the cursor stays on it.
+	c.memErr = errors.New("connection lost")
+	if err := cs.ScanContext(context.Background(), c, 0, io.Discard); !errors.Is(err, c.memErr) {
+		t.Fatalf("got %v, expected %v", err, c.memErr)
+	}
+	if cs.Cursor != 2 || cs.ScannedKeys() != 2 {
+		t.Fatalf("got cursor %d and %d keys after failure, expected 2 and 2", cs.Cursor, cs.ScannedKeys())
+	}
+
+	c.memErr = nil
+	if err := cs.ScanContext(context.Background(), c, 0, io.Discard); err != nil {
+		t.Fatalf("failed resuming: %v", err)
+	}
+	if cs.Cursor != 0 || cs.ScannedKeys() != 5 || cs.Stats["render"].Keys != 5 {
+		t.Errorf("got cursor %d and %d keys after resuming, expected 0 and 5", cs.Cursor, cs.ScannedKeys())
+	}
+}
+
+// lastReporter is a progress output keeping the last status reported.
+type lastReporter struct {
+	last progress.Status
+}
+
+func (lr *lastReporter) Start(s progress.Status)         { lr.last = s }
+func (lr *lastReporter) Update(s progress.Status)        { lr.last = s }
+func (lr *lastReporter) Done(s progress.Status, _ error) { lr.last = s }
+func (lr *lastReporter) Write(p []byte) (n int, _ error) { return len(p), nil }
+
+func TestScanProgress(t *testing.T) {
+	keys := map[string]int64{"other:a": 1, "other:b": 1, "drupal.redis.10.1.0:page:c": 1}
+	for i := 0; i < 20; i++ {
+		keys["drupal.redis.10.1.0:render:"+strconv.Itoa(i)] = 1
+	}
+	rec := &lastReporter{}
+	cs := CacheStats{Progress: rec}
+	if err := cs.Scan(statstest.NewClient(keys), 0, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The keys outside Drupal cache bins are seen too, so the progress ends at
+	// the database size.
+	if rec.last.Total != 23 || rec.last.Seen != 23 || rec.last.Matched != 21 {
+		t.Errorf("got status %+v, expected 23 keys seen out of 23, 21 matched", rec.last)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		cs       CacheStats
+		expected float64
+	}{
+		{"empty", CacheStats{}, 100},
+		{"partial", CacheStats{TotalKeys: 8, Stats: map[string]BinStats{"render": {Keys: 1}, "page": {Keys: 1}}}, 25},
+		{"full", CacheStats{TotalKeys: 2, Stats: map[string]BinStats{"render": {Keys: 2}}}, 100},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if actual := check.cs.Coverage(); actual != check.expected {
+				t.Errorf("got %f, expected %f", actual, check.expected)
+			}
+		})
+	}
+}
+
+func TestAddKey(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		prefix   string
+		key      string
+		expected bool
+	}{
+		{"default", "", "drupal.redis.10.1.0:render:node", true},
+		{"default other", "", "sessions:abc", false},
+		{"default not at start", "", "x:drupal.redis.10.1.0:render:node", false},
+		{"prefix", "site", "site:page:/home", true},
+		{"prefix other", "site", "other:page:/home", false},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			cs := CacheStats{Prefix: check.prefix}
+			if actual := cs.AddKey(check.key, 10); actual != check.expected {
+				t.Fatalf("got %t, expected %t", actual, check.expected)
+			}
+			if check.expected && cs.TotalSize() != 10 {
+				t.Errorf("got size %d, expected 10", cs.TotalSize())
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Package statstest provides an in-memory stats.Client, to unit test code using
+the scanner without a Redis server.
+*/
+package statstest
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCount is the number of keys examined by a SCAN step without COUNT,
+// like on a Redis server.
+const DefaultCount = 10
+
+// Databases is the number of databases which can be selected, like with the
+// default databases setting of Redis.
+const Databases = 16
+
+/*
+Error is an error reply from the fake server, reported by stats.IsServerError.
+*/
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// RedisError marks Error as a server error, like the go-redis errors.
+func (e Error) RedisError() {}
+
+// ErrUnsupported can be used as MemoryUsageErr to emulate servers without
+// MEMORY USAGE.
+const ErrUnsupported = Error("ERR unknown command 'MEMORY'")
+
+/*
+Client is an in-memory stats.Client serving a fixed set of keys.
+
+It is safe for concurrent use. Its exported fields must not be modified after
+its first use.
+*/
+type Client struct {
+	// Keys maps key names to their MEMORY USAGE, in database 0.
+	Keys map[string]int64
+	// DBs maps the indexes of other databases to their keys, like Keys.
+	DBs map[int]map[string]int64
+	// Sections maps INFO sections to their contents. Missing sections fail,
+	// like on servers disabling INFO, except keyspace, which then describes the
+	// keys of all databases.
+	Sections map[string]string
+	// MemoryUsageErr, if not nil, is returned by MemoryUsage.
+	MemoryUsageErr error
+
+	mu       sync.Mutex
+	db       int              // The selected database.
+	names    map[int][]string // Sorted key names of each database, the SCAN order.
+	commands map[string]int
+}
+
+/*
+NewClient returns a fake client serving the given keys and their sizes.
+*/
+func NewClient(keys map[string]int64) *Client {
+	return &Client{Keys: keys}
+}
+
+// keys returns the keys of a database.
+func (c *Client) keys(db int) map[string]int64 {
+	if db == 0 {
+		return c.Keys
+	}
+	return c.DBs[db]
+}
+
+// record counts a command, and returns the keys of the selected database and
+// their sorted names.
+func (c *Client) record(cmd string) (map[string]int64, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.commands == nil {
+		c.commands = make(map[string]int)
+		c.names = make(map[int][]string)
+	}
+	c.commands[cmd]++
+	keys := c.keys(c.db)
+	names, ok := c.names[c.db]
+	if !ok {
+		names = make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.names[c.db] = names
+	}
+	return keys, names
+}
+
+/*
+Commands returns the number of calls received for each command, like "SCAN".
+*/
+func (c *Client) Commands() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[string]int, len(c.commands))
+	for k, v := range c.commands {
+		res[k] = v
+	}
+	return res
+}
+
+/*
+DBSize implements stats.Client.
+*/
+func (c *Client) DBSize(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	_, names := c.record("DBSIZE")
+	return uint64(len(names)), nil
+}
+
+/*
+Info implements stats.Client.
+*/
+func (c *Client) Info(ctx context.Context, section string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	c.record("INFO")
+	info, ok := c.Sections[section]
+	switch {
+	case ok:
+		return info, nil
+	case section == "keyspace":
+		return c.keyspace(), nil
+	default:
+		return "", Error("ERR unknown command 'INFO'")
+	}
+}
+
+// keyspace returns the keyspace section of INFO, listing non-empty databases.
+func (c *Client) keyspace() string {
+	b := strings.Builder{}
+	b.WriteString("# Keyspace\r\n")
+	for db := 0; db < Databases; db++ {
+		if n := len(c.keys(db)); n > 0 {
+			fmt.Fprintf(&b, "db%d:keys=%d,expires=0,avg_ttl=0\r\n", db, n)
+		}
+	}
+	return b.String()
+}
+
+/*
+Select implements stats.Client.
+*/
+func (c *Client) Select(ctx context.Context, db int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.record("SELECT")
+	if db < 0 || db >= Databases {
+		return Error("ERR DB index is out of range")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.db = db
+	return nil
+}
+
+/*
+Scan implements stats.Client.
+
+The cursor is the index of the next key in key name order, and the pattern is
+matched like path.Match does, which is close to the Redis glob syntax.
+*/
+func (c *Client) Scan(ctx context.Context, cursor uint64, match string, count int64) (uint64, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	_, names := c.record("SCAN")
+	if count <= 0 {
+		count = DefaultCount
+	}
+	if cursor > uint64(len(names)) {
+		return 0, nil, Error("ERR invalid cursor " + strconv.FormatUint(cursor, 10))
+	}
+	end := cursor + uint64(count)
+	next := end
+	if end >= uint64(len(names)) {
+		end, next = uint64(len(names)), 0
+	}
+	keys := []string{}
+	for _, name := range names[cursor:end] {
+		ok, err := path.Match(match, name)
+		if err != nil {
+			return 0, nil, Error("ERR invalid pattern: " + err.Error())
+		}
+		if ok {
+			keys = append(keys, name)
+		}
+	}
+	return next, keys, nil
+}
+
+/*
+MemoryUsage implements stats.Client.
+*/
+func (c *Client) MemoryUsage(ctx context.Context, keys []string) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	values, _ := c.record("MEMORY")
+	if c.MemoryUsageErr != nil {
+		return nil, c.MemoryUsageErr
+	}
+	sizes := make([]int64, len(keys))
+	for i, key := range keys {
+		sizes[i] = values[key]
+	}
+	return sizes, nil
+}
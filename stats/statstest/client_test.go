@@ -0,0 +1,82 @@
+package statstest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/fgm/drupal_redis_stats/stats"
+	"github.com/fgm/drupal_redis_stats/stats/statstest"
+)
+
+var _ stats.Client = (*statstest.Client)(nil)
+
+func TestClientScan(t *testing.T) {
+	keys := map[string]int64{"other:key": 1000}
+	for i := 0; i < 25; i++ {
+		keys[fmt.Sprintf("drupal.redis.10.1.0:render:%02d", i)] = 10
+	}
+	c := statstest.NewClient(keys)
+	c.Sections = map[string]string{"server": "redis_version:7.2.4\r\nredis_mode:standalone\r\n"}
+
+	var cs stats.CacheStats
+	if err := cs.Scan(c, 0, io.Discard); err != nil {
+		t.Fatalf("failed scanning: %v", err)
+	}
+	expected := stats.CacheStats{
+		TotalKeys: 26,
+		Stats:     map[string]stats.BinStats{"render": {Keys: 25, Size: 250}},
+		Server:    &stats.Server{Product: stats.ProductRedis, Version: "7.2.4", Mode: "standalone", MemoryUsage: true},
+	}
+	if !reflect.DeepEqual(cs, expected) {
+		t.Errorf("got %+v, expected %+v", cs, expected)
+	}
+	// 26 keys need 3 SCAN steps of 10, and MEMORY USAGE is called once per
+	// step, plus once for server detection.
+	expectedCommands := map[string]int{"DBSIZE": 1, "INFO": 1, "SCAN": 3, "MEMORY": 4}
+	if actual := c.Commands(); !reflect.DeepEqual(actual, expectedCommands) {
+		t.Errorf("got commands %v, expected %v", actual, expectedCommands)
+	}
+}
+
+func TestClientScanMaxPasses(t *testing.T) {
+	keys := make(map[string]int64)
+	for i := 0; i < 25; i++ {
+		keys[fmt.Sprintf("drupal.redis.10.1.0:page:%02d", i)] = 1
+	}
+	var cs stats.CacheStats
+	if err := cs.Scan(statstest.NewClient(keys), 2, io.Discard); err != nil {
+		t.Fatalf("failed scanning: %v", err)
+	}
+	if actual := cs.Stats["page"].Keys; actual != 20 {
+		t.Errorf("got %d keys, expected 20", actual)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := statstest.NewClient(map[string]int64{"drupal.redis.10.1.0:page:a": 1})
+	c.MemoryUsageErr = statstest.ErrUnsupported
+	ctx := context.Background()
+
+	if _, err := c.MemoryUsage(ctx, []string{"a"}); !stats.IsServerError(err) {
+		t.Errorf("expected a server error, got %v", err)
+	}
+	if _, err := c.Info(ctx, "server"); !stats.IsServerError(err) {
+		t.Errorf("expected a server error for a missing INFO section, got %v", err)
+	}
+	if err := c.Select(ctx, statstest.Databases); !stats.IsServerError(err) {
+		t.Errorf("expected a server error for an invalid database, got %v", err)
+	}
+	if _, _, err := c.Scan(ctx, 5, "*", 0); !stats.IsServerError(err) {
+		t.Errorf("expected a server error for an invalid cursor, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.DBSize(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+}
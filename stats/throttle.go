@@ -0,0 +1,201 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Back-off delay limits between SCAN batches.
+const (
+	minBackoff = 10 * time.Millisecond
+	maxBackoff = 2 * time.Second
+)
+
+// loadCheckInterval is the minimum interval between two INFO stats queries,
+// matching the sampling of instantaneous_ops_per_sec, and the interval at which
+// the load is checked again while paused.
+const loadCheckInterval = time.Second
+
+/*
+ThrottleOptions configures a Throttle. Zero values disable the matching feature.
+*/
+type ThrottleOptions struct {
+	// Rate is the maximum number of commands per second sent by the scan, each
+	// SCAN and each MEMORY USAGE counting as one.
+	Rate float64
+	// MaxLatency is the SCAN latency above which the scan backs off.
+	MaxLatency time.Duration
+	// BusyOps is the server instantaneous_ops_per_sec above which the scan backs off.
+	BusyOps int64
+	// PauseOps is the server instantaneous_ops_per_sec above which the scan pauses
+	// until the load decreases.
+	PauseOps int64
+}
+
+/*
+Throttle paces a scan to protect the server, combining:
+
+  - a token bucket limiting the rate of commands sent by the scan,
+  - an adaptive back-off, doubling a delay between batches while the server is
+    slow or busy, and halving it once it recovers,
+  - pauses while the server is overloaded.
+
+The server load is read from the instantaneous_ops_per_sec field of INFO stats,
+which includes the commands sent by the scan itself.
+
+A nil Throttle does not limit the scan. A Throttle is not safe for concurrent
+use, but can be used by successive scans, like those of several databases.
+*/
+type Throttle struct {
+	opts    ThrottleOptions
+	tokens  float64   // Negative when waiting for the tokens of a batch.
+	filled  time.Time // Last refill of the bucket.
+	backoff time.Duration
+	checked time.Time // Last INFO stats query.
+	ops     int64     // Last instantaneous_ops_per_sec.
+	started time.Time // Start of the SCAN in progress.
+	waited  time.Duration
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+/*
+NewThrottle returns a Throttle for the given options.
+*/
+func NewThrottle(opts ThrottleOptions) *Throttle {
+	return &Throttle{opts: opts, now: time.Now, sleep: sleep}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/*
+Waited returns the total time the scans waited because of the throttle.
+*/
+func (t *Throttle) Waited() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return t.waited
+}
+
+func (t *Throttle) wait(ctx context.Context, d time.Duration) error {
+	t.waited += d
+	return t.sleep(ctx, d)
+}
+
+// take waits until n commands can be sent within the rate limit. The bucket
+// holds at most one second worth of commands.
+func (t *Throttle) take(ctx context.Context, n int) error {
+	if t.opts.Rate <= 0 || n == 0 {
+		return nil
+	}
+	burst := t.opts.Rate
+	if burst < 1 {
+		burst = 1
+	}
+	now := t.now()
+	if t.filled.IsZero() {
+		t.tokens = burst
+	} else if t.tokens += now.Sub(t.filled).Seconds() * t.opts.Rate; t.tokens > burst {
+		t.tokens = burst
+	}
+	t.filled = now
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return nil
+	}
+	return t.wait(ctx, time.Duration(-t.tokens/t.opts.Rate*float64(time.Second)))
+}
+
+// checkLoad pauses while the server load is above PauseOps.
+func (t *Throttle) checkLoad(ctx context.Context, c Client) error {
+	if t.opts.BusyOps <= 0 && t.opts.PauseOps <= 0 {
+		return nil
+	}
+	for {
+		if now := t.now(); now.Sub(t.checked) >= loadCheckInterval {
+			ops, err := serverOps(context.WithoutCancel(ctx), c)
+			if err != nil {
+				return err
+			}
+			t.ops, t.checked = ops, now
+		}
+		if t.opts.PauseOps <= 0 || t.ops <= t.opts.PauseOps {
+			return nil
+		}
+		if err := t.wait(ctx, loadCheckInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// serverOps returns the instantaneous_ops_per_sec field of INFO stats.
+func serverOps(ctx context.Context, c Client) (int64, error) {
+	info, err := c.Info(ctx, "stats")
+	if err != nil {
+		return 0, fmt.Errorf("failed INFO stats: %w", err)
+	}
+	ops, err := strconv.ParseInt(parseInfo(info)["instantaneous_ops_per_sec"], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading instantaneous_ops_per_sec: %w", err)
+	}
+	return ops, nil
+}
+
+// beforeScan waits before a SCAN batch: while the server is overloaded, for the
+// back-off delay, then for the rate limit.
+func (t *Throttle) beforeScan(ctx context.Context, c Client) error {
+	if t == nil {
+		return nil
+	}
+	if err := t.checkLoad(ctx, c); err != nil {
+		return err
+	}
+	if t.backoff > 0 {
+		if err := t.wait(ctx, t.backoff); err != nil {
+			return err
+		}
+	}
+	if err := t.take(ctx, 1); err != nil {
+		return err
+	}
+	t.started = t.now()
+	return nil
+}
+
+// afterScan adapts the back-off delay to the SCAN latency and server load, then
+// waits for the rate limit of the MEMORY USAGE commands for the keys returned.
+func (t *Throttle) afterScan(ctx context.Context, keys int) error {
+	if t == nil {
+		return nil
+	}
+	slow := t.opts.MaxLatency > 0 && t.now().Sub(t.started) > t.opts.MaxLatency
+	busy := t.opts.BusyOps > 0 && t.ops > t.opts.BusyOps
+	switch {
+	case slow || busy:
+		t.backoff *= 2
+		if t.backoff < minBackoff {
+			t.backoff = minBackoff
+		} else if t.backoff > maxBackoff {
+			t.backoff = maxBackoff
+		}
+	case t.backoff > 0:
+		if t.backoff /= 2; t.backoff < minBackoff {
+			t.backoff = 0
+		}
+	}
+	return t.take(ctx, keys)
+}
@@ -0,0 +1,138 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeClock provides the time to a Throttle, advancing it on sleeps, and by
+// latency on each SCAN when used as an onScan callback.
+type fakeClock struct {
+	now     time.Time
+	latency time.Duration
+	sleeps  []time.Duration
+}
+
+func (fc *fakeClock) install(t *Throttle) *Throttle {
+	fc.now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.now = func() time.Time { return fc.now }
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		fc.sleeps = append(fc.sleeps, d)
+		fc.now = fc.now.Add(d)
+		return ctx.Err()
+	}
+	return t
+}
+
+func (fc *fakeClock) onScan(int) {
+	fc.now = fc.now.Add(fc.latency)
+}
+
+func renderKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "drupal.redis.10.1.0:render:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func TestThrottleRate(t *testing.T) {
+	var clock fakeClock
+	throttle := clock.install(NewThrottle(ThrottleOptions{Rate: 4}))
+	// Each batch is 1 SCAN and 1 MEMORY USAGE: the first two fit in the bucket.
+	cs := CacheStats{Throttle: throttle}
+	if err := cs.ScanContext(context.Background(), &batchClient{keys: renderKeys(4)}, 0, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []time.Duration{250 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Errorf("got sleeps %v, expected %v", clock.sleeps, expected)
+	}
+	if throttle.Waited() != time.Second {
+		t.Errorf("got %v waited, expected 1s", throttle.Waited())
+	}
+}
+
+func TestThrottleLatency(t *testing.T) {
+	var clock fakeClock
+	throttle := clock.install(NewThrottle(ThrottleOptions{MaxLatency: 50 * time.Millisecond}))
+	c := &batchClient{keys: renderKeys(8), onScan: func(batch int) {
+		// Slow for 3 batches, then fast again.
+		clock.latency = 100 * time.Millisecond
+		if batch >= 3 {
+			clock.latency = time.Millisecond
+		}
+		clock.onScan(batch)
+	}}
+	cs := CacheStats{Throttle: throttle}
+	if err := cs.ScanContext(context.Background(), c, 0, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ms := time.Millisecond
+	expected := []time.Duration{10 * ms, 20 * ms, 40 * ms, 20 * ms, 10 * ms}
+	if !reflect.DeepEqual(clock.sleeps, expected) {
+		t.Errorf("got sleeps %v, expected %v", clock.sleeps, expected)
+	}
+}
+
+func TestThrottleLoad(t *testing.T) {
+	checks := [...]struct {
+		name     string
+		opts     ThrottleOptions
+		ops      []int64
+		expected []time.Duration
+	}{
+		// The load is checked again after each pause, and then once per second.
+		{"pause", ThrottleOptions{PauseOps: 1000}, []int64{5000, 2000, 10, 10}, []time.Duration{time.Second, time.Second}},
+		// The busy load read before the first batch lasts until the fourth one.
+		{"busy", ThrottleOptions{BusyOps: 1000}, []int64{5000, 10}, []time.Duration{minBackoff, 2 * minBackoff, 4 * minBackoff}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			var clock fakeClock
+			throttle := clock.install(NewThrottle(check.opts))
+			// Batches last 400ms, so INFO stats is queried again on the fourth one.
+			clock.latency = 400 * time.Millisecond
+			c := &batchClient{keys: renderKeys(4), ops: check.ops, onScan: clock.onScan}
+			cs := CacheStats{Throttle: throttle}
+			if err := cs.ScanContext(context.Background(), c, 0, io.Discard); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(clock.sleeps, check.expected) {
+				t.Errorf("got sleeps %v, expected %v", clock.sleeps, check.expected)
+			}
+		})
+	}
+}
+
+func TestThrottleSad(t *testing.T) {
+	// INFO stats failures stop the scan.
+	var clock fakeClock
+	cs := CacheStats{Throttle: clock.install(NewThrottle(ThrottleOptions{PauseOps: 1}))}
+	if err := cs.ScanContext(context.Background(), &batchClient{keys: renderKeys(1)}, 0, io.Discard); err == nil || cs.Incomplete {
+		t.Errorf("got error %v and incomplete %t, expected a complete failure", err, cs.Incomplete)
+	}
+
+	// Interruptions while waiting leave the batch to be scanned again.
+	ctx, cancel := context.WithCancel(context.Background())
+	throttle := clock.install(NewThrottle(ThrottleOptions{Rate: 1}))
+	throttle.sleep = func(context.Context, time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+	cs = CacheStats{Throttle: throttle}
+	err := cs.ScanContext(ctx, &batchClient{keys: renderKeys(3)}, 0, io.Discard)
+	if !errors.Is(err, context.Canceled) || !cs.Incomplete || cs.Cursor != 0 || cs.ScannedKeys() != 0 {
+		t.Errorf("got error %v, incomplete %t, cursor %d, %d keys", err, cs.Incomplete, cs.Cursor, cs.ScannedKeys())
+	}
+
+	var nilThrottle *Throttle
+	if nilThrottle.Waited() != 0 {
+		t.Error("nil throttle waited")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// tlsOptions holds the TLS flags used to build the configuration for
+// rediss:// connections.
+type tlsOptions struct {
+	caFile     string // PEM bundle of CAs to verify the server, instead of the system ones.
+	certFile   string // PEM client certificate, for mutual TLS.
+	keyFile    string // PEM client key, for mutual TLS.
+	serverName string // Overrides the host name from the DSN for verification.
+	insecure   bool   // Skip server certificate verification.
+}
+
+// isSet reports whether any TLS flag was used.
+func (to tlsOptions) isSet() bool {
+	return to != tlsOptions{}
+}
+
+// config builds the TLS configuration from the options.
+func (to tlsOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         to.serverName,
+		InsecureSkipVerify: to.insecure, //nolint:gosec // Explicitly requested by the user.
+	}
+	if to.caFile != "" {
+		pem, err := os.ReadFile(to.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading CA bundle: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in CA bundle %s", to.caFile)
+		}
+	}
+	switch {
+	case to.certFile != "" && to.keyFile != "":
+		cert, err := tls.LoadX509KeyPair(to.certFile, to.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	case to.certFile != "" || to.keyFile != "":
+		return nil, errors.New("client certificate and key must be used together")
+	}
+	return cfg, nil
+}
+
+// getDialOptions builds the Redis connection options from the TLS flags,
+// which are only valid with a rediss:// DSN.
+func getDialOptions(dsn string, to tlsOptions) ([]redis.DialOption, error) {
+	if !to.isSet() {
+		return nil, nil
+	}
+	if u, err := url.Parse(dsn); err != nil || u.Scheme != "rediss" {
+		return nil, errors.New("TLS flags can only be used with a rediss:// DSN")
+	}
+	return to.dialOptions()
+}
+
+// dialOptions builds the Redis connection options from the TLS options. They
+// have no effect on connections not using TLS.
+func (to tlsOptions) dialOptions() ([]redis.DialOption, error) {
+	if !to.isSet() {
+		return nil, nil
+	}
+	cfg, err := to.config()
+	if err != nil {
+		return nil, err
+	}
+	return []redis.DialOption{redis.DialTLSConfig(cfg)}, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fgm/drupal_redis_stats/redistest"
+)
+
+// testCA is a locally generated certificate authority.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating CA key: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed creating CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue creates a leaf certificate for the given DNS name, returning it in PEM
+// format along with its private key.
+func (ca testCA) issue(t *testing.T, serial int64, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed encoding key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTLSServer starts a TLS server, requiring a client certificate if mutual is
+// true.
+func newTLSServer(t *testing.T, ca testCA, mutual bool) string {
+	certPEM, keyPEM := ca.issue(t, 2, "redis.test", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed loading server certificate: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if mutual {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = x509.NewCertPool()
+		cfg.ClientCAs.AddCert(ca.cert)
+	}
+	s := redistest.NewTLSServer(cfg)
+	t.Cleanup(s.Close)
+	return s.Addr
+}
+
+func writeTestFile(t *testing.T, dir, name string, contents []byte) string {
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, contents, 0o600); err != nil {
+		t.Fatalf("failed writing %s: %v", name, err)
+	}
+	return p
+}
+
+func TestOpenTLS(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	dir := t.TempDir()
+	caFile := writeTestFile(t, dir, "ca.pem", ca.pem)
+	otherCAFile := writeTestFile(t, dir, "other-ca.pem", otherCA.pem)
+	clientCert, clientKey := ca.issue(t, 3, "client", x509.ExtKeyUsageClientAuth)
+	certFile := writeTestFile(t, dir, "client.pem", clientCert)
+	keyFile := writeTestFile(t, dir, "client-key.pem", clientKey)
+
+	addr := newTLSServer(t, ca, false)
+	mutualAddr := newTLSServer(t, ca, true)
+
+	checks := [...]struct {
+		name     string
+		addr     string
+		opts     tlsOptions
+		expError bool
+	}{
+		{"custom CA", addr, tlsOptions{caFile: caFile, serverName: "redis.test"}, false},
+		{"wrong server name", addr, tlsOptions{caFile: caFile, serverName: "other.test"}, true},
+		{"wrong CA", addr, tlsOptions{caFile: otherCAFile, serverName: "redis.test"}, true},
+		{"insecure", addr, tlsOptions{insecure: true}, false},
+		{"mutual", mutualAddr, tlsOptions{caFile: caFile, serverName: "redis.test", certFile: certFile, keyFile: keyFile}, false},
+		{"mutual without client cert", mutualAddr, tlsOptions{caFile: caFile, serverName: "redis.test"}, true},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			dsn := "rediss://" + check.addr + "/0"
+			options, err := getDialOptions(dsn, check.opts)
+			if err != nil {
+				t.Fatalf("failed building dial options: %v", err)
+			}
+			c, _, err := open(dsn, "", "", options...)
+			if err == nil {
+				defer c.Close()
+				// With TLS 1.3, a rejected client certificate is only
+				// reported on the first exchange.
+				_, err = c.Do("PING")
+			}
+			if check.expError {
+				if err == nil {
+					t.Fatal("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetDialOptionsSad(t *testing.T) {
+	dir := t.TempDir()
+	garbage := writeTestFile(t, dir, "garbage.pem", []byte("not a certificate"))
+	checks := [...]struct {
+		name string
+		dsn  string
+		opts tlsOptions
+	}{
+		{"not rediss", "redis://localhost/0", tlsOptions{insecure: true}},
+		{"missing CA", "rediss://localhost/0", tlsOptions{caFile: filepath.Join(dir, "missing.pem")}},
+		{"invalid CA", "rediss://localhost/0", tlsOptions{caFile: garbage}},
+		{"cert without key", "rediss://localhost/0", tlsOptions{certFile: garbage}},
+		{"invalid key pair", "rediss://localhost/0", tlsOptions{certFile: garbage, keyFile: garbage}},
+	}
+	for _, check := range checks {
+		t.Run(check.name, func(t *testing.T) {
+			if _, err := getDialOptions(check.dsn, check.opts); err == nil {
+				t.Error("unexpected success")
+			}
+		})
+	}
+	if options, err := getDialOptions("redis://localhost/0", tlsOptions{}); err != nil || options != nil {
+		t.Errorf("got %v, %v for no TLS options, expected nil, nil", options, err)
+	}
+}
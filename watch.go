@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/morikuni/aec"
+
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/snapshot"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+// scanFunc performs a single scan of the Redis database, stopping when ctx is
+// done, with incomplete results.
+type scanFunc func(ctx context.Context) (stats.CacheStats, error)
+
+// watcher redraws the scan results in place on each refresh.
+type watcher struct {
+	w     io.Writer
+	opts  output.Options
+	lines uint              // The number of lines drawn by the previous refresh.
+	prev  snapshot.Snapshot // The previous scan, with a zero time before the first one.
+}
+
+// refresh replaces the previously drawn table by the one for the latest scan.
+func (wa *watcher) refresh(cur snapshot.Snapshot) error {
+	var buf bytes.Buffer
+	d := snapshot.Compare(wa.prev, cur)
+	if err := output.WatchText(&buf, &d, wa.opts); err != nil {
+		return err
+	}
+	if wa.lines > 0 {
+		if _, err := fmt.Fprint(wa.w, aec.Up(wa.lines).With(aec.EraseDisplay(aec.EraseModes.Tail))); err != nil {
+			return err
+		}
+	}
+	wa.lines = uint(bytes.Count(buf.Bytes(), []byte{'\n'}))
+	wa.prev = cur
+	_, err := wa.w.Write(buf.Bytes())
+	return err
+}
+
+// watch rescans the database on each interval, redrawing the results in place,
+// until the context is canceled, usually by Ctrl-C.
+func watch(ctx context.Context, w io.Writer, interval time.Duration, scan scanFunc, opts output.Options) error {
+	wa := watcher{w: w, opts: opts}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cs, err := scan(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = wa.refresh(snapshot.New(cs)); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
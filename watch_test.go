@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morikuni/aec"
+
+	"github.com/fgm/drupal_redis_stats/output"
+	"github.com/fgm/drupal_redis_stats/stats"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var scans uint32
+	scan := func(context.Context) (stats.CacheStats, error) {
+		scans++
+		if scans == 3 {
+			cancel()
+		}
+		return stats.CacheStats{
+			TotalKeys: scans,
+			Stats:     map[string]stats.BinStats{"render": {Keys: scans, Size: int64(scans) * 1000}},
+		}, nil
+	}
+	w := strings.Builder{}
+	if err := watch(ctx, &w, time.Millisecond, scan, output.Options{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scans != 3 {
+		t.Errorf("got %d scans, expected 3", scans)
+	}
+	actual := w.String()
+	// The third scan happened after cancellation, so only 2 tables were drawn.
+	if n := strings.Count(actual, "Scanned at"); n != 2 {
+		t.Errorf("got %d tables, expected 2:\n%s", n, actual)
+	}
+	redraw := aec.Up(6).With(aec.EraseDisplay(aec.EraseModes.Tail)).String()
+	if n := strings.Count(actual, redraw); n != 1 {
+		t.Errorf("got %d redraws, expected 1:\n%q", n, actual)
+	}
+	for _, expected := range []string{"render |    1 |         - |", "render |    2 |", "/s"} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("did not find %q in:\n%s", expected, actual)
+		}
+	}
+}
+
+func TestWatchSadScan(t *testing.T) {
+	errScan := errors.New("scan failed")
+	scan := func(context.Context) (stats.CacheStats, error) { return stats.CacheStats{}, errScan }
+	err := watch(context.Background(), &strings.Builder{}, time.Millisecond, scan, output.Options{})
+	if !errors.Is(err, errScan) {
+		t.Errorf("got %v, expected %v", err, errScan)
+	}
+}